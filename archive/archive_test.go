@@ -0,0 +1,268 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+func makeLogGroup(topic string, times ...uint32) *sls.LogGroup {
+	lg := &sls.LogGroup{
+		Topic:   proto.String(topic),
+		Source:  proto.String("10.0.0.1"),
+		LogTags: []*sls.LogTag{{Key: proto.String("host"), Value: proto.String("web-1")}},
+	}
+	for _, t := range times {
+		lg.Logs = append(lg.Logs, &sls.Log{
+			Time: proto.Uint32(t),
+			Contents: []*sls.LogContent{
+				{Key: proto.String("t"), Value: proto.String(strconv.Itoa(int(t)))},
+				{Key: proto.String("content"), Value: proto.String("some repeated content some repeated content")},
+			},
+		})
+	}
+	return lg
+}
+
+func readAll(t *testing.T, it *Iterator) []*sls.LogGroup {
+	var result []*sls.LogGroup
+	for {
+		lg, _, err := it.Next()
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		result = append(result, lg)
+	}
+}
+
+func logTimes(groups []*sls.LogGroup) []uint32 {
+	var times []uint32
+	for _, lg := range groups {
+		for _, log := range lg.Logs {
+			times = append(times, log.GetTime())
+		}
+	}
+	return times
+}
+
+func writeArchive(t *testing.T, compressType int, close bool) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, compressType)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	writes := []struct {
+		shard  int
+		cursor string
+		lg     *sls.LogGroup
+	}{
+		{0, "c0", makeLogGroup("a", 100, 101, 102)},
+		{1, "c1", makeLogGroup("b", 100, 105)},
+		{0, "c0", makeLogGroup("a", 103, 110)},
+		{1, "c2", makeLogGroup("b", 120)},
+		{0, "c3", makeLogGroup("a")},
+	}
+	for _, write := range writes {
+		if err := w.Write(write.shard, write.cursor, write.lg); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if close {
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	} else if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriteRead(t *testing.T) {
+	for _, compressType := range []int{sls.Compress_LZ4, sls.Compress_ZSTD, sls.Compress_None} {
+		data := writeArchive(t, compressType, true)
+		r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("%d. NewReader() error = %v", compressType, err)
+		}
+		if got := len(r.Entries()); got != 4 {
+			t.Errorf("%d. Entries() = %v, want 4", compressType, got)
+		}
+		if got := r.Shards(); !reflect.DeepEqual(got, []int{0, 1}) {
+			t.Errorf("%d. Shards() = %v, want [0 1]", compressType, got)
+		}
+		groups := readAll(t, r.Iterator(nil))
+		if got := logTimes(groups); !reflect.DeepEqual(got, []uint32{100, 101, 102, 100, 105, 103, 110, 120}) {
+			t.Errorf("%d. log times = %v", compressType, got)
+		}
+		lg := groups[1]
+		if lg.GetTopic() != "b" || lg.GetSource() != "10.0.0.1" || lg.LogTags[0].GetValue() != "web-1" {
+			t.Errorf("%d. group metadata = %v", compressType, lg)
+		}
+		if got := lg.Logs[1].Contents[0].GetValue(); got != "105" {
+			t.Errorf("%d. log content = %v, want 105", compressType, got)
+		}
+	}
+}
+
+func TestIteratorFilter(t *testing.T) {
+	data := writeArchive(t, sls.Compress_LZ4, true)
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	tests := []struct {
+		name   string
+		filter *Filter
+		seek   int64
+		want   []uint32
+	}{
+		{"time range", &Filter{From: 102, To: 110}, 0, []uint32{102, 105, 103}},
+		{"shard", &Filter{Shards: []int{1}}, 0, []uint32{100, 105, 120}},
+		{"shard and time", &Filter{Shards: []int{0}, From: 105}, 0, []uint32{110}},
+		{"seek", nil, 106, []uint32{103, 110, 120}},
+		{"none", &Filter{From: 200}, 0, nil},
+	}
+	for _, tt := range tests {
+		it := r.Iterator(tt.filter)
+		if tt.seek > 0 {
+			it.SeekTime(tt.seek)
+		}
+		if got := logTimes(readAll(t, it)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. log times = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	data := writeArchive(t, sls.Compress_ZSTD, false)
+	if _, err := NewReader(bytes.NewReader(data), int64(len(data))); err != ErrNoIndex {
+		t.Fatalf("NewReader() error = %v, want ErrNoIndex", err)
+	}
+	// a crash in the middle of the last block
+	data = data[:len(data)-3]
+	path := filepath.Join(t.TempDir(), "crashed.slsarc")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer r.Close()
+	if got := logTimes(readAll(t, r.Iterator(nil))); !reflect.DeepEqual(got, []uint32{100, 101, 102, 100, 105, 103, 110}) {
+		t.Errorf("recovered log times = %v", got)
+	}
+	if got := r.Entries()[2].Cursor; got != "c0" {
+		t.Errorf("recovered cursor = %v, want c0", got)
+	}
+}
+
+func TestInvalidArchive(t *testing.T) {
+	data := writeArchive(t, sls.Compress_LZ4, true)
+	data[len(fileMagic)+blockHeaderSize+5] ^= 0xff
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	if _, err := r.Read(r.Entries()[0]); err != ErrInvalidArchive {
+		t.Errorf("Read() error = %v, want ErrInvalidArchive", err)
+	}
+	if _, err := NewReader(bytes.NewReader([]byte("not an archive")), 14); err != ErrInvalidArchive {
+		t.Errorf("NewReader() error = %v, want ErrInvalidArchive", err)
+	}
+}
+
+type fakeClient struct {
+	sls.ClientInterface
+	posted []*sls.PostLogStoreLogsRequest
+	fail   int
+	err    error
+	calls  int
+}
+
+func (c *fakeClient) PostLogStoreLogsV2(project, logstore string, req *sls.PostLogStoreLogsRequest) error {
+	c.calls++
+	if c.err != nil {
+		return c.err
+	}
+	if c.fail > 0 {
+		c.fail--
+		return &sls.Error{HTTPCode: 500, Code: "InternalServerError"}
+	}
+	c.posted = append(c.posted, req)
+	return nil
+}
+
+func TestReplay(t *testing.T) {
+	data := writeArchive(t, sls.Compress_LZ4, true)
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	client := &fakeClient{fail: 1}
+	result, err := Replay(context.Background(), client, "p", "l", r, &ReplayOptions{Filter: &Filter{Shards: []int{1}}})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.LogGroupCount != 2 || result.LogCount != 3 {
+		t.Errorf("Replay() = %+v, want 2 groups 3 logs", result)
+	}
+	if len(client.posted) != 2 {
+		t.Fatalf("posted %d groups, want 2", len(client.posted))
+	}
+	lg := client.posted[0].LogGroup
+	if lg.GetTopic() != "b" || lg.GetSource() != "10.0.0.1" || len(lg.LogTags) != 1 {
+		t.Errorf("posted group metadata = %v", lg)
+	}
+}
+
+func TestReplayPermanentError(t *testing.T) {
+	data := writeArchive(t, sls.Compress_LZ4, true)
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	client := &fakeClient{err: &sls.Error{HTTPCode: 404, Code: "LogStoreNotExist"}}
+	start := time.Now()
+	_, err = Replay(context.Background(), client, "p", "l", r, nil)
+	var slsErr *sls.Error
+	if !errors.As(err, &slsErr) || slsErr.Code != "LogStoreNotExist" {
+		t.Errorf("Replay() error = %v, want LogStoreNotExist", err)
+	}
+	if client.calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("Replay() posted %d times in %v, want no retry", client.calls, time.Since(start))
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&sls.Error{HTTPCode: 500, Code: "InternalServerError"}, true},
+		{&sls.Error{HTTPCode: 429, Code: "TooManyRequests"}, true},
+		{&sls.Error{HTTPCode: 403, Code: sls.WRITE_QUOTA_EXCEED}, true},
+		{&sls.Error{HTTPCode: 401, Code: "Unauthorized"}, false},
+		{&sls.Error{HTTPCode: 400, Code: "InvalidParameter"}, false},
+		{&sls.Error{HTTPCode: 404, Code: "LogStoreNotExist"}, false},
+		{errors.New("connection reset"), true},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Package archive implements a seekable local file format for LogGroup data,
+// used to back up a logstore or to replay data in tests.
+//
+// An archive file starts with an 8 byte magic, followed by blocks, each of
+// which holds one compressed LogGroup protobuf:
+//
+//	payloadLen uint32 | rawLen uint32 | compressType uint8 | shardID int32 |
+//	minTime uint32 | maxTime uint32 | logCount uint32 | cursorLen uint16 |
+//	cursor | payload | crc32(payload) uint32
+//
+// The file ends with an index of every block and a fixed size trailer:
+//
+//	index entries... | indexOffset uint64 | indexLen uint32 | crc32(index) uint32 | magic
+//
+// Each block header carries its own index entry, so the index of a file that
+// was not closed can be rebuilt by scanning the blocks.
+// All integers are little endian.
+package archive
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+const (
+	fileMagic    = "SLSARC01"
+	trailerMagic = "SLSARCIX"
+
+	// payloadLen, rawLen, compressType, shardID, minTime, maxTime, logCount, cursorLen
+	blockHeaderSize = 4 + 4 + 1 + 4 + 4 + 4 + 4 + 2
+	// offset, shardID, minTime, maxTime, logCount, cursorLen
+	entryHeaderSize = 8 + 4 + 4 + 4 + 4 + 2
+	trailerSize     = 8 + 4 + 4 + len(trailerMagic)
+
+	maxCursorLen = 1<<16 - 1
+)
+
+var (
+	// ErrInvalidArchive is returned when the file is not an archive or is corrupted.
+	ErrInvalidArchive = errors.New("archive: invalid archive file")
+	// ErrNoIndex is returned by Open when the file has no index, usually because the writer was not closed.
+	ErrNoIndex = errors.New("archive: index not found")
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Entry is the index entry of one LogGroup.
+type Entry struct {
+	// Offset is the position of the block in the file
+	Offset  int64
+	ShardID int
+	// MinTime and MaxTime are the range of log time in the group, in unix seconds
+	MinTime  uint32
+	MaxTime  uint32
+	LogCount int
+	// Cursor is the cursor that the group was pulled from, it may be shared by
+	// the groups of one pull
+	Cursor string
+}
+
+// overlaps reports whether the entry may contain logs in [from, to).
+func (e *Entry) overlaps(from, to int64) bool {
+	if to > 0 && int64(e.MinTime) >= to {
+		return false
+	}
+	return int64(e.MaxTime) >= from
+}
+
+func appendEntry(buf []byte, e *Entry) []byte {
+	var header [entryHeaderSize]byte
+	binary.LittleEndian.PutUint64(header[0:], uint64(e.Offset))
+	binary.LittleEndian.PutUint32(header[8:], uint32(int32(e.ShardID)))
+	binary.LittleEndian.PutUint32(header[12:], e.MinTime)
+	binary.LittleEndian.PutUint32(header[16:], e.MaxTime)
+	binary.LittleEndian.PutUint32(header[20:], uint32(e.LogCount))
+	binary.LittleEndian.PutUint16(header[24:], uint16(len(e.Cursor)))
+	buf = append(buf, header[:]...)
+	return append(buf, e.Cursor...)
+}
+
+func decodeEntries(buf []byte) ([]Entry, error) {
+	var entries []Entry
+	for len(buf) > 0 {
+		if len(buf) < entryHeaderSize {
+			return nil, ErrInvalidArchive
+		}
+		cursorLen := int(binary.LittleEndian.Uint16(buf[24:]))
+		if len(buf) < entryHeaderSize+cursorLen {
+			return nil, ErrInvalidArchive
+		}
+		entries = append(entries, Entry{
+			Offset:   int64(binary.LittleEndian.Uint64(buf[0:])),
+			ShardID:  int(int32(binary.LittleEndian.Uint32(buf[8:]))),
+			MinTime:  binary.LittleEndian.Uint32(buf[12:]),
+			MaxTime:  binary.LittleEndian.Uint32(buf[16:]),
+			LogCount: int(binary.LittleEndian.Uint32(buf[20:])),
+			Cursor:   string(buf[entryHeaderSize : entryHeaderSize+cursorLen]),
+		})
+		buf = buf[entryHeaderSize+cursorLen:]
+	}
+	return entries, nil
+}
+
+// blockHeader is the decoded fixed part of a block.
+type blockHeader struct {
+	payloadLen   int
+	rawLen       int
+	compressType int
+	cursorLen    int
+}
+
+func encodeBlockHeader(e *Entry, payloadLen, rawLen, compressType int) []byte {
+	header := make([]byte, blockHeaderSize, blockHeaderSize+len(e.Cursor))
+	binary.LittleEndian.PutUint32(header[0:], uint32(payloadLen))
+	binary.LittleEndian.PutUint32(header[4:], uint32(rawLen))
+	header[8] = byte(compressType)
+	binary.LittleEndian.PutUint32(header[9:], uint32(int32(e.ShardID)))
+	binary.LittleEndian.PutUint32(header[13:], e.MinTime)
+	binary.LittleEndian.PutUint32(header[17:], e.MaxTime)
+	binary.LittleEndian.PutUint32(header[21:], uint32(e.LogCount))
+	binary.LittleEndian.PutUint16(header[25:], uint16(len(e.Cursor)))
+	return append(header, e.Cursor...)
+}
+
+// decodeBlockHeader decodes the fixed part of a block header into h and the
+// entry fields except Offset and Cursor.
+func decodeBlockHeader(buf []byte, h *blockHeader, e *Entry) {
+	h.payloadLen = int(binary.LittleEndian.Uint32(buf[0:]))
+	h.rawLen = int(binary.LittleEndian.Uint32(buf[4:]))
+	h.compressType = int(buf[8])
+	e.ShardID = int(int32(binary.LittleEndian.Uint32(buf[9:])))
+	e.MinTime = binary.LittleEndian.Uint32(buf[13:])
+	e.MaxTime = binary.LittleEndian.Uint32(buf[17:])
+	e.LogCount = int(binary.LittleEndian.Uint32(buf[21:]))
+	h.cursorLen = int(binary.LittleEndian.Uint16(buf[25:]))
+}
+
+// compress returns the payload and the compress type actually used, data
+// that lz4 can not compress is stored uncompressed.
+func compress(raw []byte, compressType int) ([]byte, int, error) {
+	switch compressType {
+	case sls.Compress_None:
+		return raw, sls.Compress_None, nil
+	case sls.Compress_LZ4:
+		out := make([]byte, lz4.CompressBlockBound(len(raw)))
+		var hashTable [1 << 16]int
+		n, err := lz4.CompressBlock(raw, out, hashTable[:])
+		if err != nil {
+			return nil, 0, err
+		}
+		if n == 0 {
+			return raw, sls.Compress_None, nil
+		}
+		return out[:n], sls.Compress_LZ4, nil
+	case sls.Compress_ZSTD:
+		return zstdEncoder.EncodeAll(raw, nil), sls.Compress_ZSTD, nil
+	}
+	return nil, 0, sls.InvalidCompressError
+}
+
+func decompress(payload []byte, rawLen, compressType int) ([]byte, error) {
+	switch compressType {
+	case sls.Compress_None:
+		return payload, nil
+	case sls.Compress_LZ4:
+		out := make([]byte, rawLen)
+		n, err := lz4.UncompressBlock(payload, out)
+		if err != nil {
+			return nil, err
+		}
+		if n != rawLen {
+			return nil, fmt.Errorf("archive: lz4 raw size %d, want %d", n, rawLen)
+		}
+		return out, nil
+	case sls.Compress_ZSTD:
+		return zstdDecoder.DecodeAll(payload, make([]byte, 0, rawLen))
+	}
+	return nil, sls.InvalidCompressError
+}
+
+func checksum(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data)
+}
+
+// timeRange returns the min and max log time of the group.
+func timeRange(lg *sls.LogGroup) (uint32, uint32) {
+	var min, max uint32
+	for i, log := range lg.Logs {
+		t := log.GetTime()
+		if i == 0 || t < min {
+			min = t
+		}
+		if t > max {
+			max = t
+		}
+	}
+	return min, max
+}
@@ -0,0 +1,281 @@
+package archive
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+// Reader reads an archive by its index. It is safe for concurrent use if r is.
+type Reader struct {
+	r       io.ReaderAt
+	closer  io.Closer
+	entries []Entry
+}
+
+// NewReader reads the index of an archive of the given size.
+// It returns ErrNoIndex if the archive was not closed, use Recover in that case.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	if err := checkMagic(r, size); err != nil {
+		return nil, err
+	}
+	if size < int64(len(fileMagic)+trailerSize) {
+		return nil, ErrNoIndex
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := r.ReadAt(trailer, size-int64(trailerSize)); err != nil {
+		return nil, err
+	}
+	if string(trailer[16:]) != trailerMagic {
+		return nil, ErrNoIndex
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(trailer[0:]))
+	indexLen := int64(binary.LittleEndian.Uint32(trailer[8:]))
+	if indexOffset < int64(len(fileMagic)) || indexOffset+indexLen != size-int64(trailerSize) {
+		return nil, ErrInvalidArchive
+	}
+	index := make([]byte, indexLen)
+	if _, err := r.ReadAt(index, indexOffset); err != nil {
+		return nil, err
+	}
+	if checksum(index) != binary.LittleEndian.Uint32(trailer[12:]) {
+		return nil, ErrInvalidArchive
+	}
+	entries, err := decodeEntries(index)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, entries: entries}, nil
+}
+
+// Recover rebuilds the index by scanning the blocks, it is used to read an
+// archive whose writer crashed before Close. A truncated last block is ignored.
+func Recover(r io.ReaderAt, size int64) (*Reader, error) {
+	if err := checkMagic(r, size); err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	offset := int64(len(fileMagic))
+	header := make([]byte, blockHeaderSize)
+	for offset+int64(blockHeaderSize) <= size {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return nil, err
+		}
+		var h blockHeader
+		e := Entry{Offset: offset}
+		decodeBlockHeader(header, &h, &e)
+		end := offset + int64(blockHeaderSize+h.cursorLen+h.payloadLen+4)
+		if end > size || h.compressType >= sls.Compress_Max {
+			break
+		}
+		block, err := readBlock(r, offset, size)
+		if err != nil {
+			// the index of a closed archive, or a corrupted block
+			break
+		}
+		e.Cursor = block.cursor
+		entries = append(entries, e)
+		offset = end
+	}
+	return &Reader{r: r, entries: entries}, nil
+}
+
+// Open opens an archive file, if it has no index the index is recovered by scanning.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	reader, err := NewReader(f, stat.Size())
+	if err == ErrNoIndex {
+		reader, err = Recover(f, stat.Size())
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	reader.closer = f
+	return reader, nil
+}
+
+// Close closes the file opened by Open.
+func (r *Reader) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+func checkMagic(r io.ReaderAt, size int64) error {
+	if size < int64(len(fileMagic)) {
+		return ErrInvalidArchive
+	}
+	magic := make([]byte, len(fileMagic))
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return err
+	}
+	if string(magic) != fileMagic {
+		return ErrInvalidArchive
+	}
+	return nil
+}
+
+// Entries returns the index entries in written order.
+func (r *Reader) Entries() []Entry {
+	return r.entries
+}
+
+// Shards returns the sorted shard ids in the archive.
+func (r *Reader) Shards() []int {
+	seen := map[int]bool{}
+	var shards []int
+	for _, e := range r.entries {
+		if !seen[e.ShardID] {
+			seen[e.ShardID] = true
+			shards = append(shards, e.ShardID)
+		}
+	}
+	sort.Ints(shards)
+	return shards
+}
+
+type block struct {
+	cursor string
+	raw    []byte
+}
+
+func readBlock(r io.ReaderAt, offset, size int64) (*block, error) {
+	header := make([]byte, blockHeaderSize)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return nil, err
+	}
+	var h blockHeader
+	var e Entry
+	decodeBlockHeader(header, &h, &e)
+	bodyLen := int64(h.cursorLen + h.payloadLen + 4)
+	if size >= 0 && offset+int64(blockHeaderSize)+bodyLen > size {
+		return nil, ErrInvalidArchive
+	}
+	body := make([]byte, bodyLen)
+	if _, err := r.ReadAt(body, offset+int64(blockHeaderSize)); err != nil {
+		return nil, err
+	}
+	payload := body[h.cursorLen : h.cursorLen+h.payloadLen]
+	if checksum(payload) != binary.LittleEndian.Uint32(body[h.cursorLen+h.payloadLen:]) {
+		return nil, ErrInvalidArchive
+	}
+	raw, err := decompress(payload, h.rawLen, h.compressType)
+	if err != nil {
+		return nil, err
+	}
+	return &block{cursor: string(body[:h.cursorLen]), raw: raw}, nil
+}
+
+// Read reads the LogGroup of an index entry.
+func (r *Reader) Read(e Entry) (*sls.LogGroup, error) {
+	b, err := readBlock(r.r, e.Offset, -1)
+	if err != nil {
+		return nil, err
+	}
+	lg := &sls.LogGroup{}
+	if err := proto.Unmarshal(b.raw, lg); err != nil {
+		return nil, err
+	}
+	return lg, nil
+}
+
+// Filter selects the data returned by an Iterator.
+type Filter struct {
+	// Shards limits the shards to read, nil means all shards
+	Shards []int
+	// From and To select logs with time in [From, To), in unix seconds. To <= 0 means no upper bound
+	From int64
+	To   int64
+}
+
+func (f *Filter) matchShard(shardID int) bool {
+	if f.Shards == nil {
+		return true
+	}
+	for _, s := range f.Shards {
+		if s == shardID {
+			return true
+		}
+	}
+	return false
+}
+
+// Iterator reads LogGroups in written order.
+type Iterator struct {
+	reader  *Reader
+	filter  Filter
+	entries []Entry
+	pos     int
+}
+
+// Iterator returns an iterator of the groups matching filter, filter may be nil.
+// Blocks outside of the time range are skipped by the index without being read,
+// and logs outside of the range are removed from the returned groups.
+func (r *Reader) Iterator(filter *Filter) *Iterator {
+	it := &Iterator{reader: r}
+	if filter != nil {
+		it.filter = *filter
+	}
+	for _, e := range r.entries {
+		if it.filter.matchShard(e.ShardID) && e.overlaps(it.filter.From, it.filter.To) {
+			it.entries = append(it.entries, e)
+		}
+	}
+	return it
+}
+
+// SeekTime moves the iterator to the first group that has logs at or after t.
+func (it *Iterator) SeekTime(t int64) {
+	it.pos = len(it.entries)
+	for i, e := range it.entries {
+		if int64(e.MaxTime) >= t {
+			it.pos = i
+			return
+		}
+	}
+}
+
+// Next returns the next group and its index entry, or io.EOF at the end.
+func (it *Iterator) Next() (*sls.LogGroup, Entry, error) {
+	for it.pos < len(it.entries) {
+		e := it.entries[it.pos]
+		it.pos++
+		lg, err := it.reader.Read(e)
+		if err != nil {
+			return nil, e, err
+		}
+		if int64(e.MinTime) < it.filter.From || (it.filter.To > 0 && int64(e.MaxTime) >= it.filter.To) {
+			lg.Logs = filterLogs(lg.Logs, it.filter.From, it.filter.To)
+			if len(lg.Logs) == 0 {
+				continue
+			}
+		}
+		return lg, e, nil
+	}
+	return nil, Entry{}, io.EOF
+}
+
+func filterLogs(logs []*sls.Log, from, to int64) []*sls.Log {
+	result := logs[:0]
+	for _, log := range logs {
+		t := int64(log.GetTime())
+		if t >= from && (to <= 0 || t < to) {
+			result = append(result, log)
+		}
+	}
+	return result
+}
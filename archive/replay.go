@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/cenkalti/backoff"
+)
+
+// ReplayOptions configures Replay.
+type ReplayOptions struct {
+	// Filter selects the data to replay, nil means all
+	Filter *Filter
+	// CompressType of the post requests, default lz4
+	CompressType int
+	// MaxRetryElapsedTime is the max time to retry one failed post, default 5 minutes
+	MaxRetryElapsedTime time.Duration
+	// Progress is called after every posted group if it is not nil
+	Progress func(e Entry)
+}
+
+// ReplayResult is the statistics of a replay.
+type ReplayResult struct {
+	LogGroupCount int
+	LogCount      int
+}
+
+// Replay writes the archived groups into a logstore with PostLogStoreLogsV2,
+// in written order. Topic, source and log tags of every group are kept, and
+// logs keep their original time, so the target logstore should accept data
+// of that time range.
+func Replay(ctx context.Context, client sls.ClientInterface, project, logstore string, reader *Reader, options *ReplayOptions) (*ReplayResult, error) {
+	if options == nil {
+		options = &ReplayOptions{}
+	}
+	maxElapsedTime := options.MaxRetryElapsedTime
+	if maxElapsedTime <= 0 {
+		maxElapsedTime = 5 * time.Minute
+	}
+	result := &ReplayResult{}
+	it := reader.Iterator(options.Filter)
+	for {
+		lg, entry, err := it.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		b := backoff.NewExponentialBackOff()
+		b.MaxElapsedTime = maxElapsedTime
+		err = sls.RetryWithCondition(ctx, b, func() (bool, error) {
+			err := client.PostLogStoreLogsV2(project, logstore, &sls.PostLogStoreLogsRequest{
+				LogGroup:     lg,
+				CompressType: options.CompressType,
+			})
+			return err != nil && retryable(err), err
+		})
+		if err != nil {
+			return result, err
+		}
+		result.LogGroupCount++
+		result.LogCount += len(lg.Logs)
+		if options.Progress != nil {
+			options.Progress(entry)
+		}
+	}
+}
+
+// retryable reports whether a failed post may succeed later, the client errors
+// other than throttling and the write quota, eg. LogStoreNotExist, are permanent
+func retryable(err error) bool {
+	var slsErr *sls.Error
+	if errors.As(err, &slsErr) && slsErr.HTTPCode >= 400 && slsErr.HTTPCode < 500 {
+		return slsErr.HTTPCode == 429 || slsErr.Code == sls.WRITE_QUOTA_EXCEED || slsErr.Code == sls.SHARD_WRITE_QUOTA_EXCEED
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+// Writer appends LogGroups to an archive. It is not safe for concurrent use.
+type Writer struct {
+	w            *bufio.Writer
+	closer       io.Closer
+	compressType int
+	offset       int64
+	entries      []Entry
+	closed       bool
+}
+
+// NewWriter creates a Writer on w, compressType is one of sls.Compress_LZ4,
+// sls.Compress_ZSTD and sls.Compress_None. Close must be called to write the index,
+// it does not close w.
+func NewWriter(w io.Writer, compressType int) (*Writer, error) {
+	if compressType < 0 || compressType >= sls.Compress_Max {
+		return nil, sls.InvalidCompressError
+	}
+	writer := &Writer{w: bufio.NewWriter(w), compressType: compressType}
+	if err := writer.write([]byte(fileMagic)); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Create creates or truncates the named file and returns a Writer on it.
+// Close closes the file.
+func Create(path string, compressType int) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f, compressType)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.closer = f
+	return w, nil
+}
+
+func (w *Writer) write(data []byte) error {
+	n, err := w.w.Write(data)
+	w.offset += int64(n)
+	return err
+}
+
+// Write appends one LogGroup pulled from cursor of the shard.
+// Empty groups are skipped.
+func (w *Writer) Write(shardID int, cursor string, lg *sls.LogGroup) error {
+	if w.closed {
+		return errors.New("archive: write to closed writer")
+	}
+	if len(lg.Logs) == 0 {
+		return nil
+	}
+	if len(cursor) > maxCursorLen {
+		return fmt.Errorf("archive: cursor too long: %d", len(cursor))
+	}
+	raw, err := proto.Marshal(lg)
+	if err != nil {
+		return err
+	}
+	payload, compressType, err := compress(raw, w.compressType)
+	if err != nil {
+		return err
+	}
+	entry := Entry{
+		Offset:   w.offset,
+		ShardID:  shardID,
+		LogCount: len(lg.Logs),
+		Cursor:   cursor,
+	}
+	entry.MinTime, entry.MaxTime = timeRange(lg)
+
+	if err := w.write(encodeBlockHeader(&entry, len(payload), len(raw), compressType)); err != nil {
+		return err
+	}
+	if err := w.write(payload); err != nil {
+		return err
+	}
+	var crc [4]byte
+	binary.LittleEndian.PutUint32(crc[:], checksum(payload))
+	if err := w.write(crc[:]); err != nil {
+		return err
+	}
+	w.entries = append(w.entries, entry)
+	return nil
+}
+
+// WriteLogGroupList appends all groups of a pull result, such as the one passed to a consumer processor.
+func (w *Writer) WriteLogGroupList(shardID int, cursor string, logGroupList *sls.LogGroupList) error {
+	for _, lg := range logGroupList.LogGroups {
+		if err := w.Write(shardID, cursor, lg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes buffered blocks to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Entries returns the index entries written so far.
+func (w *Writer) Entries() []Entry {
+	return w.entries
+}
+
+// Close writes the index and the trailer. If the Writer was created by Create, the file is closed.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.writeIndex()
+	if w.closer != nil {
+		if closeErr := w.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (w *Writer) writeIndex() error {
+	var index []byte
+	for i := range w.entries {
+		index = appendEntry(index, &w.entries[i])
+	}
+	indexOffset := w.offset
+	if err := w.write(index); err != nil {
+		return err
+	}
+	trailer := make([]byte, trailerSize)
+	binary.LittleEndian.PutUint64(trailer[0:], uint64(indexOffset))
+	binary.LittleEndian.PutUint32(trailer[8:], uint32(len(index)))
+	binary.LittleEndian.PutUint32(trailer[12:], checksum(index))
+	copy(trailer[16:], trailerMagic)
+	if err := w.write(trailer); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
@@ -0,0 +1,261 @@
+// Package autoscale splits hot shards and merges cold adjacent shards of a
+// logstore based on their write rates.
+package autoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	ActionSplit = "split"
+	ActionMerge = "merge"
+)
+
+// Config is the configuration of a Controller.
+type Config struct {
+	Project  string
+	Logstore string
+	// MinShardCount and MaxShardCount bound the number of readwrite shards, default 1 and 64
+	MinShardCount int
+	MaxShardCount int
+	// SplitThreshold is the write rate in bytes per second above which a shard is split, default 4MB/s
+	SplitThreshold float64
+	// MergeThreshold is the total write rate in bytes per second of two adjacent
+	// shards below which they are merged, default 1MB/s. It must be less than SplitThreshold.
+	MergeThreshold float64
+	// SplitNum is the number of shards a hot shard is split into, default 2
+	SplitNum int
+	// Interval between two evaluations of Run, default 1 minute
+	Interval time.Duration
+	// Cooldown is the min time after a split or merge before the next one, so
+	// that the metrics reflect the new shards, default 10 minutes
+	Cooldown time.Duration
+	// DryRun only logs and returns the actions without executing them
+	DryRun bool
+	// Logger defaults to sls.Logger
+	Logger log.Logger
+}
+
+// Action is a split or a merge decided by the controller.
+type Action struct {
+	Kind    string
+	ShardID int
+	// SplitNum is the number of new shards of a split
+	SplitNum int
+	// MergeWith is the right neighbor merged into ShardID
+	MergeWith int
+	// Rate is the write rate of the shard, or of both shards of a merge
+	Rate   float64
+	DryRun bool
+}
+
+func (a Action) String() string {
+	if a.Kind == ActionMerge {
+		return fmt.Sprintf("merge shard %d and %d, rate %.0f B/s", a.ShardID, a.MergeWith, a.Rate)
+	}
+	return fmt.Sprintf("split shard %d into %d, rate %.0f B/s", a.ShardID, a.SplitNum, a.Rate)
+}
+
+// Controller scales the shards of one logstore.
+type Controller struct {
+	client     sls.ClientInterface
+	source     MetricsSource
+	config     Config
+	logger     log.Logger
+	now        func() time.Time
+	mutex      sync.Mutex
+	lastAction time.Time
+}
+
+// NewController creates a Controller, config is copied.
+func NewController(client sls.ClientInterface, source MetricsSource, config *Config) (*Controller, error) {
+	c := *config
+	if c.Project == "" || c.Logstore == "" {
+		return nil, errors.New("autoscale: project and logstore are required")
+	}
+	if c.MinShardCount <= 0 {
+		c.MinShardCount = 1
+	}
+	if c.MaxShardCount <= 0 {
+		c.MaxShardCount = 64
+	}
+	if c.MinShardCount > c.MaxShardCount {
+		return nil, fmt.Errorf("autoscale: MinShardCount %d is greater than MaxShardCount %d", c.MinShardCount, c.MaxShardCount)
+	}
+	if c.SplitThreshold <= 0 {
+		c.SplitThreshold = 4 * 1024 * 1024
+	}
+	if c.MergeThreshold <= 0 {
+		c.MergeThreshold = 1024 * 1024
+	}
+	if c.MergeThreshold >= c.SplitThreshold {
+		return nil, errors.New("autoscale: MergeThreshold must be less than SplitThreshold")
+	}
+	if c.SplitNum < 2 {
+		c.SplitNum = 2
+	}
+	if c.Interval <= 0 {
+		c.Interval = time.Minute
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = 10 * time.Minute
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = sls.Logger
+	}
+	return &Controller{
+		client: client,
+		source: source,
+		config: c,
+		logger: log.With(logger, "project", c.Project, "logstore", c.Logstore),
+		now:    time.Now,
+	}, nil
+}
+
+// Run evaluates the shards every Interval until ctx is canceled.
+// Errors of one evaluation are logged and do not stop the controller.
+func (c *Controller) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.Step(ctx); err != nil {
+			level.Warn(c.logger).Log("msg", "autoscale step failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Step evaluates the shards once and executes the actions, unless in dry-run
+// mode or cooling down. Splits of hot shards take priority over merges.
+func (c *Controller) Step(ctx context.Context) ([]Action, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.config.DryRun && !c.lastAction.IsZero() && c.now().Sub(c.lastAction) < c.config.Cooldown {
+		level.Debug(c.logger).Log("msg", "autoscale is cooling down", "lastAction", c.lastAction)
+		return nil, nil
+	}
+	topology, err := sls.LoadShardTopology(c.client, c.config.Project, c.config.Logstore)
+	if err != nil {
+		return nil, err
+	}
+	if issues := topology.Check(); len(issues) > 0 {
+		// a split or merge is in progress, or the shards were changed by someone else
+		return nil, fmt.Errorf("autoscale: inconsistent shards: %v", issues)
+	}
+	rates, err := c.source.ShardWriteRates(ctx, c.config.Project, c.config.Logstore)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := c.plan(topology.ActiveShards(), rates)
+	var executed []Action
+	for _, action := range actions {
+		action.DryRun = c.config.DryRun
+		if c.config.DryRun {
+			level.Info(c.logger).Log("msg", "autoscale dry run", "action", action.String())
+			executed = append(executed, action)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return executed, err
+		}
+		level.Info(c.logger).Log("msg", "autoscale", "action", action.String())
+		if action.Kind == ActionSplit {
+			_, err = c.client.SplitNumShard(c.config.Project, c.config.Logstore, action.ShardID, action.SplitNum)
+		} else {
+			_, err = c.client.MergeShards(c.config.Project, c.config.Logstore, action.ShardID)
+		}
+		if err != nil {
+			return executed, fmt.Errorf("autoscale: %v: %w", action, err)
+		}
+		c.lastAction = c.now()
+		executed = append(executed, action)
+	}
+	return executed, nil
+}
+
+// plan decides the actions on the readwrite shards ordered by key range.
+func (c *Controller) plan(shards []*sls.Shard, rates map[int]float64) []Action {
+	count := len(shards)
+	var actions []Action
+
+	// split the hottest shards first, as long as the max shard count allows
+	hot := make([]*sls.Shard, 0)
+	for _, s := range shards {
+		if rates[s.ShardID] > c.config.SplitThreshold {
+			hot = append(hot, s)
+		}
+	}
+	sort.Slice(hot, func(i, j int) bool { return rates[hot[i].ShardID] > rates[hot[j].ShardID] })
+	for _, s := range hot {
+		splitNum := c.config.SplitNum
+		if count+splitNum-1 > c.config.MaxShardCount {
+			splitNum = c.config.MaxShardCount - count + 1
+		}
+		if splitNum < 2 {
+			level.Warn(c.logger).Log("msg", "hot shard can not be split, max shard count reached",
+				"shard", s.ShardID, "rate", rates[s.ShardID], "maxShardCount", c.config.MaxShardCount)
+			break
+		}
+		actions = append(actions, Action{Kind: ActionSplit, ShardID: s.ShardID, SplitNum: splitNum, Rate: rates[s.ShardID]})
+		count += splitNum - 1
+	}
+	if len(actions) > 0 {
+		return actions
+	}
+
+	// a shard missing from the rates may be idle or missing from the metrics,
+	// the shards are not merged on a guess
+	for _, s := range shards {
+		if _, ok := rates[s.ShardID]; !ok {
+			level.Info(c.logger).Log("msg", "autoscale skips merges, shard has no write rate", "shard", s.ShardID)
+			return actions
+		}
+	}
+
+	// merge the coldest adjacent pairs, each shard is merged at most once
+	type pair struct {
+		left, right int
+		rate        float64
+	}
+	var pairs []pair
+	for i := 0; i+1 < len(shards); i++ {
+		rate := rates[shards[i].ShardID] + rates[shards[i+1].ShardID]
+		if rate < c.config.MergeThreshold {
+			pairs = append(pairs, pair{i, i + 1, rate})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].rate < pairs[j].rate })
+	merged := map[int]bool{}
+	for _, p := range pairs {
+		if count-1 < c.config.MinShardCount {
+			break
+		}
+		if merged[p.left] || merged[p.right] {
+			continue
+		}
+		merged[p.left], merged[p.right] = true, true
+		actions = append(actions, Action{
+			Kind:      ActionMerge,
+			ShardID:   shards[p.left].ShardID,
+			MergeWith: shards[p.right].ShardID,
+			Rate:      p.rate,
+		})
+		count--
+	}
+	return actions
+}
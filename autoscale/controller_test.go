@@ -0,0 +1,229 @@
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+)
+
+// fakeClient keeps the shards of one logstore and splits or merges them like the server.
+type fakeClient struct {
+	sls.ClientInterface
+	shards []*sls.Shard
+	nextID int
+	calls  []string
+}
+
+func newFakeClient(count int) *fakeClient {
+	c := &fakeClient{}
+	c.addShards(sls.MinShardHashKey, sls.MaxShardHashKey, count)
+	return c
+}
+
+func parseKey(key string) *big.Int {
+	n, _ := new(big.Int).SetString(key, 16)
+	return n
+}
+
+// addShards splits [begin, end) evenly into count readwrite shards.
+func (c *fakeClient) addShards(begin, end string, count int) {
+	b, e := parseKey(begin), parseKey(end)
+	step := new(big.Int).Div(new(big.Int).Sub(e, b), big.NewInt(int64(count)))
+	for i := 0; i < count; i++ {
+		shardBegin := fmt.Sprintf("%032x", new(big.Int).Add(b, new(big.Int).Mul(step, big.NewInt(int64(i)))))
+		shardEnd := end
+		if i+1 < count {
+			shardEnd = fmt.Sprintf("%032x", new(big.Int).Add(b, new(big.Int).Mul(step, big.NewInt(int64(i+1)))))
+		}
+		c.shards = append(c.shards, &sls.Shard{ShardID: c.nextID, Status: "readwrite", InclusiveBeginKey: shardBegin, ExclusiveBeginKey: shardEnd})
+		c.nextID++
+	}
+}
+
+func (c *fakeClient) ListShards(project, logstore string) ([]*sls.Shard, error) {
+	return c.shards, nil
+}
+
+func (c *fakeClient) SplitNumShard(project, logstore string, shardID, shardsNum int) ([]*sls.Shard, error) {
+	c.calls = append(c.calls, fmt.Sprintf("split %d %d", shardID, shardsNum))
+	for _, s := range c.shards {
+		if s.ShardID == shardID {
+			s.Status = "readonly"
+			c.addShards(s.InclusiveBeginKey, s.ExclusiveBeginKey, shardsNum)
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("shard %d not exist", shardID)
+}
+
+func (c *fakeClient) MergeShards(project, logstore string, shardID int) ([]*sls.Shard, error) {
+	c.calls = append(c.calls, fmt.Sprintf("merge %d", shardID))
+	for _, left := range c.shards {
+		if left.ShardID != shardID {
+			continue
+		}
+		for _, right := range c.shards {
+			if right.Status == "readwrite" && right.InclusiveBeginKey == left.ExclusiveBeginKey {
+				left.Status, right.Status = "readonly", "readonly"
+				c.addShards(left.InclusiveBeginKey, right.ExclusiveBeginKey, 1)
+				return nil, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("shard %d can not be merged", shardID)
+}
+
+func rates(r map[int]float64) MetricsSource {
+	return MetricsSourceFunc(func(ctx context.Context, project, logstore string) (map[int]float64, error) {
+		return r, nil
+	})
+}
+
+func TestControllerStep(t *testing.T) {
+	const mb = 1024 * 1024
+	tests := []struct {
+		name   string
+		shards int
+		config Config
+		rates  map[int]float64
+		want   []string
+	}{
+		{"split hottest first", 4, Config{MaxShardCount: 5}, map[int]float64{0: 5 * mb, 1: 6 * mb, 2: 2 * mb, 3: 2 * mb}, []string{"split 1 2"}},
+		{"split num", 2, Config{SplitNum: 3}, map[int]float64{0: 9 * mb, 1: 2 * mb}, []string{"split 0 3"}},
+		{"split limited", 2, Config{SplitNum: 4, MaxShardCount: 3}, map[int]float64{0: 9 * mb}, []string{"split 0 2"}},
+		{"no split at max", 2, Config{MaxShardCount: 2}, map[int]float64{0: 9 * mb}, nil},
+		{"merge coldest pairs", 5, Config{}, map[int]float64{0: 0.3 * mb, 1: 0.1 * mb, 2: 0.2 * mb, 3: 3 * mb, 4: 0.1 * mb}, []string{"merge 1"}},
+		{"merge disjoint pairs", 4, Config{}, map[int]float64{0: 0, 1: 0, 2: 0, 3: 0}, []string{"merge 0", "merge 2"}},
+		{"merge limited", 4, Config{MinShardCount: 3}, map[int]float64{0: 0, 1: 0, 2: 0, 3: 0}, []string{"merge 0"}},
+		{"no merge with missing rates", 4, Config{}, map[int]float64{0: 0, 1: 0, 3: 0}, nil},
+		{"split with missing rates", 2, Config{}, map[int]float64{0: 9 * mb}, []string{"split 0 2"}},
+		{"steady", 2, Config{}, map[int]float64{0: 2 * mb, 1: 2 * mb}, nil},
+	}
+	for _, tt := range tests {
+		client := newFakeClient(tt.shards)
+		tt.config.Project, tt.config.Logstore, tt.config.Logger = "p", "l", log.NewNopLogger()
+		c, err := NewController(client, rates(tt.rates), &tt.config)
+		if err != nil {
+			t.Fatalf("%q. NewController() error = %v", tt.name, err)
+		}
+		actions, err := c.Step(context.Background())
+		if err != nil {
+			t.Errorf("%q. Step() error = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(client.calls, tt.want) {
+			t.Errorf("%q. Step() calls = %v, want %v", tt.name, client.calls, tt.want)
+		}
+		if len(actions) != len(tt.want) {
+			t.Errorf("%q. Step() = %v, want %d actions", tt.name, actions, len(tt.want))
+		}
+	}
+}
+
+func TestControllerCooldownAndDryRun(t *testing.T) {
+	client := newFakeClient(2)
+	now := time.Unix(1000, 0)
+	writeRates := map[int]float64{0: 9 * 1024 * 1024, 1: 9 * 1024 * 1024}
+	c, err := NewController(client, rates(writeRates), &Config{
+		Project: "p", Logstore: "l", MaxShardCount: 8, Cooldown: time.Minute, Logger: log.NewNopLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return now }
+	if actions, _ := c.Step(context.Background()); len(actions) != 2 {
+		t.Fatalf("Step() = %v, want 2 splits", actions)
+	}
+	if len(client.shards) != 6 {
+		t.Errorf("shards after split = %d, want 6", len(client.shards))
+	}
+	now = now.Add(30 * time.Second)
+	if actions, _ := c.Step(context.Background()); len(actions) != 0 {
+		t.Errorf("Step() in cooldown = %v", actions)
+	}
+	now = now.Add(time.Minute)
+	// the new shards 2 to 5 have no writes yet, so they are merged in pairs
+	for id := 2; id <= 5; id++ {
+		writeRates[id] = 0
+	}
+	actions, err := c.Step(context.Background())
+	if err != nil || len(actions) != 2 || actions[0].Kind != ActionMerge || actions[0].ShardID != 2 || actions[1].ShardID != 4 {
+		t.Errorf("Step() after cooldown = %v, %v", actions, err)
+	}
+
+	dry := newFakeClient(2)
+	c, _ = NewController(dry, rates(map[int]float64{0: 9 * 1024 * 1024}), &Config{
+		Project: "p", Logstore: "l", DryRun: true, Logger: log.NewNopLogger(),
+	})
+	actions, err = c.Step(context.Background())
+	if err != nil || len(actions) != 1 || !actions[0].DryRun {
+		t.Errorf("dry run Step() = %v, %v", actions, err)
+	}
+	if len(dry.calls) != 0 {
+		t.Errorf("dry run called %v", dry.calls)
+	}
+}
+
+func TestControllerInconsistentShards(t *testing.T) {
+	client := newFakeClient(2)
+	client.shards[1].Status = "readonly"
+	c, _ := NewController(client, rates(nil), &Config{Project: "p", Logstore: "l", Logger: log.NewNopLogger()})
+	if _, err := c.Step(context.Background()); err == nil {
+		t.Error("Step() expect error for a gap in the shards")
+	}
+}
+
+func TestMonitoringSourceInvalidName(t *testing.T) {
+	source := &MonitoringSource{}
+	for _, logstore := range []string{`l" or Project: "other`, `l\`, ""} {
+		if _, err := source.ShardWriteRates(context.Background(), "p", logstore); err == nil {
+			t.Errorf("ShardWriteRates(%q) expect error", logstore)
+		}
+	}
+}
+
+// monitorClient returns the inflow of the shards from the operation log
+type monitorClient struct {
+	*fakeClient
+	inflow map[int]float64
+}
+
+func (c *monitorClient) GetLogs(project, logstore string, topic string, from int64, to int64, queryExp string,
+	maxLineNum int64, offset int64, reverse bool) (*sls.GetLogsResponse, error) {
+	resp := &sls.GetLogsResponse{Progress: "Complete"}
+	for shard, inflow := range c.inflow {
+		resp.Logs = append(resp.Logs, map[string]string{"Shard": fmt.Sprint(shard), "inflow": fmt.Sprint(inflow)})
+	}
+	return resp, nil
+}
+
+func TestMonitoringSourceIdleShard(t *testing.T) {
+	client := &monitorClient{fakeClient: newFakeClient(4), inflow: map[int]float64{0: 60, 1: 60, 2: 60}}
+	client.shards[0].Status = "readonly"
+	source := &MonitoringSource{Client: client, Window: time.Minute}
+	got, err := source.ShardWriteRates(context.Background(), "p", "l")
+	if err != nil {
+		t.Fatalf("ShardWriteRates() error = %v", err)
+	}
+	// the readwrite shard 3 has no requests, the readonly shard 0 keeps its rate
+	want := map[int]float64{0: 1, 1: 1, 2: 1, 3: 0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ShardWriteRates() = %v, want %v", got, want)
+	}
+
+	client.shards[0].Status = "readwrite"
+	// the idle shard is merged first
+	c, _ := NewController(client, source, &Config{Project: "p", Logstore: "l", Logger: log.NewNopLogger()})
+	if _, err := c.Step(context.Background()); err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+	if want := []string{"merge 2", "merge 0"}; !reflect.DeepEqual(client.calls, want) {
+		t.Errorf("Step() calls = %v, want %v", client.calls, want)
+	}
+}
@@ -0,0 +1,95 @@
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+// MetricsSource reports the recent write rate of shards.
+type MetricsSource interface {
+	// ShardWriteRates returns the write rate in bytes per second of the shards
+	// of a logstore. Idle shards should be reported with 0, no shard is merged
+	// while a readwrite shard is missing.
+	ShardWriteRates(ctx context.Context, project, logstore string) (map[int]float64, error)
+}
+
+// MetricsSourceFunc adapts a function to MetricsSource, eg. to use rates from your own metrics system.
+type MetricsSourceFunc func(ctx context.Context, project, logstore string) (map[int]float64, error)
+
+func (f MetricsSourceFunc) ShardWriteRates(ctx context.Context, project, logstore string) (map[int]float64, error) {
+	return f(ctx, project, logstore)
+}
+
+// validName matches the project and logstore names
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// MonitoringSource reads write rates from the operation log of a project,
+// which records the inflow of every PostLogStoreLogs request. The readwrite
+// shards without requests in the Window are reported with 0.
+type MonitoringSource struct {
+	Client sls.ClientInterface
+	// Project and Logstore of the operation log, Project defaults to the
+	// project being scaled and Logstore defaults to internal-operation_log
+	Project  string
+	Logstore string
+	// Window is the time range to compute the average rate, default 5 minutes
+	Window time.Duration
+}
+
+func (s *MonitoringSource) ShardWriteRates(ctx context.Context, project, logstore string) (map[int]float64, error) {
+	monitorProject := s.Project
+	if monitorProject == "" {
+		monitorProject = project
+	}
+	monitorLogstore := s.Logstore
+	if monitorLogstore == "" {
+		monitorLogstore = "internal-operation_log"
+	}
+	window := s.Window
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	// the names are put into the query, they must not break out of the quotes
+	if !validName.MatchString(project) || !validName.MatchString(logstore) {
+		return nil, fmt.Errorf("autoscale: invalid project or logstore name %q/%q", project, logstore)
+	}
+	to := time.Now().Unix()
+	from := to - int64(window/time.Second)
+	query := fmt.Sprintf(`Method: PostLogStoreLogs and Project: "%s" and LogStore: "%s" | select Shard, sum(InFlow) as inflow group by Shard limit 10000`,
+		project, logstore)
+	resp, err := s.Client.GetLogs(monitorProject, monitorLogstore, "", from, to, query, 0, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	// an idle shard is only known to be idle from a complete result
+	if !resp.IsComplete() {
+		return nil, fmt.Errorf("autoscale: the query of the operation log is %s", resp.Progress)
+	}
+	shards, err := s.Client.ListShards(project, logstore)
+	if err != nil {
+		return nil, err
+	}
+	rates := make(map[int]float64, len(shards))
+	for _, shard := range shards {
+		if shard.Status == "readwrite" {
+			rates[shard.ShardID] = 0
+		}
+	}
+	for _, log := range resp.Logs {
+		shardID, err := strconv.Atoi(log["Shard"])
+		if err != nil {
+			continue
+		}
+		inflow, err := strconv.ParseFloat(log["inflow"], 64)
+		if err != nil {
+			continue
+		}
+		rates[shardID] = inflow / window.Seconds()
+	}
+	return rates, nil
+}
@@ -157,6 +157,16 @@ type ClientInterface interface {
 	DeleteMetricStore(project, name string) error
 	// GetMetricStore return a metric store.
 	GetMetricStore(project, name string) (*LogStore, error)
+	// PromInstantQuery evaluates a PromQL query at a time of a metric store, a zero time means now.
+	PromInstantQuery(project, metricStore, query string, ts time.Time) (*PromQueryResult, error)
+	// PromRangeQuery evaluates a PromQL query over [start, end] with a resolution of step.
+	PromRangeQuery(project, metricStore, query string, start, end time.Time, step time.Duration) (*PromQueryResult, error)
+	// PromSeries returns the label sets of the series that match any of the selectors.
+	PromSeries(project, metricStore string, matches []string, start, end time.Time) ([]map[string]string, error)
+	// PromLabels returns the label names of the series that match any of the selectors.
+	PromLabels(project, metricStore string, matches []string, start, end time.Time) ([]string, error)
+	// PromLabelValues returns the values of a label of the series that match any of the selectors.
+	PromLabelValues(project, metricStore, label string, matches []string, start, end time.Time) ([]string, error)
 
 	// #################### EventStore Operations #####################
 	// CreateEventStore creates a new event store in SLS.
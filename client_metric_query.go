@@ -0,0 +1,245 @@
+package sls
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The result types of a PromQL query.
+const (
+	PromResultTypeVector = "vector"
+	PromResultTypeMatrix = "matrix"
+	PromResultTypeScalar = "scalar"
+	PromResultTypeString = "string"
+)
+
+// PromPoint is a value at a time.
+type PromPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// PromSample is a sample of an instant vector.
+type PromSample struct {
+	Metric map[string]string
+	PromPoint
+}
+
+// PromRangeSeries is a series of a range vector.
+type PromRangeSeries struct {
+	Metric map[string]string
+	Points []PromPoint
+}
+
+// PromQueryResult is the result of a PromQL query, the field matching ResultType is set.
+type PromQueryResult struct {
+	ResultType string
+	Vector     []PromSample
+	Matrix     []PromRangeSeries
+	Scalar     *PromPoint
+	// String is the value of a string result, its time is StringTime
+	String     string
+	StringTime time.Time
+	Warnings   []string
+}
+
+// promResponse is the envelope of the responses of the Prometheus HTTP API
+type promResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+	Warnings  []string        `json:"warnings"`
+}
+
+// PromInstantQuery evaluates a PromQL query at a time of a MetricStore, a zero time means now.
+func (c *Client) PromInstantQuery(project, metricStore, query string, ts time.Time) (*PromQueryResult, error) {
+	v := url.Values{}
+	v.Set("query", query)
+	if !ts.IsZero() {
+		v.Set("time", formatPromTime(ts))
+	}
+	return c.promQuery(project, metricStore, "query", v)
+}
+
+// PromRangeQuery evaluates a PromQL query over [start, end] with a resolution of step.
+func (c *Client) PromRangeQuery(project, metricStore, query string, start, end time.Time, step time.Duration) (*PromQueryResult, error) {
+	v := url.Values{}
+	v.Set("query", query)
+	v.Set("start", formatPromTime(start))
+	v.Set("end", formatPromTime(end))
+	v.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+	return c.promQuery(project, metricStore, "query_range", v)
+}
+
+// PromSeries returns the label sets of the series that match any of the selectors in [start, end],
+// zero times are omitted.
+func (c *Client) PromSeries(project, metricStore string, matches []string, start, end time.Time) ([]map[string]string, error) {
+	var series []map[string]string
+	err := c.promRequest(project, metricStore, "series", promMatchValues(matches, start, end), &series, nil)
+	return series, err
+}
+
+// PromLabels returns the label names of the series that match any of the selectors in [start, end],
+// all series if matches is empty.
+func (c *Client) PromLabels(project, metricStore string, matches []string, start, end time.Time) ([]string, error) {
+	var labels []string
+	err := c.promRequest(project, metricStore, "labels", promMatchValues(matches, start, end), &labels, nil)
+	return labels, err
+}
+
+// PromLabelValues returns the values of a label of the series that match any of the selectors in [start, end],
+// all series if matches is empty.
+func (c *Client) PromLabelValues(project, metricStore, label string, matches []string, start, end time.Time) ([]string, error) {
+	var values []string
+	err := c.promRequest(project, metricStore, "label/"+url.PathEscape(label)+"/values", promMatchValues(matches, start, end), &values, nil)
+	return values, err
+}
+
+func (c *Client) promQuery(project, metricStore, api string, v url.Values) (*PromQueryResult, error) {
+	var data struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	}
+	result := &PromQueryResult{}
+	if err := c.promRequest(project, metricStore, api, v, &data, &result.Warnings); err != nil {
+		return nil, err
+	}
+	result.ResultType = data.ResultType
+	var err error
+	switch data.ResultType {
+	case PromResultTypeVector:
+		var samples []struct {
+			Metric map[string]string `json:"metric"`
+			Value  promValue         `json:"value"`
+		}
+		if err = json.Unmarshal(data.Result, &samples); err == nil {
+			for _, s := range samples {
+				result.Vector = append(result.Vector, PromSample{Metric: s.Metric, PromPoint: s.Value.point()})
+			}
+		}
+	case PromResultTypeMatrix:
+		var series []struct {
+			Metric map[string]string `json:"metric"`
+			Values []promValue       `json:"values"`
+		}
+		if err = json.Unmarshal(data.Result, &series); err == nil {
+			for _, s := range series {
+				rs := PromRangeSeries{Metric: s.Metric, Points: make([]PromPoint, 0, len(s.Values))}
+				for _, value := range s.Values {
+					rs.Points = append(rs.Points, value.point())
+				}
+				result.Matrix = append(result.Matrix, rs)
+			}
+		}
+	case PromResultTypeScalar:
+		var value promValue
+		if err = json.Unmarshal(data.Result, &value); err == nil {
+			point := value.point()
+			result.Scalar = &point
+		}
+	case PromResultTypeString:
+		var value promValue
+		if err = json.Unmarshal(data.Result, &value); err == nil {
+			result.String, result.StringTime = value.value, value.time
+		}
+	default:
+		err = fmt.Errorf("unknown result type %q", data.ResultType)
+	}
+	if err != nil {
+		return nil, NewClientError(err)
+	}
+	return result, nil
+}
+
+// promRequest calls an api of the Prometheus HTTP API of a MetricStore, and decodes the data of the response
+func (c *Client) promRequest(project, metricStore, api string, v url.Values, data interface{}, warnings *[]string) error {
+	h := map[string]string{
+		"x-log-bodyrawsize": "0",
+		"Content-Type":      "application/json",
+	}
+	uri := fmt.Sprintf("/prometheus/%s/%s/api/v1/%s?%s", project, metricStore, api, v.Encode())
+	r, err := c.request(project, "GET", uri, h, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return NewClientError(err)
+	}
+	resp := &promResponse{}
+	if err := json.Unmarshal(buf, resp); err != nil {
+		return NewClientError(err)
+	}
+	if resp.Status != "success" {
+		return &Error{
+			HTTPCode:  int32(r.StatusCode),
+			Code:      resp.ErrorType,
+			Message:   resp.Error,
+			RequestID: r.Header.Get(RequestIDHeader),
+		}
+	}
+	if warnings != nil {
+		*warnings = resp.Warnings
+	}
+	if err := json.Unmarshal(resp.Data, data); err != nil {
+		return NewClientError(err)
+	}
+	return nil
+}
+
+func promMatchValues(matches []string, start, end time.Time) url.Values {
+	v := url.Values{}
+	for _, m := range matches {
+		v.Add("match[]", m)
+	}
+	if !start.IsZero() {
+		v.Set("start", formatPromTime(start))
+	}
+	if !end.IsZero() {
+		v.Set("end", formatPromTime(end))
+	}
+	return v
+}
+
+// formatPromTime formats a time as unix seconds with millisecond precision
+func formatPromTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano()/int64(time.Millisecond))/1000, 'f', -1, 64)
+}
+
+// promValue is a [<unix seconds>, "<value>"] pair of the Prometheus HTTP API
+type promValue struct {
+	time  time.Time
+	value string
+}
+
+func (v *promValue) UnmarshalJSON(data []byte) error {
+	var pair []interface{}
+	if err := json.Unmarshal(data, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return fmt.Errorf("invalid value %s", data)
+	}
+	seconds, ok := pair[0].(float64)
+	value, ok2 := pair[1].(string)
+	if !ok || !ok2 {
+		return fmt.Errorf("invalid value %s", data)
+	}
+	v.time = time.Unix(0, int64(math.Round(seconds*1e3))*int64(time.Millisecond))
+	v.value = value
+	return nil
+}
+
+// point parses the value as a float, Prometheus formats NaN and infinities as strings
+// that ParseFloat understands
+func (v promValue) point() PromPoint {
+	f, _ := strconv.ParseFloat(v.value, 64)
+	return PromPoint{Time: v.time, Value: f}
+}
@@ -0,0 +1,85 @@
+package sls
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newPromTestClient returns a client that sends every request to handler
+func newPromTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return &Client{
+		Endpoint:        "cn-hangzhou.log.aliyuncs.com",
+		AccessKeyID:     "id",
+		AccessKeySecret: "key",
+		HTTPClient: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return net.Dial("tcp", ts.Listener.Addr().String())
+			},
+		}},
+	}
+}
+
+func TestPromQuery(t *testing.T) {
+	responses := map[string]string{
+		"/prometheus/p/m/api/v1/query": `{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{"__name__":"up","job":"node"},"value":[1700000000.5,"1"]}]},"warnings":["w"]}`,
+		"/prometheus/p/m/api/v1/query_range": `{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"job":"node"},"values":[[1700000000,"1"],[1700000060,"NaN"]]}]}}`,
+		"/prometheus/p/m/api/v1/series":           `{"status":"success","data":[{"__name__":"up","job":"node"}]}`,
+		"/prometheus/p/m/api/v1/labels":           `{"status":"success","data":["__name__","job"]}`,
+		"/prometheus/p/m/api/v1/label/job/values": `{"status":"success","data":["node"]}`,
+		"/prometheus/p/bad/api/v1/query":          `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+		"/prometheus/p/scalar/api/v1/query":       `{"status":"success","data":{"resultType":"scalar","result":[1700000000,"2.5"]}}`,
+	}
+	var queries []string
+	client := newPromTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "p.cn-hangzhou.log.aliyuncs.com", r.Host)
+		assert.NotEmpty(t, r.Header.Get(HTTPHeaderAuthorization))
+		queries = append(queries, r.URL.RawQuery)
+		w.Write([]byte(responses[r.URL.Path]))
+	})
+
+	result, err := client.PromInstantQuery("p", "m", "up", time.Unix(1700000000, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, PromResultTypeVector, result.ResultType)
+	assert.Equal(t, []string{"w"}, result.Warnings)
+	assert.Equal(t, []PromSample{{
+		Metric:    map[string]string{"__name__": "up", "job": "node"},
+		PromPoint: PromPoint{Time: time.Unix(1700000000, 5e8), Value: 1},
+	}}, result.Vector)
+	assert.Equal(t, "query=up&time=1700000000", queries[0])
+
+	result, err = client.PromRangeQuery("p", "m", "up", time.Unix(1700000000, 0), time.Unix(1700000060, 0), time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(result.Matrix))
+	assert.Equal(t, 2, len(result.Matrix[0].Points))
+	assert.Equal(t, "end=1700000060&query=up&start=1700000000&step=60", queries[1])
+
+	result, err = client.PromInstantQuery("p", "scalar", "2.5", time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2.5, result.Scalar.Value)
+
+	series, err := client.PromSeries("p", "m", []string{"up"}, time.Time{}, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]string{{"__name__": "up", "job": "node"}}, series)
+
+	labels, err := client.PromLabels("p", "m", nil, time.Time{}, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"__name__", "job"}, labels)
+
+	values, err := client.PromLabelValues("p", "m", "job", nil, time.Time{}, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"node"}, values)
+
+	_, err = client.PromInstantQuery("p", "bad", "up{", time.Time{})
+	assert.NotNil(t, err)
+	assert.Equal(t, "bad_data", err.(*Error).Code)
+}
@@ -139,38 +139,55 @@ func (consumer *ConsumerClient) getCursor(shardId int, from string) (string, err
 	return cursor, err
 }
 
-func (consumer *ConsumerClient) pullLogs(shardId int, cursor string) (gl *sls.LogGroupList, plm *sls.PullLogMeta, err error) {
+// pullLogs pulls the logs of a shard like pullLogsBytes and decodes them, the
+// decompressed data is returned too.
+func (consumer *ConsumerClient) pullLogs(shardId int, cursor string) (gl *sls.LogGroupList, data []byte, plm *sls.PullLogMeta, err error) {
+	data, plm, err = consumer.pullLogsBytes(shardId, cursor)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	gl, err = sls.LogsBytesDecode(data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return
+}
+
+// pullLogsBytes pulls the decompressed data of a shard without decoding it, it
+// retries three times, and waits longer after a 403 of the quota.
+func (consumer *ConsumerClient) pullLogsBytes(shardId int, cursor string) (data []byte, plm *sls.PullLogMeta, err error) {
 	plr := &sls.PullLogRequest{
 		Project:          consumer.option.Project,
 		Logstore:         consumer.option.Logstore,
 		ShardID:          shardId,
 		Cursor:           cursor,
 		LogGroupMaxCount: consumer.option.MaxFetchLogGroupCount,
+		Query:            consumer.option.Query,
 		CompressType:     consumer.option.CompressType,
 	}
 	for retry := 0; retry < 3; retry++ {
-		gl, plm, err = consumer.client.PullLogsWithQuery(plr)
-		if err != nil {
-			slsError, ok := err.(*sls.Error)
-			if ok {
-				level.Warn(consumer.logger).Log("msg", "shard pull logs failed, occur sls error",
-					"shard", shardId,
-					"error", slsError,
-					"tryTimes", retry+1,
-					"cursor", cursor,
-				)
-				if slsError.HTTPCode == 403 {
-					time.Sleep(5 * time.Second)
-				}
-			} else {
-				level.Warn(consumer.logger).Log("msg", "unknown error when pull log",
-					"shardId", shardId,
-					"cursor", cursor,
-					"error", err,
-					"tryTimes", retry+1)
+		data, plm, err = consumer.client.GetLogsBytesWithQuery(plr)
+		if err == nil {
+			return
+		}
+		if slsError, ok := err.(*sls.Error); ok {
+			level.Warn(consumer.logger).Log("msg", "shard pull logs failed, occur sls error",
+				"shard", shardId,
+				"error", slsError,
+				"tryTimes", retry+1,
+				"cursor", cursor,
+			)
+			if slsError.HTTPCode == 403 {
+				time.Sleep(5 * time.Second)
 			}
-			time.Sleep(200 * time.Millisecond)
+		} else {
+			level.Warn(consumer.logger).Log("msg", "unknown error when pull log",
+				"shardId", shardId,
+				"cursor", cursor,
+				"error", err,
+				"tryTimes", retry+1)
 		}
+		time.Sleep(200 * time.Millisecond)
 	}
 	// If you can't retry the log three times, it will return to empty list and start pulling the log cursor,
 	// so that next time you will come in and pull the function again, which is equivalent to a dead cycle.
@@ -6,6 +6,7 @@ import (
 
 	sls "github.com/aliyun/aliyun-log-go-sdk"
 	"github.com/go-kit/kit/log"
+	"github.com/gogo/protobuf/proto"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -98,3 +99,57 @@ func TestConsumerClient_updateConsumerGroup(t *testing.T) {
 	// clean
 	_ = oldClient.client.DeleteConsumerGroup(oldOption.Project, oldOption.Logstore, oldOption.ConsumerGroupName)
 }
+
+type pullClient struct {
+	sls.ClientInterface
+	requests []*sls.PullLogRequest
+	data     []byte
+}
+
+func (c *pullClient) GetLogsBytesWithQuery(plr *sls.PullLogRequest) ([]byte, *sls.PullLogMeta, error) {
+	c.requests = append(c.requests, plr)
+	return c.data, &sls.PullLogMeta{NextCursor: "next"}, nil
+}
+
+func TestConsumerClient_pullLogs(t *testing.T) {
+	lgList := &sls.LogGroupList{LogGroups: []*sls.LogGroup{{Topic: proto.String("t")}}}
+	data, _ := lgList.Marshal()
+	fake := &pullClient{data: data}
+	option := InitOption()
+	option.Query = "* | where a = 'x'"
+	consumer := &ConsumerClient{option: option, client: fake, logger: log.NewNopLogger()}
+
+	gl, raw, meta, err := consumer.pullLogs(0, "cursor")
+	assert.Nil(t, err)
+	assert.Equal(t, "t", gl.LogGroups[0].GetTopic())
+	assert.Equal(t, data, raw)
+	assert.Equal(t, "next", meta.NextCursor)
+	_, _, err = consumer.pullLogsBytes(0, "cursor")
+	assert.Nil(t, err)
+	// both paths send the same request
+	assert.Equal(t, 2, len(fake.requests))
+	assert.Equal(t, option.Query, fake.requests[0].Query)
+	assert.Equal(t, fake.requests[0], fake.requests[1])
+}
+
+func TestLazyProcessFunc_Process(t *testing.T) {
+	lgList := &sls.LogGroupList{LogGroups: []*sls.LogGroup{{Topic: proto.String("t")}}}
+	pulled := []byte("pulled data")
+	var got []byte
+	processor := LazyProcessFunc(func(shard int, lazy *sls.LazyLogGroupList, tracker CheckPointTracker) (string, error) {
+		got = lazy.Bytes()
+		return "", nil
+	})
+	// the pulled data is passed through
+	ProcessPulled(processor, 0, lgList, pulled, nil)
+	assert.Equal(t, pulled, got)
+	// a list without the data is encoded
+	data, _ := lgList.Marshal()
+	processor.Process(0, lgList, nil)
+	assert.Equal(t, data, got)
+	ProcessPulled(ProcessFunc(func(shard int, list *sls.LogGroupList, tracker CheckPointTracker) (string, error) {
+		got = nil
+		return "", nil
+	}), 0, lgList, pulled, nil)
+	assert.Nil(t, got)
+}
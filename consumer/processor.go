@@ -1,6 +1,8 @@
 package consumerLibrary
 
-import sls "github.com/aliyun/aliyun-log-go-sdk"
+import (
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
 
 type Processor interface {
 	Process(int, *sls.LogGroupList, CheckPointTracker) (string, error)
@@ -17,3 +19,58 @@ func (processor ProcessFunc) Shutdown(checkpointTracker CheckPointTracker) error
 	// Do nothing
 	return nil
 }
+
+// LazyProcessor can be implemented by a Processor to receive the pulled data
+// without decoding it into structs, which saves most of the allocations when
+// only a few fields of every log are read. When the processor of a worker
+// implements it, ProcessLazy is called instead of Process.
+type LazyProcessor interface {
+	ProcessLazy(int, *sls.LazyLogGroupList, CheckPointTracker) (string, error)
+}
+
+type LazyProcessFunc func(int, *sls.LazyLogGroupList, CheckPointTracker) (string, error)
+
+func (processor LazyProcessFunc) ProcessLazy(shard int, lgList *sls.LazyLogGroupList, checkpointTracker CheckPointTracker) (string, error) {
+	return processor(shard, lgList, checkpointTracker)
+}
+
+// PulledDataProcessor can be implemented by a Processor to receive the data the
+// pulled list is decoded from. A Processor that wraps a LazyProcessFunc can
+// implement it and pass the data to ProcessPulled, so the list is not encoded
+// again.
+type PulledDataProcessor interface {
+	ProcessPulled(shard int, lgList *sls.LogGroupList, data []byte, checkpointTracker CheckPointTracker) (string, error)
+}
+
+// ProcessPulled calls ProcessPulled of the processor if it is a
+// PulledDataProcessor, otherwise Process. data must be the data lgList is
+// decoded from, or nil if the list is changed.
+func ProcessPulled(processor Processor, shard int, lgList *sls.LogGroupList, data []byte, checkpointTracker CheckPointTracker) (string, error) {
+	if p, ok := processor.(PulledDataProcessor); ok {
+		return p.ProcessPulled(shard, lgList, data, checkpointTracker)
+	}
+	return processor.Process(shard, lgList, checkpointTracker)
+}
+
+// Process encodes the list and calls the function, it is only used if the
+// processor is wrapped by another processor, see ProcessPulled.
+func (processor LazyProcessFunc) Process(shard int, lgList *sls.LogGroupList, checkpointTracker CheckPointTracker) (string, error) {
+	return processor.ProcessPulled(shard, lgList, nil, checkpointTracker)
+}
+
+// ProcessPulled calls the function with the pulled data, the list is encoded
+// only if data is nil.
+func (processor LazyProcessFunc) ProcessPulled(shard int, lgList *sls.LogGroupList, data []byte, checkpointTracker CheckPointTracker) (string, error) {
+	if data == nil {
+		var err error
+		if data, err = lgList.Marshal(); err != nil {
+			return "", err
+		}
+	}
+	return processor(shard, sls.NewLazyLogGroupList(data), checkpointTracker)
+}
+
+func (processor LazyProcessFunc) Shutdown(checkpointTracker CheckPointTracker) error {
+	// Do nothing
+	return nil
+}
@@ -14,6 +14,8 @@ type ShardConsumerWorker struct {
 	consumerCheckPointTracker      *DefaultCheckPointTracker
 	shutdownFlag                   bool
 	lastFetchLogGroupList          *sls.LogGroupList
+	lastFetchLazyLogGroupList      *sls.LazyLogGroupList
+	lastFetchData                  []byte
 	nextFetchCursor                string
 	lastFetchGroupCount            int
 	lastFetchGroupCountBeforeQuery int
@@ -0,0 +1,40 @@
+package consumerLibrary
+
+import (
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/spl"
+)
+
+type splProcessor struct {
+	pipeline  *spl.Pipeline
+	processor Processor
+}
+
+// NewSPLProcessor returns a Processor that runs the SPL query locally on every
+// fetched LogGroupList before passing the result to processor.
+// It can be used when server side SPL consumption is not available, or to
+// run the same query as LogHubConfig.Query offline.
+// Leave LogHubConfig.Query empty when using it, otherwise the query runs twice.
+func NewSPLProcessor(query string, processor Processor) (Processor, error) {
+	pipeline, err := spl.Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	return &splProcessor{
+		pipeline:  pipeline,
+		processor: processor,
+	}, nil
+}
+
+func (p *splProcessor) Process(shard int, lgList *sls.LogGroupList, checkpointTracker CheckPointTracker) (string, error) {
+	result, err := p.pipeline.Process(lgList)
+	if err != nil {
+		return "", err
+	}
+	// the processor is always called, so that it can save checkpoints even if all logs are filtered out
+	return p.processor.Process(shard, result, checkpointTracker)
+}
+
+func (p *splProcessor) Shutdown(checkpointTracker CheckPointTracker) error {
+	return p.processor.Shutdown(checkpointTracker)
+}
@@ -6,6 +6,7 @@ import (
 	"runtime"
 	"time"
 
+	sls "github.com/aliyun/aliyun-log-go-sdk"
 	"github.com/go-kit/kit/log/level"
 )
 
@@ -49,16 +50,34 @@ func (consumer *ShardConsumerWorker) nextFetchTask() error {
 	// update last fetch time, for control fetch frequency
 	consumer.lastFetchTime = time.Now()
 
-	logGroup, pullLogMeta, err := consumer.client.pullLogs(consumer.shardId, consumer.nextFetchCursor)
-	if err != nil {
-		return err
+	var pullLogMeta *sls.PullLogMeta
+	if _, lazy := consumer.processor.(LazyProcessor); lazy {
+		data, meta, err := consumer.client.pullLogsBytes(consumer.shardId, consumer.nextFetchCursor)
+		if err != nil {
+			return err
+		}
+		lazyLogGroupList := sls.NewLazyLogGroupList(data)
+		groupCount, err := lazyLogGroupList.Len()
+		if err != nil {
+			return err
+		}
+		consumer.lastFetchLazyLogGroupList = lazyLogGroupList
+		consumer.lastFetchGroupCount = groupCount
+		pullLogMeta = meta
+	} else {
+		logGroup, data, meta, err := consumer.client.pullLogs(consumer.shardId, consumer.nextFetchCursor)
+		if err != nil {
+			return err
+		}
+		consumer.lastFetchLogGroupList = logGroup
+		consumer.lastFetchData = data
+		consumer.lastFetchGroupCount = GetLogGroupCount(consumer.lastFetchLogGroupList)
+		pullLogMeta = meta
 	}
 	// set cursors user to decide whether to save according to the execution of `process`
 	consumer.consumerCheckPointTracker.setCurrentCursor(consumer.nextFetchCursor)
-	consumer.lastFetchLogGroupList = logGroup
 	consumer.nextFetchCursor = pullLogMeta.NextCursor
 	consumer.lastFetchRawSize = pullLogMeta.RawSize
-	consumer.lastFetchGroupCount = GetLogGroupCount(consumer.lastFetchLogGroupList)
 	if consumer.client.option.Query != "" {
 		consumer.lastFetchRawSizeBeforeQuery = pullLogMeta.RawSizeBeforeQuery
 		consumer.lastFetchGroupCountBeforeQuery = pullLogMeta.RawDataCountBeforeQuery
@@ -76,6 +95,8 @@ func (consumer *ShardConsumerWorker) nextFetchTask() error {
 	)
 	if consumer.lastFetchGroupCount == 0 {
 		consumer.lastFetchLogGroupList = nil
+		consumer.lastFetchData = nil
+		consumer.lastFetchLazyLogGroupList = nil
 		// may no new data can be pulled, no process func can trigger checkpoint saving
 		consumer.saveCheckPointIfNeeded()
 	}
@@ -94,12 +115,22 @@ func (consumer *ShardConsumerWorker) consumerProcessTask() (rollBackCheckpoint s
 		}
 	}()
 	if consumer.lastFetchLogGroupList != nil {
-		rollBackCheckpoint, err = consumer.processor.Process(consumer.shardId, consumer.lastFetchLogGroupList, consumer.consumerCheckPointTracker)
+		rollBackCheckpoint, err = ProcessPulled(consumer.processor, consumer.shardId, consumer.lastFetchLogGroupList, consumer.lastFetchData, consumer.consumerCheckPointTracker)
 		consumer.saveCheckPointIfNeeded()
 		if err != nil {
 			return
 		}
 		consumer.lastFetchLogGroupList = nil
+		consumer.lastFetchData = nil
+	}
+	if consumer.lastFetchLazyLogGroupList != nil {
+		lazyProcessor := consumer.processor.(LazyProcessor)
+		rollBackCheckpoint, err = lazyProcessor.ProcessLazy(consumer.shardId, consumer.lastFetchLazyLogGroupList, consumer.consumerCheckPointTracker)
+		consumer.saveCheckPointIfNeeded()
+		if err != nil {
+			return
+		}
+		consumer.lastFetchLazyLogGroupList = nil
 	}
 
 	return
@@ -0,0 +1,324 @@
+// Package downloader pulls a time range of historical data from every shard
+// of a logstore concurrently, and can resume an interrupted download from a
+// local state file.
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	defaultMaxFetchLogGroupCount = 1000
+	defaultConcurrency           = 8
+	defaultMaxRetryTimes         = 10
+)
+
+// the backoff between retries of one shard, overridden in tests
+var (
+	retryBaseInterval = time.Second
+	retryMaxInterval  = 30 * time.Second
+)
+
+// Config is the configuration of a Downloader.
+type Config struct {
+	Project  string
+	Logstore string
+	// From and To are unix timestamps in seconds of the server receive time,
+	// data in [From, To) is downloaded
+	From int64
+	To   int64
+	// Query is an optional SPL query executed on the server side
+	Query string
+	// MaxFetchLogGroupCount is the max number of log groups of one pull, default 1000
+	MaxFetchLogGroupCount int
+	// Concurrency is the max number of shards pulled at the same time, default 8
+	Concurrency int
+	// MaxRetryTimes is the max consecutive failures of one shard before the download fails, default 10
+	MaxRetryTimes int
+	// StateFile is an optional local file recording the progress of every shard.
+	// If it exists and was written for the same project, logstore, time range and query,
+	// the download continues from the recorded cursors.
+	StateFile string
+	// CompressType is the compress type of the pull request, default lz4
+	CompressType int
+	// Logger defaults to sls.Logger
+	Logger log.Logger
+}
+
+// Sink receives the log groups of one shard in cursor order.
+// Calls for different shards may happen concurrently.
+// The progress of a shard only moves forward after the sink returns nil,
+// so a failed call is repeated with the same data after a resume.
+type Sink func(shardID int, logGroupList *sls.LogGroupList) error
+
+// Downloader downloads a time range from all shards of a logstore.
+type Downloader struct {
+	client sls.ClientInterface
+	config Config
+	logger log.Logger
+
+	mutex sync.Mutex
+	state *state
+}
+
+// NewDownloader creates a Downloader, config is copied.
+func NewDownloader(client sls.ClientInterface, config *Config) *Downloader {
+	c := *config
+	if c.MaxFetchLogGroupCount <= 0 {
+		c.MaxFetchLogGroupCount = defaultMaxFetchLogGroupCount
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = defaultConcurrency
+	}
+	if c.MaxRetryTimes <= 0 {
+		c.MaxRetryTimes = defaultMaxRetryTimes
+	}
+	logger := c.Logger
+	if logger == nil {
+		logger = sls.Logger
+	}
+	return &Downloader{
+		client: client,
+		config: c,
+		logger: log.With(logger, "project", c.Project, "logstore", c.Logstore),
+	}
+}
+
+// Progress returns a snapshot of the progress of every shard, it is empty before Run resolves the cursors.
+func (d *Downloader) Progress() []ShardProgress {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.state == nil {
+		return nil
+	}
+	result := make([]ShardProgress, 0, len(d.state.Shards))
+	for _, p := range d.state.Shards {
+		result = append(result, *p)
+	}
+	sortProgress(result)
+	return result
+}
+
+// Run downloads all shards and returns when every shard is done, an error
+// occurs or ctx is canceled. The first error stops the other shards.
+// Run can be called again with the same StateFile to resume.
+func (d *Downloader) Run(ctx context.Context, sink Sink) error {
+	if d.config.From >= d.config.To {
+		return fmt.Errorf("invalid time range [%d, %d)", d.config.From, d.config.To)
+	}
+	if err := d.initState(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	semaphore := make(chan struct{}, d.config.Concurrency)
+	for _, p := range d.Progress() {
+		if p.Done {
+			continue
+		}
+		shardID := p.ShardID
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-semaphore }()
+			if err := d.downloadShard(ctx, shardID, sink); err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("shard %d: %w", shardID, err)
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// initState loads the state file, or resolves the cursors of every shard.
+func (d *Downloader) initState() error {
+	if d.config.StateFile != "" {
+		s, err := loadState(d.config.StateFile)
+		if err != nil {
+			return err
+		}
+		if s != nil && s.matches(&d.config) {
+			level.Info(d.logger).Log("msg", "resume download from state file", "file", d.config.StateFile)
+			d.mutex.Lock()
+			d.state = s
+			d.mutex.Unlock()
+			return nil
+		}
+		if s != nil {
+			level.Warn(d.logger).Log("msg", "state file is for another download, ignore it", "file", d.config.StateFile)
+		}
+	}
+
+	shards, err := d.client.ListShards(d.config.Project, d.config.Logstore)
+	if err != nil {
+		return err
+	}
+	s := &state{
+		Project:  d.config.Project,
+		Logstore: d.config.Logstore,
+		From:     d.config.From,
+		To:       d.config.To,
+		Query:    d.config.Query,
+		Shards:   make(map[int]*ShardProgress, len(shards)),
+	}
+	from := strconv.FormatInt(d.config.From, 10)
+	to := strconv.FormatInt(d.config.To, 10)
+	// readonly shards are included, they hold the data written before a split or merge
+	for _, shard := range shards {
+		begin, err := d.client.GetCursor(d.config.Project, d.config.Logstore, shard.ShardID, from)
+		if err != nil {
+			return fmt.Errorf("get begin cursor of shard %d: %w", shard.ShardID, err)
+		}
+		end, err := d.client.GetCursor(d.config.Project, d.config.Logstore, shard.ShardID, to)
+		if err != nil {
+			return fmt.Errorf("get end cursor of shard %d: %w", shard.ShardID, err)
+		}
+		s.Shards[shard.ShardID] = &ShardProgress{
+			ShardID:     shard.ShardID,
+			BeginCursor: begin,
+			EndCursor:   end,
+			NextCursor:  begin,
+			Done:        begin == end,
+		}
+	}
+	d.mutex.Lock()
+	d.state = s
+	d.mutex.Unlock()
+	return d.saveState()
+}
+
+func (d *Downloader) downloadShard(ctx context.Context, shardID int, sink Sink) error {
+	d.mutex.Lock()
+	progress := *d.state.Shards[shardID]
+	d.mutex.Unlock()
+
+	logger := log.With(d.logger, "shard", shardID)
+	level.Info(logger).Log("msg", "start downloading shard", "cursor", progress.NextCursor)
+	cursor := progress.NextCursor
+	failures := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		logGroupList, meta, err := d.client.PullLogsWithQuery(&sls.PullLogRequest{
+			Project:          d.config.Project,
+			Logstore:         d.config.Logstore,
+			ShardID:          shardID,
+			Cursor:           cursor,
+			EndCursor:        progress.EndCursor,
+			LogGroupMaxCount: d.config.MaxFetchLogGroupCount,
+			Query:            d.config.Query,
+			CompressType:     d.config.CompressType,
+		})
+		if err == nil && logGroupList != nil && len(logGroupList.LogGroups) > 0 {
+			err = sink(shardID, logGroupList)
+		}
+		if err != nil {
+			failures++
+			if failures > d.config.MaxRetryTimes || !retryable(err) {
+				return err
+			}
+			level.Warn(logger).Log("msg", "download shard failed, retry later", "error", err, "retryTimes", failures)
+			if err := sleep(ctx, backoff(failures)); err != nil {
+				return err
+			}
+			continue
+		}
+		failures = 0
+
+		done := meta.NextCursor == "" || meta.NextCursor == progress.EndCursor || meta.NextCursor == cursor
+		if err := d.updateProgress(shardID, meta, logGroupList, done); err != nil {
+			return err
+		}
+		if done {
+			level.Info(logger).Log("msg", "shard download finished")
+			return nil
+		}
+		cursor = meta.NextCursor
+	}
+}
+
+func (d *Downloader) updateProgress(shardID int, meta *sls.PullLogMeta, logGroupList *sls.LogGroupList, done bool) error {
+	d.mutex.Lock()
+	p := d.state.Shards[shardID]
+	if meta.NextCursor != "" {
+		p.NextCursor = meta.NextCursor
+	}
+	p.Done = done
+	p.RawSize += int64(meta.RawSize)
+	if logGroupList != nil {
+		p.LogGroupCount += int64(len(logGroupList.LogGroups))
+		for _, lg := range logGroupList.LogGroups {
+			p.LogCount += int64(len(lg.Logs))
+		}
+	}
+	d.mutex.Unlock()
+	return d.saveState()
+}
+
+func (d *Downloader) saveState() error {
+	if d.config.StateFile == "" {
+		return nil
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return saveState(d.config.StateFile, d.state)
+}
+
+// retryable returns false for client errors that will fail again, such as a missing logstore or an invalid query.
+func retryable(err error) bool {
+	var slsErr *sls.Error
+	if errors.As(err, &slsErr) {
+		return slsErr.HTTPCode < 400 || slsErr.HTTPCode >= 500 || slsErr.HTTPCode == 403 || slsErr.HTTPCode == 429
+	}
+	return true
+}
+
+func backoff(failures int) time.Duration {
+	interval := retryBaseInterval
+	for i := 1; i < failures && interval < retryMaxInterval; i++ {
+		interval *= 2
+	}
+	if interval > retryMaxInterval {
+		interval = retryMaxInterval
+	}
+	return interval
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
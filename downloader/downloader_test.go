@@ -0,0 +1,187 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/gogo/protobuf/proto"
+)
+
+// fakeClient serves shards whose cursors are the index of the log group,
+// and whose log groups are written at second 100 + index.
+type fakeClient struct {
+	sls.ClientInterface
+	shards    map[int]int // shard id -> log group count
+	mutex     sync.Mutex
+	pullTimes int
+	failOnce  map[int]bool
+}
+
+func (c *fakeClient) ListShards(project, logstore string) ([]*sls.Shard, error) {
+	var shards []*sls.Shard
+	for id := range c.shards {
+		status := "readwrite"
+		if id == 0 {
+			status = "readonly"
+		}
+		shards = append(shards, &sls.Shard{ShardID: id, Status: status})
+	}
+	return shards, nil
+}
+
+func (c *fakeClient) GetCursor(project, logstore string, shardID int, from string) (string, error) {
+	t, err := strconv.Atoi(from)
+	if err != nil {
+		return "", err
+	}
+	index := t - 100
+	if index < 0 {
+		index = 0
+	}
+	if index > c.shards[shardID] {
+		index = c.shards[shardID]
+	}
+	return strconv.Itoa(index), nil
+}
+
+func (c *fakeClient) PullLogsWithQuery(plr *sls.PullLogRequest) (*sls.LogGroupList, *sls.PullLogMeta, error) {
+	c.mutex.Lock()
+	c.pullTimes++
+	if c.failOnce[plr.ShardID] {
+		c.failOnce[plr.ShardID] = false
+		c.mutex.Unlock()
+		return nil, nil, &sls.Error{HTTPCode: 500, Code: "InternalServerError"}
+	}
+	c.mutex.Unlock()
+
+	begin, _ := strconv.Atoi(plr.Cursor)
+	end, _ := strconv.Atoi(plr.EndCursor)
+	if begin+plr.LogGroupMaxCount < end {
+		end = begin + plr.LogGroupMaxCount
+	}
+	list := &sls.LogGroupList{}
+	for i := begin; i < end; i++ {
+		list.LogGroups = append(list.LogGroups, &sls.LogGroup{
+			Source: proto.String(strconv.Itoa(i)),
+			Logs:   []*sls.Log{{Time: proto.Uint32(uint32(100 + i))}},
+		})
+	}
+	return list, &sls.PullLogMeta{NextCursor: strconv.Itoa(end)}, nil
+}
+
+type collector struct {
+	mutex    sync.Mutex
+	received map[int][]string
+}
+
+func (c *collector) sink(shardID int, logGroupList *sls.LogGroupList) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, lg := range logGroupList.LogGroups {
+		c.received[shardID] = append(c.received[shardID], lg.GetSource())
+	}
+	return nil
+}
+
+func init() {
+	retryBaseInterval = time.Millisecond
+	retryMaxInterval = time.Millisecond
+}
+
+func TestDownloaderRun(t *testing.T) {
+	client := &fakeClient{
+		shards:   map[int]int{0: 10, 1: 25, 2: 3},
+		failOnce: map[int]bool{1: true},
+	}
+	d := NewDownloader(client, &Config{
+		Project:               "p",
+		Logstore:              "l",
+		From:                  102,
+		To:                    120,
+		MaxFetchLogGroupCount: 4,
+		Concurrency:           2,
+		Logger:                log.NewNopLogger(),
+	})
+	c := &collector{received: map[int][]string{}}
+	if err := d.Run(context.Background(), c.sink); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	want := map[int]int{0: 8, 1: 18, 2: 1}
+	for shard, count := range want {
+		got := c.received[shard]
+		if len(got) != count {
+			t.Errorf("shard %d received %d log groups, want %d", shard, len(got), count)
+			continue
+		}
+		if got[0] != "2" {
+			t.Errorf("shard %d first log group = %v, want 2", shard, got[0])
+		}
+	}
+	for _, p := range d.Progress() {
+		if !p.Done || int(p.LogGroupCount) != want[p.ShardID] {
+			t.Errorf("Progress() = %+v, want done with %d log groups", p, want[p.ShardID])
+		}
+	}
+}
+
+func TestDownloaderResume(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	client := &fakeClient{shards: map[int]int{0: 30, 1: 30}}
+	config := &Config{
+		Project:               "p",
+		Logstore:              "l",
+		From:                  100,
+		To:                    130,
+		MaxFetchLogGroupCount: 5,
+		Concurrency:           1,
+		StateFile:             stateFile,
+		Logger:                log.NewNopLogger(),
+	}
+	c := &collector{received: map[int][]string{}}
+	calls := 0
+	errStop := errors.New("stop")
+	err := NewDownloader(client, config).Run(context.Background(), func(shardID int, logGroupList *sls.LogGroupList) error {
+		calls++
+		if calls >= 3 {
+			return errStop
+		}
+		return c.sink(shardID, logGroupList)
+	})
+	if err == nil {
+		t.Fatal("Run() expect error")
+	}
+
+	d := NewDownloader(client, config)
+	if err := d.Run(context.Background(), c.sink); err != nil {
+		t.Fatalf("resumed Run() error = %v", err)
+	}
+	for shard := 0; shard < 2; shard++ {
+		got := c.received[shard]
+		if len(got) != 30 {
+			t.Errorf("shard %d received %d log groups, want 30", shard, len(got))
+			continue
+		}
+		for i, source := range got {
+			if source != strconv.Itoa(i) {
+				t.Errorf("shard %d log group %d = %v, want %d", shard, i, source, i)
+				break
+			}
+		}
+	}
+
+	// a finished download is not pulled again
+	before := client.pullTimes
+	if err := NewDownloader(client, config).Run(context.Background(), c.sink); err != nil {
+		t.Fatalf("third Run() error = %v", err)
+	}
+	if client.pullTimes != before {
+		t.Errorf("finished download pulled %d times", client.pullTimes-before)
+	}
+}
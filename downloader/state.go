@@ -0,0 +1,87 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ShardProgress is the download progress of one shard.
+type ShardProgress struct {
+	ShardID     int    `json:"shardId"`
+	BeginCursor string `json:"beginCursor"`
+	EndCursor   string `json:"endCursor"`
+	// NextCursor is the cursor of the first log group not yet delivered to the sink
+	NextCursor    string `json:"nextCursor"`
+	Done          bool   `json:"done"`
+	LogGroupCount int64  `json:"logGroupCount"`
+	LogCount      int64  `json:"logCount"`
+	RawSize       int64  `json:"rawSize"`
+}
+
+// state is persisted to Config.StateFile after every delivered fetch.
+type state struct {
+	Project  string                 `json:"project"`
+	Logstore string                 `json:"logstore"`
+	From     int64                  `json:"from"`
+	To       int64                  `json:"to"`
+	Query    string                 `json:"query,omitempty"`
+	Shards   map[int]*ShardProgress `json:"shards"`
+}
+
+func (s *state) matches(config *Config) bool {
+	return s.Project == config.Project && s.Logstore == config.Logstore &&
+		s.From == config.From && s.To == config.To && s.Query == config.Query
+}
+
+// loadState returns nil if the state file does not exist.
+func loadState(path string) (*state, error) {
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &state{}
+	if err := json.Unmarshal(buf, s); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// saveState writes to a temp file then renames it, so that a crash never leaves a partial state file.
+func saveState(path string, s *state) error {
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func sortProgress(progress []ShardProgress) {
+	sort.Slice(progress, func(i, j int) bool {
+		return progress[i].ShardID < progress[j].ShardID
+	})
+}
@@ -17,6 +17,7 @@ require (
 	github.com/stretchr/testify v1.5.1
 	go.uber.org/atomic v1.5.0
 	golang.org/x/net v0.0.0-20201021035429-f5854403a974
+	google.golang.org/protobuf v1.25.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 )
 
@@ -42,7 +43,6 @@ require (
 	github.com/tjfoc/gmsm v1.3.2 // indirect
 	golang.org/x/lint v0.0.0-20190930215403-16217165b5de // indirect
 	golang.org/x/tools v0.0.0-20210106214847-113979e3529a // indirect
-	google.golang.org/protobuf v1.25.0 // indirect
 	gopkg.in/ini.v1 v1.56.0 // indirect
 	gopkg.in/yaml.v2 v2.2.8 // indirect
 )
@@ -0,0 +1,587 @@
+package sls
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// RawLogKey is the key of a record that could not be parsed, like Logtail uploads it.
+const RawLogKey = "__raw_log__"
+
+// the quote of a delimiter config that means fields are not quoted
+const noQuote = "\u0001"
+
+// LogParser parses records locally with the parsing rules of a file config,
+// one of RegexConfigInputDetail, DelimiterConfigInputDetail, JSONConfigInputDetail
+// or ApsaraLogConfigInputDetail. The FilterKeys, FilterRegex and SensitiveKeys of
+// the config are applied to the parsed logs.
+type LogParser struct {
+	// TimeKey and TimeFormat extract the time of a log from a parsed key,
+	// TimeFormat is a strftime format like "%Y-%m-%d %H:%M:%S", or "%s" for unix seconds.
+	// They are initialized from the config, the time of a log without TimeKey is now.
+	TimeKey    string
+	TimeFormat string
+	// Location is the time zone of times without a zone, initialized from
+	// LogTimeZone if AdjustTimeZone is set, or time.Local.
+	Location *time.Location
+	// DiscardUnmatch is initialized from the config, records that fail to parse
+	// are dropped instead of being kept as __raw_log__.
+	DiscardUnmatch bool
+
+	beginRegex *regexp.Regexp
+	regex      *regexp.Regexp
+	keys       []string
+	separator  string
+	quote      string
+	autoExtend bool
+	acceptLess bool
+	apsara     bool
+	filters    []logFilter
+	masks      []*SensitiveMask
+}
+
+// logFilter keeps the logs whose key matches the regex
+type logFilter struct {
+	key   string
+	regex *regexp.Regexp
+}
+
+// SensitiveMask masks the values of a key like a SensitiveKey of a logtail
+// config, it is safe for concurrent use.
+type SensitiveMask struct {
+	// Key is the key of the contents masked
+	Key   string
+	regex *regexp.Regexp
+	// content is the index of the content group, after the groups of the begin
+	content int
+	md5     bool
+	value   string
+	all     bool
+}
+
+// NewSensitiveMask compiles a SensitiveKey: the content matching RegexContent
+// after RegexBegin is replaced by ConstString with the "const" Type, or by its
+// md5 with the "md5" Type, only the first match unless All.
+func NewSensitiveMask(k SensitiveKey) (*SensitiveMask, error) {
+	begin, err := regexp.Compile(k.RegexBegin)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sensitive regex of %s: %w", k.Key, err)
+	}
+	// the begin is kept and the content is replaced
+	regex, err := regexp.Compile("(" + k.RegexBegin + ")(" + k.RegexContent + ")")
+	if err != nil {
+		return nil, fmt.Errorf("invalid sensitive regex of %s: %w", k.Key, err)
+	}
+	switch k.Type {
+	case "const", "md5":
+	default:
+		return nil, fmt.Errorf("invalid sensitive type %q of %s", k.Type, k.Key)
+	}
+	return &SensitiveMask{
+		Key:     k.Key,
+		regex:   regex,
+		content: 2 + begin.NumSubexp(),
+		md5:     k.Type == "md5",
+		value:   k.ConstString,
+		all:     k.All,
+	}, nil
+}
+
+// Mask returns the masked value, or false if nothing matches.
+func (m *SensitiveMask) Mask(value string) (string, bool) {
+	n := 1
+	if m.all {
+		n = -1
+	}
+	matches := m.regex.FindAllStringSubmatchIndex(value, n)
+	if len(matches) == 0 {
+		return value, false
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		begin, end := match[2*m.content], match[2*m.content+1]
+		b.WriteString(value[last:begin])
+		if m.md5 {
+			sum := md5.Sum([]byte(value[begin:end]))
+			b.WriteString(hex.EncodeToString(sum[:]))
+		} else {
+			b.WriteString(m.value)
+		}
+		last = end
+	}
+	b.WriteString(value[last:])
+	return b.String(), true
+}
+
+// NewLogParser creates a LogParser from the input detail of a file config, the detail may be a
+// *RegexConfigInputDetail, *DelimiterConfigInputDetail, *JSONConfigInputDetail,
+// *ApsaraLogConfigInputDetail, or the map[string]interface{} returned by GetConfig.
+func NewLogParser(detail InputDetailInterface) (*LogParser, error) {
+	if m, ok := detail.(map[string]interface{}); ok {
+		logType, _ := GetFileConfigInputDetailType(m)
+		var converted InputDetailInterface
+		switch logType {
+		case LogFileTypeRegexLog:
+			converted, ok = ConvertToRegexConfigInputDetail(m)
+		case LogFileTypeDelimiterLog:
+			converted, ok = ConvertToDelimiterConfigInputDetail(m)
+		case LogFileTypeJSONLog:
+			converted, ok = ConvertToJSONConfigInputDetail(m)
+		case LogFileTypeApsaraLog:
+			converted, ok = ConvertToApsaraLogConfigInputDetail(m)
+		default:
+			return nil, fmt.Errorf("unsupported log type %q", logType)
+		}
+		if !ok {
+			return nil, InvalidTypeError
+		}
+		detail = converted
+	}
+
+	p := &LogParser{}
+	var file *LocalFileConfigInputDetail
+	switch d := detail.(type) {
+	case *RegexConfigInputDetail:
+		file = &d.LocalFileConfigInputDetail
+		if len(d.Key) == 0 {
+			return nil, fmt.Errorf("regex config has no key")
+		}
+		regex, err := compileFullRegex(d.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		if regex.NumSubexp() != len(d.Key) {
+			return nil, fmt.Errorf("regex has %d groups but %d keys", regex.NumSubexp(), len(d.Key))
+		}
+		p.regex, p.keys = regex, d.Key
+		if p.beginRegex, err = compileBeginRegex(d.LogBeginRegex); err != nil {
+			return nil, err
+		}
+		// Logtail extracts the time of a regex log from the key named time
+		p.TimeKey = "time"
+	case *DelimiterConfigInputDetail:
+		file = &d.LocalFileConfigInputDetail
+		if d.Separator == "" || len(d.Key) == 0 {
+			return nil, fmt.Errorf("delimiter config has no separator or key")
+		}
+		p.separator, p.quote, p.keys = d.Separator, d.Quote, d.Key
+		p.autoExtend, p.acceptLess = d.AutoExtend, d.AcceptNoEnoughKeys
+		p.TimeKey = d.TimeKey
+	case *JSONConfigInputDetail:
+		file = &d.LocalFileConfigInputDetail
+		p.TimeKey = d.TimeKey
+	case *ApsaraLogConfigInputDetail:
+		file = &d.LocalFileConfigInputDetail
+		p.apsara = true
+		var err error
+		if p.beginRegex, err = compileBeginRegex(d.LogBeginRegex); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, InvalidTypeError
+	}
+	p.TimeFormat = file.TimeFormat
+	if p.TimeFormat == "" {
+		p.TimeKey = ""
+	}
+	p.DiscardUnmatch = file.DiscardUnmatch
+	p.Location = time.Local
+	if file.AdjustTimeZone && file.LogTimeZone != "" {
+		loc, err := parseLogTimeZone(file.LogTimeZone)
+		if err != nil {
+			return nil, err
+		}
+		p.Location = loc
+	}
+	if err := p.initFilters(&file.CommonConfigInputDetail); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *LogParser) initFilters(common *CommonConfigInputDetail) error {
+	if len(common.FilterKeys) != len(common.FilterRegex) {
+		return fmt.Errorf("%d filter keys but %d filter regexes", len(common.FilterKeys), len(common.FilterRegex))
+	}
+	for i, key := range common.FilterKeys {
+		regex, err := compileFullRegex(common.FilterRegex[i])
+		if err != nil {
+			return fmt.Errorf("invalid filter regex of %s: %w", key, err)
+		}
+		p.filters = append(p.filters, logFilter{key: key, regex: regex})
+	}
+	for _, k := range common.SensitiveKeys {
+		mask, err := NewSensitiveMask(k)
+		if err != nil {
+			return err
+		}
+		p.masks = append(p.masks, mask)
+	}
+	return nil
+}
+
+// IsBeginLine reports whether a line begins a new record, it is always true
+// unless the config has a LogBeginRegex other than ".*".
+func (p *LogParser) IsBeginLine(line string) bool {
+	return p.beginRegex == nil || p.beginRegex.MatchString(line)
+}
+
+// Parse parses one record, which may have several lines. It returns a nil log
+// without error if the log is dropped by the filters of the config.
+func (p *LogParser) Parse(record string) (*Log, error) {
+	if p.apsara {
+		log, err := parseApsaraLog(record, p.Location)
+		if err != nil {
+			return nil, err
+		}
+		return p.filterAndMask(log), nil
+	}
+	var contents []*LogContent
+	var err error
+	switch {
+	case p.regex != nil:
+		contents, err = p.parseRegex(record)
+	case p.separator != "":
+		contents, err = p.parseDelimiter(record)
+	default:
+		contents, err = parseJSONLog(record)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log := &Log{Contents: contents}
+	t := time.Now()
+	if p.TimeKey != "" {
+		value, ok := getContent(contents, p.TimeKey)
+		if !ok {
+			return nil, fmt.Errorf("time key %s not found", p.TimeKey)
+		}
+		if t, err = ParseStrftime(p.TimeFormat, value, p.Location); err != nil {
+			return nil, err
+		}
+	}
+	log.Time = proto.Uint32(uint32(t.Unix()))
+	log.TimeNs = proto.Uint32(uint32(t.Nanosecond()))
+	return p.filterAndMask(log), nil
+}
+
+// filterAndMask returns nil if a filter does not match, the masks are applied in place
+func (p *LogParser) filterAndMask(log *Log) *Log {
+	for _, f := range p.filters {
+		value, ok := getContent(log.Contents, f.key)
+		if !ok || !f.regex.MatchString(value) {
+			return nil
+		}
+	}
+	for _, m := range p.masks {
+		for _, c := range log.Contents {
+			if c.GetKey() != m.Key {
+				continue
+			}
+			if value, ok := m.Mask(c.GetValue()); ok {
+				c.Value = proto.String(value)
+			}
+		}
+	}
+	return log
+}
+
+// parseApsaraLog parses a log of the apsara format:
+//
+//	[2024-01-02 03:04:05.123456]\t[INFO]\t[12345]\t[/src/file.cpp:10]\t\tkey:value\tkey2:value2
+//
+// The time is the log time and the microtime content, the level, thread and
+// file are __LEVEL__, __THREAD__, __FILE__ and __LINE__, and the key:value
+// fields are contents.
+func parseApsaraLog(record string, loc *time.Location) (*Log, error) {
+	fields := strings.Split(record, "\t")
+	var brackets []string
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "[") && strings.HasSuffix(fields[0], "]") {
+		brackets = append(brackets, fields[0][1:len(fields[0])-1])
+		fields = fields[1:]
+	}
+	if len(brackets) == 0 {
+		return nil, fmt.Errorf("no apsara time")
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05.999999", brackets[0], loc)
+	if err != nil {
+		return nil, err
+	}
+	log := &Log{Time: proto.Uint32(uint32(t.Unix())), TimeNs: proto.Uint32(uint32(t.Nanosecond()))}
+	add := func(key, value string) {
+		log.Contents = append(log.Contents, &LogContent{Key: proto.String(key), Value: proto.String(value)})
+	}
+	add("microtime", strconv.FormatInt(t.UnixNano()/int64(time.Microsecond), 10))
+	for i, value := range brackets[1:] {
+		switch i {
+		case 0:
+			add("__LEVEL__", value)
+		case 1:
+			add("__THREAD__", value)
+		case 2:
+			if j := strings.LastIndexByte(value, ':'); j >= 0 {
+				add("__FILE__", value[:j])
+				add("__LINE__", value[j+1:])
+			} else {
+				add("__FILE__", value)
+			}
+		}
+	}
+	for _, field := range fields {
+		if i := strings.IndexByte(field, ':'); i > 0 {
+			add(field[:i], field[i+1:])
+		}
+	}
+	return log, nil
+}
+
+func (p *LogParser) parseRegex(record string) ([]*LogContent, error) {
+	match := p.regex.FindStringSubmatch(record)
+	if match == nil {
+		return nil, fmt.Errorf("regex does not match")
+	}
+	contents := make([]*LogContent, 0, len(p.keys))
+	for i, key := range p.keys {
+		contents = append(contents, &LogContent{Key: proto.String(key), Value: proto.String(match[i+1])})
+	}
+	return contents, nil
+}
+
+func (p *LogParser) parseDelimiter(record string) ([]*LogContent, error) {
+	fields, err := splitDelimited(record, p.separator, p.quote)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < len(p.keys) && !p.acceptLess {
+		return nil, fmt.Errorf("%d fields is less than %d keys", len(fields), len(p.keys))
+	}
+	if len(fields) > len(p.keys) && !p.autoExtend {
+		return nil, fmt.Errorf("%d fields is more than %d keys", len(fields), len(p.keys))
+	}
+	contents := make([]*LogContent, 0, len(fields))
+	for i, field := range fields {
+		// extra fields are named like Logtail does
+		key := fmt.Sprintf("__column%d__", i)
+		if i < len(p.keys) {
+			key = p.keys[i]
+		}
+		contents = append(contents, &LogContent{Key: proto.String(key), Value: proto.String(field)})
+	}
+	return contents, nil
+}
+
+// splitDelimited splits a record by a separator, a field starting with the quote
+// ends at the next quote, and two quotes in a quoted field are one quote
+func splitDelimited(record, separator, quote string) ([]string, error) {
+	if quote == "" || quote == noQuote {
+		return strings.Split(record, separator), nil
+	}
+	var fields []string
+	for {
+		if !strings.HasPrefix(record, quote) {
+			i := strings.Index(record, separator)
+			if i < 0 {
+				return append(fields, record), nil
+			}
+			fields = append(fields, record[:i])
+			record = record[i+len(separator):]
+			continue
+		}
+		var field strings.Builder
+		rest := record[len(quote):]
+		for {
+			i := strings.Index(rest, quote)
+			if i < 0 {
+				return nil, fmt.Errorf("unclosed quote")
+			}
+			field.WriteString(rest[:i])
+			rest = rest[i+len(quote):]
+			if strings.HasPrefix(rest, quote) {
+				field.WriteString(quote)
+				rest = rest[len(quote):]
+				continue
+			}
+			break
+		}
+		fields = append(fields, field.String())
+		if rest == "" {
+			return fields, nil
+		}
+		if !strings.HasPrefix(rest, separator) {
+			return nil, fmt.Errorf("no separator after quoted field")
+		}
+		record = rest[len(separator):]
+	}
+}
+
+// parseJSONLog parses the keys of a JSON object in their order, values that are not strings are kept as JSON
+func parseJSONLog(record string) ([]*LogContent, error) {
+	decoder := json.NewDecoder(strings.NewReader(record))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, fmt.Errorf("not a JSON object")
+	}
+	var contents []*LogContent
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := token.(string)
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, err
+		}
+		value := string(raw)
+		if len(raw) > 0 && raw[0] == '"' {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, err
+			}
+		}
+		contents = append(contents, &LogContent{Key: proto.String(key), Value: proto.String(value)})
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+func getContent(contents []*LogContent, key string) (string, bool) {
+	for _, c := range contents {
+		if c.GetKey() == key {
+			return c.GetValue(), true
+		}
+	}
+	return "", false
+}
+
+// compileFullRegex compiles a regex that must match a whole record, "." matches
+// line breaks of multiline records like in Logtail regexes
+func compileFullRegex(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?s)^(?:" + expr + ")$")
+}
+
+// compileBeginRegex returns nil for the default ".*", every line begins a record
+func compileBeginRegex(expr string) (*regexp.Regexp, error) {
+	if expr == "" || expr == ".*" {
+		return nil, nil
+	}
+	regex, err := compileFullRegex(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log begin regex: %w", err)
+	}
+	return regex, nil
+}
+
+// parseLogTimeZone parses a LogTimeZone such as "GMT+08:00"
+func parseLogTimeZone(zone string) (*time.Location, error) {
+	offset := strings.TrimPrefix(zone, "GMT")
+	if offset == "" {
+		return time.UTC, nil
+	}
+	t, err := time.Parse("-07:00", offset)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log time zone %q", zone)
+	}
+	_, seconds := t.Zone()
+	return time.FixedZone(zone, seconds), nil
+}
+
+// strftimeLayouts are the Go layouts of the strftime directives
+var strftimeLayouts = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM",
+	'b': "Jan", 'h': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'z': "-0700", 'Z': "MST", 'f': "999999999",
+	'F': "2006-01-02", 'T': "15:04:05", 'D': "01/02/06", 'R': "15:04",
+	'%': "%",
+}
+
+// ParseStrftime parses a time with a strftime format, as used by the TimeFormat of
+// file configs. "%s" parses unix seconds, "%f" the fraction of a second after
+// "." or ",". Times without a zone are in loc.
+func ParseStrftime(format, value string, loc *time.Location) (time.Time, error) {
+	if format == "%s" {
+		seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	var layout strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			layout.WriteByte(format[i])
+			continue
+		}
+		i++
+		l, ok := strftimeLayouts[format[i]]
+		if !ok {
+			return time.Time{}, fmt.Errorf("unsupported time format %%%c", format[i])
+		}
+		layout.WriteString(l)
+	}
+	return time.ParseInLocation(layout.String(), value, loc)
+}
+
+// LogConfigTestResult is the result of a record of the sample in ApplyLogConfig.
+type LogConfigTestResult struct {
+	// Line is the number of the first line of the record, starting from 1
+	Line int
+	Raw  string
+	// Log is nil if the record fails to parse or is dropped by the filters
+	Log      *Log
+	Filtered bool
+	Err      error
+}
+
+// ApplyLogConfig applies the parsing rules of a file config to the sample text
+// locally, the LogSample of the config is used if sample is empty. The lines
+// are grouped into records by the LogBeginRegex of the config and every record
+// has a result.
+func ApplyLogConfig(config *LogConfig, sample string) ([]*LogConfigTestResult, error) {
+	if config.InputType != InputTypeFile {
+		return nil, fmt.Errorf("unsupported input type %q", config.InputType)
+	}
+	parser, err := NewLogParser(config.InputDetail)
+	if err != nil {
+		return nil, err
+	}
+	if sample == "" {
+		sample = config.LogSample
+	}
+	if sample == "" {
+		return nil, nil
+	}
+	var results []*LogConfigTestResult
+	var record []string
+	first := 0
+	parse := func() {
+		if len(record) == 0 {
+			return
+		}
+		result := &LogConfigTestResult{Line: first, Raw: strings.Join(record, "\n")}
+		result.Log, result.Err = parser.Parse(result.Raw)
+		result.Filtered = result.Log == nil && result.Err == nil
+		results = append(results, result)
+		record = record[:0]
+	}
+	for i, line := range strings.Split(strings.TrimSuffix(sample, "\n"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if parser.IsBeginLine(line) || len(record) == 0 {
+			parse()
+			first = i + 1
+		}
+		record = append(record, line)
+	}
+	parse()
+	return results, nil
+}
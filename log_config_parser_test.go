@@ -0,0 +1,203 @@
+package sls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parsedContents(log *Log) map[string]string {
+	contents := map[string]string{}
+	for _, c := range log.Contents {
+		contents[c.GetKey()] = c.GetValue()
+	}
+	return contents
+}
+
+func TestLogParserDelimiter(t *testing.T) {
+	detail := &DelimiterConfigInputDetail{}
+	InitDelimiterConfigInputDetail(detail)
+	detail.Separator = ","
+	detail.Quote = `"`
+	detail.Key = []string{"time", "user", "message"}
+	detail.TimeKey = "time"
+	detail.TimeFormat = "%s"
+	p, err := NewLogParser(detail)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		record  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{`1700000000,bob,"hello, ""world"""`, map[string]string{"time": "1700000000", "user": "bob", "message": `hello, "world"`}, false},
+		{`1700000000,bob,hi,extra`, map[string]string{"time": "1700000000", "user": "bob", "message": "hi", "__column3__": "extra"}, false},
+		{`1700000000,bob`, nil, true},
+		{`1700000000,"bob`, nil, true},
+		{`now,bob,hi`, nil, true},
+	}
+	for _, tt := range tests {
+		log, err := p.Parse(tt.record)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. Parse() error = %v, wantErr %v", tt.record, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			assert.Equal(t, tt.want, parsedContents(log), tt.record)
+			assert.Equal(t, uint32(1700000000), log.GetTime(), tt.record)
+		}
+	}
+}
+
+func TestLogParserJSON(t *testing.T) {
+	p, err := NewLogParser(map[string]interface{}{
+		"logType":        LogFileTypeJSONLog,
+		"timeKey":        "ts",
+		"timeFormat":     "%Y-%m-%dT%H:%M:%S.%f%z",
+		"discardUnmatch": true,
+	})
+	assert.Nil(t, err)
+	assert.True(t, p.DiscardUnmatch)
+	log, err := p.Parse(`{"ts":"2024-01-02T03:04:05.123+0800","n":1.50,"obj":{"a":[1]},"s":"x\ty"}`)
+	assert.Nil(t, err)
+	var keys []string
+	for _, c := range log.Contents {
+		keys = append(keys, c.GetKey())
+	}
+	assert.Equal(t, []string{"ts", "n", "obj", "s"}, keys)
+	assert.Equal(t, map[string]string{"ts": "2024-01-02T03:04:05.123+0800", "n": "1.50", "obj": `{"a":[1]}`, "s": "x\ty"}, parsedContents(log))
+	assert.Equal(t, uint32(1704135845), log.GetTime())
+	assert.Equal(t, uint32(123000000), log.GetTimeNs())
+
+	_, err = p.Parse(`[1]`)
+	assert.NotNil(t, err)
+}
+
+func TestParseStrftime(t *testing.T) {
+	loc := time.FixedZone("GMT+08:00", 8*3600)
+	tests := []struct {
+		format string
+		value  string
+		want   int64
+	}{
+		{"%Y-%m-%d %H:%M:%S", "2024-01-02 03:04:05", 1704135845},
+		{"%d/%b/%Y:%H:%M:%S %z", "02/Jan/2024:03:04:05 +0000", 1704164645},
+		{"[%F %T,%f]", "[2024-01-02 03:04:05,5]", 1704135845},
+	}
+	for _, tt := range tests {
+		got, err := ParseStrftime(tt.format, tt.value, loc)
+		if err != nil || got.Unix() != tt.want {
+			t.Errorf("%q. ParseStrftime() = %v, %v, want %v", tt.format, got.Unix(), err, tt.want)
+		}
+	}
+	_, err := ParseStrftime("%Q", "x", loc)
+	assert.NotNil(t, err)
+
+	zone, err := parseLogTimeZone("GMT+08:00")
+	assert.Nil(t, err)
+	_, offset := time.Unix(0, 0).In(zone).Zone()
+	assert.Equal(t, 8*3600, offset)
+}
+
+func TestLogParserApsara(t *testing.T) {
+	detail := &ApsaraLogConfigInputDetail{}
+	detail.LogType = LogFileTypeApsaraLog
+	detail.LogBeginRegex = `\[\d+-\d+-\d+ .*`
+	detail.AdjustTimeZone = true
+	detail.LogTimeZone = "GMT+08:00"
+	p, err := NewLogParser(detail)
+	assert.Nil(t, err)
+	assert.False(t, p.IsBeginLine("\tat main.go"))
+
+	log, err := p.Parse("[2024-01-02 03:04:05.123456]\t[INFO]\t[42]\t[/src/main.cpp:10]\t\tuser:bob\tmsg:a:b")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"microtime":  "1704135845123456",
+		"__LEVEL__":  "INFO",
+		"__THREAD__": "42",
+		"__FILE__":   "/src/main.cpp",
+		"__LINE__":   "10",
+		"user":       "bob",
+		"msg":        "a:b",
+	}, parsedContents(log))
+	assert.Equal(t, uint32(1704135845), log.GetTime())
+	assert.Equal(t, uint32(123456000), log.GetTimeNs())
+
+	_, err = p.Parse("INFO no time")
+	assert.NotNil(t, err)
+}
+
+func TestLogParserFilterAndMask(t *testing.T) {
+	detail := &RegexConfigInputDetail{}
+	InitRegexConfigInputDetail(detail)
+	detail.Regex = `(\w+) (\S+) (.*)`
+	detail.Key = []string{"level", "user", "message"}
+	detail.FilterKeys = []string{"level"}
+	detail.FilterRegex = []string{"WARN|ERROR"}
+	detail.SensitiveKeys = []SensitiveKey{
+		{Key: "message", Type: "const", RegexBegin: "password=", RegexContent: `[^,]+`, ConstString: "***"},
+		{Key: "user", Type: "md5", RegexBegin: "^", RegexContent: `.+`, All: true},
+	}
+	p, err := NewLogParser(detail)
+	assert.Nil(t, err)
+
+	log, err := p.Parse("ERROR bob password=1,password=2")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"level":   "ERROR",
+		"user":    "9f9d51bc70ef21ca5c14f307980a29d8",
+		"message": "password=***,password=2",
+	}, parsedContents(log))
+
+	log, err = p.Parse("INFO bob hello")
+	assert.Nil(t, err)
+	assert.Nil(t, log)
+
+	// the groups of the begin are not the content
+	mask, err := NewSensitiveMask(SensitiveKey{Key: "k", Type: "md5", RegexBegin: "(phone|tel):", RegexContent: `\d+`, All: true})
+	assert.Nil(t, err)
+	masked, ok := mask.Mask("phone:1 tel:2 fax:3")
+	assert.True(t, ok)
+	assert.Equal(t, "phone:c4ca4238a0b923820dcc509a6f75849b tel:c81e728d9d4c2f636f067f89cc14862c fax:3", masked)
+	_, ok = mask.Mask("fax:3")
+	assert.False(t, ok)
+
+	detail.SensitiveKeys[0].Type = "hash"
+	_, err = NewLogParser(detail)
+	assert.NotNil(t, err)
+	detail.SensitiveKeys = nil
+	detail.FilterRegex = nil
+	_, err = NewLogParser(detail)
+	assert.NotNil(t, err)
+}
+
+func TestApplyLogConfig(t *testing.T) {
+	detail := &RegexConfigInputDetail{}
+	InitRegexConfigInputDetail(detail)
+	detail.LogBeginRegex = `\d+ .*`
+	detail.Regex = `(\d+) (\w+) (.*)`
+	detail.Key = []string{"time", "level", "message"}
+	detail.TimeFormat = "%s"
+	detail.FilterKeys = []string{"level"}
+	detail.FilterRegex = []string{"ERROR"}
+	config := &LogConfig{
+		InputType:   InputTypeFile,
+		InputDetail: detail,
+		LogSample:   "1700000000 ERROR panic\n\tat main.go:1\n1700000001 INFO ok\n1700000002 oops\n",
+	}
+	results, err := ApplyLogConfig(config, "")
+	assert.Nil(t, err)
+	if assert.Equal(t, 3, len(results)) {
+		assert.Equal(t, 1, results[0].Line)
+		assert.Equal(t, "panic\n\tat main.go:1", parsedContents(results[0].Log)["message"])
+		assert.Equal(t, 3, results[1].Line)
+		assert.True(t, results[1].Filtered)
+		assert.Equal(t, 4, results[2].Line)
+		assert.NotNil(t, results[2].Err)
+	}
+
+	config.InputType = InputTypePlugin
+	_, err = ApplyLogConfig(config, "")
+	assert.NotNil(t, err)
+}
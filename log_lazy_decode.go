@@ -0,0 +1,383 @@
+package sls
+
+import (
+	"errors"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// ErrLazyDecode is returned when the lazily decoded data is not a valid LogGroupList.
+var ErrLazyDecode = errors.New("invalid protobuf data of LogGroupList")
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoField is one field read from a protobuf message.
+type protoField struct {
+	num      int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+func readVarint(data []byte, pos int) (uint64, int, bool) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if pos >= len(data) {
+			return 0, 0, false
+		}
+		b := data[pos]
+		pos++
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return v, pos, true
+		}
+	}
+	return 0, 0, false
+}
+
+// readField reads the field at pos and returns the position of the next field.
+func readField(data []byte, pos int, f *protoField) (int, error) {
+	key, pos, ok := readVarint(data, pos)
+	if !ok {
+		return 0, ErrLazyDecode
+	}
+	f.num, f.wireType = int(key>>3), int(key&7)
+	switch f.wireType {
+	case wireVarint:
+		if f.varint, pos, ok = readVarint(data, pos); !ok {
+			return 0, ErrLazyDecode
+		}
+	case wireFixed64:
+		if pos+8 > len(data) {
+			return 0, ErrLazyDecode
+		}
+		f.varint = uint64(data[pos]) | uint64(data[pos+1])<<8 | uint64(data[pos+2])<<16 | uint64(data[pos+3])<<24 |
+			uint64(data[pos+4])<<32 | uint64(data[pos+5])<<40 | uint64(data[pos+6])<<48 | uint64(data[pos+7])<<56
+		pos += 8
+	case wireFixed32:
+		if pos+4 > len(data) {
+			return 0, ErrLazyDecode
+		}
+		f.varint = uint64(data[pos]) | uint64(data[pos+1])<<8 | uint64(data[pos+2])<<16 | uint64(data[pos+3])<<24
+		pos += 4
+	case wireBytes:
+		var n uint64
+		if n, pos, ok = readVarint(data, pos); !ok || n > uint64(len(data)-pos) {
+			return 0, ErrLazyDecode
+		}
+		f.bytes = data[pos : pos+int(n)]
+		pos += int(n)
+	default:
+		return 0, ErrLazyDecode
+	}
+	return pos, nil
+}
+
+// LazyLogGroupList reads a protobuf encoded LogGroupList, such as the data
+// returned by GetLogsBytesWithQuery, without decoding it into structs.
+// All byte slices returned by it and its iterators point into the buffer and
+// are only valid as long as the buffer is not modified.
+type LazyLogGroupList struct {
+	data []byte
+}
+
+// NewLazyLogGroupList wraps the decompressed protobuf data of a LogGroupList.
+func NewLazyLogGroupList(data []byte) *LazyLogGroupList {
+	return &LazyLogGroupList{data: data}
+}
+
+// Bytes returns the underlying buffer.
+func (l *LazyLogGroupList) Bytes() []byte {
+	return l.data
+}
+
+// Len returns the number of log groups.
+func (l *LazyLogGroupList) Len() (int, error) {
+	count := 0
+	var f protoField
+	for pos := 0; pos < len(l.data); {
+		var err error
+		if pos, err = readField(l.data, pos, &f); err != nil {
+			return 0, err
+		}
+		if f.num == 1 && f.wireType == wireBytes {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Groups returns an iterator of the log groups.
+func (l *LazyLogGroupList) Groups() LazyLogGroupIterator {
+	return LazyLogGroupIterator{data: l.data}
+}
+
+// Decode fully decodes the data, same as LogsBytesDecode.
+func (l *LazyLogGroupList) Decode() (*LogGroupList, error) {
+	return LogsBytesDecode(l.data)
+}
+
+// LazyLogGroupIterator iterates over log groups, eg.
+//
+//	it := list.Groups()
+//	for it.Next() {
+//		group := it.Group()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type LazyLogGroupIterator struct {
+	data  []byte
+	pos   int
+	err   error
+	group LazyLogGroup
+}
+
+// Next moves to the next group, it returns false at the end or on error.
+func (it *LazyLogGroupIterator) Next() bool {
+	var f protoField
+	for it.err == nil && it.pos < len(it.data) {
+		if it.pos, it.err = readField(it.data, it.pos, &f); it.err != nil {
+			return false
+		}
+		if f.num == 1 && f.wireType == wireBytes {
+			it.err = it.group.reset(f.bytes)
+			return it.err == nil
+		}
+	}
+	return false
+}
+
+// Group returns the current group, it is reused by Next.
+func (it *LazyLogGroupIterator) Group() *LazyLogGroup {
+	return &it.group
+}
+
+// Err returns the decoding error that stopped the iteration.
+func (it *LazyLogGroupIterator) Err() error {
+	return it.err
+}
+
+// LazyLogGroup is a view of one encoded LogGroup.
+type LazyLogGroup struct {
+	data        []byte
+	topic       []byte
+	source      []byte
+	category    []byte
+	machineUUID []byte
+	logCount    int
+}
+
+func (g *LazyLogGroup) reset(data []byte) error {
+	*g = LazyLogGroup{data: data}
+	var f protoField
+	for pos := 0; pos < len(data); {
+		var err error
+		if pos, err = readField(data, pos, &f); err != nil {
+			return err
+		}
+		if f.wireType != wireBytes {
+			continue
+		}
+		switch f.num {
+		case 1:
+			g.logCount++
+		case 2:
+			g.category = f.bytes
+		case 3:
+			g.topic = f.bytes
+		case 4:
+			g.source = f.bytes
+		case 5:
+			g.machineUUID = f.bytes
+		}
+	}
+	return nil
+}
+
+// Topic returns the topic of the group, it is nil if not set.
+func (g *LazyLogGroup) Topic() []byte { return g.topic }
+
+// Source returns the source of the group, it is nil if not set.
+func (g *LazyLogGroup) Source() []byte { return g.source }
+
+// Category returns the category of the group, it is nil if not set.
+func (g *LazyLogGroup) Category() []byte { return g.category }
+
+// MachineUUID returns the machine uuid of the group, it is nil if not set.
+func (g *LazyLogGroup) MachineUUID() []byte { return g.machineUUID }
+
+// LogCount returns the number of logs in the group.
+func (g *LazyLogGroup) LogCount() int { return g.logCount }
+
+// Logs returns an iterator of the logs.
+func (g *LazyLogGroup) Logs() LazyLogIterator {
+	return LazyLogIterator{data: g.data}
+}
+
+// Tags returns an iterator of the log tags.
+func (g *LazyLogGroup) Tags() LazyKeyValueIterator {
+	return LazyKeyValueIterator{data: g.data, fieldNum: 6}
+}
+
+// Tag returns the value of the first tag with the key.
+func (g *LazyLogGroup) Tag(key string) ([]byte, bool) {
+	it := g.Tags()
+	return it.find(key)
+}
+
+// Decode fully decodes the group, eg. to keep a log group that passed a filter.
+func (g *LazyLogGroup) Decode() (*LogGroup, error) {
+	lg := &LogGroup{}
+	if err := proto.Unmarshal(g.data, lg); err != nil {
+		return nil, err
+	}
+	return lg, nil
+}
+
+// LazyLogIterator iterates over the logs of a group.
+type LazyLogIterator struct {
+	data []byte
+	pos  int
+	err  error
+	log  LazyLog
+}
+
+// Next moves to the next log, it returns false at the end or on error.
+func (it *LazyLogIterator) Next() bool {
+	var f protoField
+	for it.err == nil && it.pos < len(it.data) {
+		if it.pos, it.err = readField(it.data, it.pos, &f); it.err != nil {
+			return false
+		}
+		if f.num == 1 && f.wireType == wireBytes {
+			it.err = it.log.reset(f.bytes)
+			return it.err == nil
+		}
+	}
+	return false
+}
+
+// Log returns the current log, it is reused by Next.
+func (it *LazyLogIterator) Log() *LazyLog {
+	return &it.log
+}
+
+// Err returns the decoding error that stopped the iteration.
+func (it *LazyLogIterator) Err() error {
+	return it.err
+}
+
+// LazyLog is a view of one encoded Log.
+type LazyLog struct {
+	data   []byte
+	time   uint32
+	timeNs uint32
+	hasNs  bool
+}
+
+func (l *LazyLog) reset(data []byte) error {
+	*l = LazyLog{data: data}
+	var f protoField
+	for pos := 0; pos < len(data); {
+		var err error
+		if pos, err = readField(data, pos, &f); err != nil {
+			return err
+		}
+		switch {
+		case f.num == 1 && f.wireType == wireVarint:
+			l.time = uint32(f.varint)
+		case f.num == 4 && f.wireType == wireFixed32:
+			l.timeNs, l.hasNs = uint32(f.varint), true
+		}
+	}
+	return nil
+}
+
+// Time returns the log time in unix seconds.
+func (l *LazyLog) Time() uint32 { return l.time }
+
+// TimeNs returns the nanosecond part of the log time and whether it is set.
+func (l *LazyLog) TimeNs() (uint32, bool) { return l.timeNs, l.hasNs }
+
+// Contents returns an iterator of the key value pairs of the log.
+func (l *LazyLog) Contents() LazyKeyValueIterator {
+	return LazyKeyValueIterator{data: l.data, fieldNum: 2}
+}
+
+// Get returns the value of the first content with the key, without allocating.
+func (l *LazyLog) Get(key string) ([]byte, bool) {
+	it := l.Contents()
+	return it.find(key)
+}
+
+// Decode fully decodes the log.
+func (l *LazyLog) Decode() (*Log, error) {
+	log := &Log{}
+	if err := proto.Unmarshal(l.data, log); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// LazyKeyValueIterator iterates over the contents of a log or the tags of a group.
+type LazyKeyValueIterator struct {
+	data     []byte
+	fieldNum int
+	pos      int
+	err      error
+	key      []byte
+	value    []byte
+}
+
+// Next moves to the next pair, it returns false at the end or on error.
+func (it *LazyKeyValueIterator) Next() bool {
+	var f protoField
+	for it.err == nil && it.pos < len(it.data) {
+		if it.pos, it.err = readField(it.data, it.pos, &f); it.err != nil {
+			return false
+		}
+		if f.num != it.fieldNum || f.wireType != wireBytes {
+			continue
+		}
+		it.key, it.value = nil, nil
+		var kv protoField
+		for pos := 0; pos < len(f.bytes); {
+			if pos, it.err = readField(f.bytes, pos, &kv); it.err != nil {
+				return false
+			}
+			if kv.wireType != wireBytes {
+				continue
+			}
+			if kv.num == 1 {
+				it.key = kv.bytes
+			} else if kv.num == 2 {
+				it.value = kv.bytes
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Key returns the key of the current pair.
+func (it *LazyKeyValueIterator) Key() []byte { return it.key }
+
+// Value returns the value of the current pair.
+func (it *LazyKeyValueIterator) Value() []byte { return it.value }
+
+// Err returns the decoding error that stopped the iteration.
+func (it *LazyKeyValueIterator) Err() error { return it.err }
+
+func (it *LazyKeyValueIterator) find(key string) ([]byte, bool) {
+	for it.Next() {
+		if string(it.key) == key {
+			return it.value, true
+		}
+	}
+	return nil, false
+}
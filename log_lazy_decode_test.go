@@ -0,0 +1,158 @@
+package sls
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeLazyTestData(groups, logsPerGroup int) []byte {
+	list := &LogGroupList{}
+	for g := 0; g < groups; g++ {
+		lg := &LogGroup{
+			Topic:   proto.String(fmt.Sprintf("topic-%d", g)),
+			Source:  proto.String("10.0.0.1"),
+			LogTags: []*LogTag{{Key: proto.String("host"), Value: proto.String("web-1")}},
+		}
+		for i := 0; i < logsPerGroup; i++ {
+			lg.Logs = append(lg.Logs, &Log{
+				Time:   proto.Uint32(uint32(1700000000 + i)),
+				TimeNs: proto.Uint32(uint32(i)),
+				Contents: []*LogContent{
+					{Key: proto.String("level"), Value: proto.String([]string{"INFO", "ERROR"}[i%2])},
+					{Key: proto.String("method"), Value: proto.String("GET")},
+					{Key: proto.String("path"), Value: proto.String("/api/v1/projects/test/logstores")},
+					{Key: proto.String("status"), Value: proto.String("200")},
+					{Key: proto.String("message"), Value: proto.String("request handled successfully by the backend server")},
+				},
+			})
+		}
+		list.LogGroups = append(list.LogGroups, lg)
+	}
+	data, _ := proto.Marshal(list)
+	return data
+}
+
+func TestLazyLogGroupList(t *testing.T) {
+	data := makeLazyTestData(3, 4)
+	list := NewLazyLogGroupList(data)
+	n, err := list.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	expected, err := LogsBytesDecode(data)
+	assert.Nil(t, err)
+
+	groups := list.Groups()
+	g := 0
+	for groups.Next() {
+		group := groups.Group()
+		want := expected.LogGroups[g]
+		assert.Equal(t, want.GetTopic(), string(group.Topic()))
+		assert.Equal(t, want.GetSource(), string(group.Source()))
+		assert.Nil(t, group.Category())
+		assert.Equal(t, 4, group.LogCount())
+		host, ok := group.Tag("host")
+		assert.True(t, ok)
+		assert.Equal(t, "web-1", string(host))
+
+		logs := group.Logs()
+		i := 0
+		for logs.Next() {
+			log := logs.Log()
+			assert.Equal(t, want.Logs[i].GetTime(), log.Time())
+			ns, ok := log.TimeNs()
+			assert.True(t, ok)
+			assert.Equal(t, want.Logs[i].GetTimeNs(), ns)
+
+			contents := log.Contents()
+			c := 0
+			for contents.Next() {
+				assert.Equal(t, want.Logs[i].Contents[c].GetKey(), string(contents.Key()))
+				assert.Equal(t, want.Logs[i].Contents[c].GetValue(), string(contents.Value()))
+				c++
+			}
+			assert.Nil(t, contents.Err())
+			assert.Equal(t, 5, c)
+
+			level, ok := log.Get("level")
+			assert.True(t, ok)
+			assert.Equal(t, want.Logs[i].Contents[0].GetValue(), string(level))
+			_, ok = log.Get("missing")
+			assert.False(t, ok)
+
+			decoded, err := log.Decode()
+			assert.Nil(t, err)
+			assert.Equal(t, want.Logs[i].String(), decoded.String())
+			i++
+		}
+		assert.Nil(t, logs.Err())
+		assert.Equal(t, 4, i)
+		g++
+	}
+	assert.Nil(t, groups.Err())
+	assert.Equal(t, 3, g)
+}
+
+func TestLazyLogGroupListInvalid(t *testing.T) {
+	data := makeLazyTestData(2, 2)
+	for _, bad := range [][]byte{data[:len(data)-3], {0x0a, 0xff}, {0x0f}} {
+		groups := NewLazyLogGroupList(bad).Groups()
+		for groups.Next() {
+			logs := groups.Group().Logs()
+			for logs.Next() {
+			}
+		}
+		assert.Equal(t, ErrLazyDecode, groups.Err(), "%x", bad)
+	}
+	empty := NewLazyLogGroupList(nil).Groups()
+	assert.False(t, empty.Next())
+	assert.Nil(t, empty.Err())
+}
+
+func BenchmarkLogsBytesDecode(b *testing.B) {
+	data := makeLazyTestData(100, 100)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		list, err := LogsBytesDecode(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		errors := 0
+		for _, lg := range list.LogGroups {
+			for _, log := range lg.Logs {
+				for _, c := range log.Contents {
+					if c.GetKey() == "level" && c.GetValue() == "ERROR" {
+						errors++
+					}
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkLazyDecode(b *testing.B) {
+	data := makeLazyTestData(100, 100)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		errors := 0
+		groups := NewLazyLogGroupList(data).Groups()
+		for groups.Next() {
+			logs := groups.Group().Logs()
+			for logs.Next() {
+				if level, ok := logs.Log().Get("level"); ok && string(level) == "ERROR" {
+					errors++
+				}
+			}
+		}
+		if groups.Err() != nil {
+			b.Fatal(groups.Err())
+		}
+	}
+}
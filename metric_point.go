@@ -0,0 +1,149 @@
+package sls
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// The keys of a log written to a MetricStore.
+const (
+	MetricNameKey     = "__name__"
+	MetricLabelsKey   = "__labels__"
+	MetricTimeNanoKey = "__time_nano__"
+	MetricValueKey    = "__value__"
+
+	// MetricLabelSeparator joins a label name and value, labels are joined by "|"
+	MetricLabelSeparator = "#$#"
+)
+
+// MetricPoint builds the log of one sample of a MetricStore. The labels are
+// sorted by name and encoded as __labels__, eg.
+//
+//	log, err := NewMetricPoint("http_requests_total").
+//		Label("method", "GET").
+//		Label("code", "200").
+//		Time(time.Now()).
+//		Value(1027).
+//		Log()
+type MetricPoint struct {
+	name     string
+	labels   []metricLabel
+	timeNano int64
+	value    float64
+}
+
+type metricLabel struct {
+	name  string
+	value string
+}
+
+// NewMetricPoint creates a MetricPoint of a metric, the time defaults to now.
+func NewMetricPoint(name string) *MetricPoint {
+	return &MetricPoint{name: name, timeNano: time.Now().UnixNano()}
+}
+
+// Label adds a label, labels with an empty value are omitted like in Prometheus.
+func (p *MetricPoint) Label(name, value string) *MetricPoint {
+	p.labels = append(p.labels, metricLabel{name: name, value: value})
+	return p
+}
+
+// Labels adds labels.
+func (p *MetricPoint) Labels(labels map[string]string) *MetricPoint {
+	for name, value := range labels {
+		p.Label(name, value)
+	}
+	return p
+}
+
+// Time sets the time of the sample.
+func (p *MetricPoint) Time(t time.Time) *MetricPoint {
+	p.timeNano = t.UnixNano()
+	return p
+}
+
+// TimeNano sets the time of the sample in unix nanoseconds.
+func (p *MetricPoint) TimeNano(timeNano int64) *MetricPoint {
+	p.timeNano = timeNano
+	return p
+}
+
+// Value sets the value of the sample.
+func (p *MetricPoint) Value(value float64) *MetricPoint {
+	p.value = value
+	return p
+}
+
+// Log validates the point and returns its log. The metric name must match
+// [a-zA-Z_:][a-zA-Z0-9_:]*, label names must match [a-zA-Z_][a-zA-Z0-9_]* and
+// be unique, and label values must not contain "|" or "#$#".
+func (p *MetricPoint) Log() (*Log, error) {
+	if !validMetricName(p.name) {
+		return nil, fmt.Errorf("invalid metric name %q", p.name)
+	}
+	labels := make([]metricLabel, 0, len(p.labels))
+	for _, l := range p.labels {
+		if !validLabelName(l.name) {
+			return nil, fmt.Errorf("invalid label name %q of metric %s", l.name, p.name)
+		}
+		if strings.Contains(l.value, "|") || strings.Contains(l.value, MetricLabelSeparator) {
+			return nil, fmt.Errorf("invalid value %q of label %s of metric %s", l.value, l.name, p.name)
+		}
+		if l.value != "" {
+			labels = append(labels, l)
+		}
+	}
+	sort.SliceStable(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+	var b strings.Builder
+	for i, l := range labels {
+		if i > 0 {
+			if labels[i-1].name == l.name {
+				return nil, fmt.Errorf("duplicate label %s of metric %s", l.name, p.name)
+			}
+			b.WriteByte('|')
+		}
+		b.WriteString(l.name)
+		b.WriteString(MetricLabelSeparator)
+		b.WriteString(l.value)
+	}
+	return &Log{
+		Time: proto.Uint32(uint32(p.timeNano / int64(time.Second))),
+		Contents: []*LogContent{
+			{Key: proto.String(MetricNameKey), Value: proto.String(p.name)},
+			{Key: proto.String(MetricLabelsKey), Value: proto.String(b.String())},
+			{Key: proto.String(MetricTimeNanoKey), Value: proto.String(strconv.FormatInt(p.timeNano, 10))},
+			{Key: proto.String(MetricValueKey), Value: proto.String(strconv.FormatFloat(p.value, 'g', -1, 64))},
+		},
+	}, nil
+}
+
+func validMetricName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if !(c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func validLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
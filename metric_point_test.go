@@ -0,0 +1,42 @@
+package sls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricPoint(t *testing.T) {
+	log, err := NewMetricPoint("http_requests_total").
+		Label("method", "GET").
+		Labels(map[string]string{"code": "200", "empty": ""}).
+		Time(time.Unix(1700000000, 5)).
+		Value(0.25).
+		Log()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1700000000), log.GetTime())
+	want := map[string]string{
+		"__name__":      "http_requests_total",
+		"__labels__":    "code#$#200|method#$#GET",
+		"__time_nano__": "1700000000000000005",
+		"__value__":     "0.25",
+	}
+	got := map[string]string{}
+	for _, c := range log.Contents {
+		got[c.GetKey()] = c.GetValue()
+	}
+	assert.Equal(t, want, got)
+
+	for _, p := range []*MetricPoint{
+		NewMetricPoint(""),
+		NewMetricPoint("1xx"),
+		NewMetricPoint("up").Label("a-b", "1"),
+		NewMetricPoint("up").Label("a", "x|y"),
+		NewMetricPoint("up").Label("a", "x#$#y"),
+		NewMetricPoint("up").Label("a", "1").Label("a", "2"),
+	} {
+		_, err := p.Log()
+		assert.NotNil(t, err, "%+v", p)
+	}
+}
@@ -0,0 +1,105 @@
+// Package mirror continuously replicates a logstore to another logstore,
+// eg. to a logstore in another region for disaster recovery.
+//
+// A consumer group reads the source and a producer writes the target.
+// The checkpoint of a fetch is saved only after all of its logs are written
+// to the target, so every log is replicated at least once: logs may be
+// duplicated after a failure or a rebalance, but are never lost.
+package mirror
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	consumerLibrary "github.com/aliyun/aliyun-log-go-sdk/consumer"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+	"github.com/aliyun/aliyun-log-go-sdk/spl"
+)
+
+// Config is the configuration of a Mirror.
+type Config struct {
+	// Source is the consumer group config of the source logstore.
+	// Query and AutoCommitDisabled are ignored, checkpoints are saved by the mirror.
+	Source consumerLibrary.LogHubConfig
+	// Target is the producer config of the target, it is recommended to set a small
+	// LingerMs such as 100, because every fetch waits until its logs are written.
+	Target         *producer.ProducerConfig
+	TargetProject  string
+	TargetLogstore string
+	// Query is an optional SPL query run locally on the fetched data before it is written,
+	// see package spl for the supported commands
+	Query string
+	// RewriteTopic and RewriteSource optionally change the topic and source of every log group
+	RewriteTopic  func(topic string) string
+	RewriteSource func(source string) string
+}
+
+// Mirror replicates a logstore. Log tags of the source are not replicated,
+// the target groups carry the tags in Target.LogTags.
+type Mirror struct {
+	worker    *consumerLibrary.ConsumerWorker
+	producer  *producer.Producer
+	processor *mirrorProcessor
+}
+
+// NewMirror creates a Mirror, it creates the consumer group of the source if needed.
+func NewMirror(config *Config) (*Mirror, error) {
+	if config.Target == nil {
+		return nil, errors.New("mirror: target producer config is required")
+	}
+	if config.TargetProject == "" || config.TargetLogstore == "" {
+		return nil, errors.New("mirror: target project and logstore are required")
+	}
+	var pipeline *spl.Pipeline
+	if config.Query != "" {
+		var err error
+		if pipeline, err = spl.Compile(config.Query); err != nil {
+			return nil, err
+		}
+	}
+	c := *config
+	source := c.Source
+	source.Query = ""
+	source.AutoCommitDisabled = false
+
+	p := producer.InitProducer(c.Target)
+	processor := newMirrorProcessor(&c, pipeline, p)
+	return &Mirror{
+		worker:    consumerLibrary.InitConsumerWorkerWithProcessor(source, processor),
+		producer:  p,
+		processor: processor,
+	}, nil
+}
+
+// Start starts the producer and the consumer worker.
+func (m *Mirror) Start() {
+	m.producer.Start()
+	m.worker.Start()
+}
+
+// StopAndWait stops consuming, flushes the checkpoints of replicated data and then closes the producer.
+func (m *Mirror) StopAndWait() {
+	m.worker.StopAndWait()
+	m.producer.SafeClose()
+}
+
+// Status returns the replication status of the shards this mirror has consumed, ordered by shard id.
+func (m *Mirror) Status() []ShardStatus {
+	status := m.processor.status()
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].ShardID < status[j].ShardID
+	})
+	return status
+}
+
+// Lag returns the max replication lag of all shards.
+func (m *Mirror) Lag() time.Duration {
+	var lag time.Duration
+	for _, s := range m.processor.status() {
+		if s.Lag > lag {
+			lag = s.Lag
+		}
+	}
+	return lag
+}
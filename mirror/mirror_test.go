@@ -0,0 +1,133 @@
+package mirror
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+	"github.com/aliyun/aliyun-log-go-sdk/spl"
+	"github.com/gogo/protobuf/proto"
+)
+
+type sent struct {
+	topic, source string
+	logs          []*sls.Log
+}
+
+// fakeSender calls the callbacks asynchronously, like the producer does.
+type fakeSender struct {
+	mutex   sync.Mutex
+	sent    []sent
+	fail    int
+	sendErr error
+}
+
+func (s *fakeSender) SendLogListWithCallBack(project, logstore, topic, source string, logList []*sls.Log, callback producer.CallBack) error {
+	if s.sendErr != nil {
+		return s.sendErr
+	}
+	s.mutex.Lock()
+	fail := s.fail > 0
+	if fail {
+		s.fail--
+	} else {
+		s.sent = append(s.sent, sent{topic, source, logList})
+	}
+	s.mutex.Unlock()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		if fail {
+			callback.Fail(nil)
+		} else {
+			callback.Success(nil)
+		}
+	}()
+	return nil
+}
+
+type fakeTracker struct {
+	saved int
+}
+
+func (t *fakeTracker) GetCheckPoint() string     { return "" }
+func (t *fakeTracker) SaveCheckPoint(bool) error { t.saved++; return nil }
+func (t *fakeTracker) GetCurrentCursor() string  { return "" }
+func (t *fakeTracker) GetNextCursor() string     { return "" }
+func (t *fakeTracker) GetShardId() int           { return 0 }
+
+func makeLogGroupList() *sls.LogGroupList {
+	makeLog := func(t uint32, level string) *sls.Log {
+		return &sls.Log{Time: proto.Uint32(t), Contents: []*sls.LogContent{{Key: proto.String("level"), Value: proto.String(level)}}}
+	}
+	return &sls.LogGroupList{LogGroups: []*sls.LogGroup{
+		{Topic: proto.String("app"), Source: proto.String("host-1"), Logs: []*sls.Log{makeLog(100, "INFO"), makeLog(101, "ERROR")}},
+		{Topic: proto.String("app"), Source: proto.String("host-2"), Logs: []*sls.Log{makeLog(102, "DEBUG")}},
+	}}
+}
+
+func TestProcessSavesCheckpointAfterCallbacks(t *testing.T) {
+	s := &fakeSender{fail: 1}
+	config := &Config{
+		TargetProject:  "p",
+		TargetLogstore: "l",
+		RewriteTopic:   strings.ToUpper,
+		RewriteSource:  func(source string) string { return "dr-" + source },
+	}
+	p := newMirrorProcessor(config, nil, s)
+	tracker := &fakeTracker{}
+
+	// one of the two batches fails, the checkpoint must not be saved
+	if _, err := p.Process(1, makeLogGroupList(), tracker); err == nil {
+		t.Fatal("Process() expect error")
+	}
+	if tracker.saved != 0 {
+		t.Fatalf("checkpoint saved %d times after a failed callback", tracker.saved)
+	}
+	if _, err := p.Process(1, makeLogGroupList(), tracker); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if tracker.saved != 1 {
+		t.Errorf("checkpoint saved %d times, want 1", tracker.saved)
+	}
+	last := s.sent[len(s.sent)-1]
+	if last.topic != "APP" || last.source != "dr-host-2" {
+		t.Errorf("sent topic = %v, source = %v", last.topic, last.source)
+	}
+
+	status := p.status()
+	if len(status) != 1 || status[0].ShardID != 1 || status[0].LogCount != 3 || status[0].FailedTimes != 1 {
+		t.Fatalf("status() = %+v", status)
+	}
+	if !status[0].LastLogTime.Equal(time.Unix(102, 0)) || status[0].Lag <= 0 {
+		t.Errorf("status() lag = %v, last log time = %v", status[0].Lag, status[0].LastLogTime)
+	}
+}
+
+func TestProcessSendError(t *testing.T) {
+	s := &fakeSender{sendErr: errors.New("producer closed")}
+	p := newMirrorProcessor(&Config{TargetProject: "p", TargetLogstore: "l"}, nil, s)
+	tracker := &fakeTracker{}
+	if _, err := p.Process(0, makeLogGroupList(), tracker); err == nil || tracker.saved != 0 {
+		t.Errorf("Process() error = %v, saved = %d, want error and no checkpoint", err, tracker.saved)
+	}
+}
+
+func TestProcessWithQuery(t *testing.T) {
+	s := &fakeSender{}
+	p := newMirrorProcessor(&Config{TargetProject: "p", TargetLogstore: "l"}, spl.MustCompile("* | where level != 'DEBUG'"), s)
+	tracker := &fakeTracker{}
+	if _, err := p.Process(0, makeLogGroupList(), tracker); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if len(s.sent) != 1 || len(s.sent[0].logs) != 2 {
+		t.Errorf("sent = %+v, want one group of 2 logs", s.sent)
+	}
+	// the group filtered out by the query is skipped, the checkpoint is still saved
+	if tracker.saved != 1 {
+		t.Errorf("checkpoint saved %d times, want 1", tracker.saved)
+	}
+}
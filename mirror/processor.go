@@ -0,0 +1,167 @@
+package mirror
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	consumerLibrary "github.com/aliyun/aliyun-log-go-sdk/consumer"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+	"github.com/aliyun/aliyun-log-go-sdk/spl"
+)
+
+// sender is the part of producer.Producer used by the mirror.
+type sender interface {
+	SendLogListWithCallBack(project, logstore, topic, source string, logList []*sls.Log, callback producer.CallBack) error
+}
+
+// ShardStatus is the replication status of one source shard.
+type ShardStatus struct {
+	ShardID int
+	// LastLogTime is the max log time of the last replicated fetch
+	LastLogTime time.Time
+	// LastReplicateTime is when the last fetch was fully written to the target
+	LastReplicateTime time.Time
+	// Lag is LastReplicateTime - LastLogTime, the delay of the newest replicated data
+	Lag time.Duration
+	// LogCount is the number of logs written to the target
+	LogCount int64
+	// FailedTimes is the number of fetches that failed and were retried
+	FailedTimes int64
+}
+
+// waitCallBack collects the results of all batches sent for one fetch.
+type waitCallBack struct {
+	wg    *sync.WaitGroup
+	mutex *sync.Mutex
+	err   *error
+}
+
+func (c *waitCallBack) Success(result *producer.Result) {
+	c.wg.Done()
+}
+
+func (c *waitCallBack) Fail(result *producer.Result) {
+	c.mutex.Lock()
+	if *c.err == nil {
+		if result != nil {
+			*c.err = fmt.Errorf("send to target failed, code: %s, message: %s, requestId: %s",
+				result.GetErrorCode(), result.GetErrorMessage(), result.GetRequestId())
+		} else {
+			*c.err = fmt.Errorf("send to target failed")
+		}
+	}
+	c.mutex.Unlock()
+	c.wg.Done()
+}
+
+// mirrorProcessor writes every fetch to the target and saves the checkpoint
+// only after all of its batches are written.
+type mirrorProcessor struct {
+	config   *Config
+	pipeline *spl.Pipeline
+	sender   sender
+
+	mutex  sync.Mutex
+	shards map[int]*ShardStatus
+}
+
+func newMirrorProcessor(config *Config, pipeline *spl.Pipeline, sender sender) *mirrorProcessor {
+	return &mirrorProcessor{
+		config:   config,
+		pipeline: pipeline,
+		sender:   sender,
+		shards:   map[int]*ShardStatus{},
+	}
+}
+
+func (p *mirrorProcessor) Process(shardID int, logGroupList *sls.LogGroupList, checkpointTracker consumerLibrary.CheckPointTracker) (string, error) {
+	if err := p.replicate(logGroupList); err != nil {
+		p.updateStatus(shardID, func(s *ShardStatus) { s.FailedTimes++ })
+		// the consumer calls Process again with the same data, no checkpoint is saved
+		return "", err
+	}
+	var logCount int64
+	var maxTime uint32
+	for _, lg := range logGroupList.LogGroups {
+		logCount += int64(len(lg.Logs))
+		for _, log := range lg.Logs {
+			if log.GetTime() > maxTime {
+				maxTime = log.GetTime()
+			}
+		}
+	}
+	now := time.Now()
+	p.updateStatus(shardID, func(s *ShardStatus) {
+		s.LogCount += logCount
+		s.LastReplicateTime = now
+		if maxTime > 0 {
+			s.LastLogTime = time.Unix(int64(maxTime), 0)
+			s.Lag = now.Sub(s.LastLogTime)
+		}
+	})
+	return "", checkpointTracker.SaveCheckPoint(false)
+}
+
+// replicate sends all logs of the list and waits for the callbacks.
+func (p *mirrorProcessor) replicate(logGroupList *sls.LogGroupList) error {
+	if p.pipeline != nil {
+		var err error
+		if logGroupList, err = p.pipeline.Process(logGroupList); err != nil {
+			return err
+		}
+	}
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		sendErr error
+	)
+	callback := &waitCallBack{wg: &wg, mutex: &mutex, err: &sendErr}
+	for _, lg := range logGroupList.LogGroups {
+		if len(lg.Logs) == 0 {
+			continue
+		}
+		topic, source := lg.GetTopic(), lg.GetSource()
+		if p.config.RewriteTopic != nil {
+			topic = p.config.RewriteTopic(topic)
+		}
+		if p.config.RewriteSource != nil {
+			source = p.config.RewriteSource(source)
+		}
+		wg.Add(1)
+		if err := p.sender.SendLogListWithCallBack(p.config.TargetProject, p.config.TargetLogstore, topic, source, lg.Logs, callback); err != nil {
+			wg.Done()
+			// wait for the sent batches, so that their callbacks do not race with a retry
+			wg.Wait()
+			return err
+		}
+	}
+	wg.Wait()
+	return sendErr
+}
+
+func (p *mirrorProcessor) Shutdown(checkpointTracker consumerLibrary.CheckPointTracker) error {
+	return nil
+}
+
+func (p *mirrorProcessor) updateStatus(shardID int, update func(s *ShardStatus)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, ok := p.shards[shardID]
+	if !ok {
+		s = &ShardStatus{ShardID: shardID}
+		p.shards[shardID] = s
+	}
+	update(s)
+}
+
+func (p *mirrorProcessor) status() []ShardStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	result := make([]ShardStatus, 0, len(p.shards))
+	for _, s := range p.shards {
+		result = append(result, *s)
+	}
+	return result
+}
@@ -0,0 +1,193 @@
+package otlp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+// The keys of the log contents and tags that are not attributes.
+const (
+	KeyBody           = "body"
+	KeySeverityText   = "severity_text"
+	KeySeverityNumber = "severity_number"
+	KeyTraceID        = "trace_id"
+	KeySpanID         = "span_id"
+	KeyFlags          = "flags"
+	KeyEventName      = "event_name"
+	KeyScopeName      = "otel.scope.name"
+	KeyScopeVersion   = "otel.scope.version"
+)
+
+// Options configures the conversion of OTLP logs.
+type Options struct {
+	// Topic and Source of the log groups, both default to empty
+	Topic  string
+	Source string
+}
+
+// Convert converts all logs of a request, see ConvertResourceLogs.
+func Convert(req *ExportLogsServiceRequest, options *Options) []*sls.LogGroup {
+	var groups []*sls.LogGroup
+	for _, rl := range req.ResourceLogs {
+		groups = append(groups, ConvertResourceLogs(rl, options)...)
+	}
+	return groups
+}
+
+// ConvertResourceLogs converts the logs of one resource into one log group per scope.
+//
+// The resource attributes, the scope name and version, and the scope attributes
+// become the log tags. The body of a record is the "body" content and every
+// attribute is a content of the same key. Strings are kept as is, other values
+// are formatted, arrays and maps as JSON. The timestamp of a record, or the
+// observed timestamp if it is not set, becomes Time and TimeNs, records without
+// either use the current time. The severity, trace id, span id, flags and event
+// name are written to the Key* contents if they are set.
+func ConvertResourceLogs(rl *ResourceLogs, options *Options) []*sls.LogGroup {
+	if options == nil {
+		options = &Options{}
+	}
+	var resourceTags []*sls.LogTag
+	if rl.Resource != nil {
+		resourceTags = appendTags(resourceTags, rl.Resource.Attributes)
+	}
+	var groups []*sls.LogGroup
+	for _, sl := range rl.ScopeLogs {
+		if len(sl.LogRecords) == 0 {
+			continue
+		}
+		tags := append([]*sls.LogTag{}, resourceTags...)
+		if sl.Scope != nil {
+			if sl.Scope.Name != "" {
+				tags = append(tags, &sls.LogTag{Key: proto.String(KeyScopeName), Value: proto.String(sl.Scope.Name)})
+			}
+			if sl.Scope.Version != "" {
+				tags = append(tags, &sls.LogTag{Key: proto.String(KeyScopeVersion), Value: proto.String(sl.Scope.Version)})
+			}
+			tags = appendTags(tags, sl.Scope.Attributes)
+		}
+		lg := &sls.LogGroup{
+			Topic:   proto.String(options.Topic),
+			Source:  proto.String(options.Source),
+			LogTags: tags,
+		}
+		for _, r := range sl.LogRecords {
+			lg.Logs = append(lg.Logs, convertLogRecord(r))
+		}
+		groups = append(groups, lg)
+	}
+	return groups
+}
+
+func appendTags(tags []*sls.LogTag, attributes []*KeyValue) []*sls.LogTag {
+	for _, kv := range attributes {
+		tags = append(tags, &sls.LogTag{Key: proto.String(kv.Key), Value: proto.String(formatValue(kv.Value))})
+	}
+	return tags
+}
+
+func convertLogRecord(r *LogRecord) *sls.Log {
+	ns := r.TimeUnixNano
+	if ns == 0 {
+		ns = r.ObservedTimeUnixNano
+	}
+	if ns == 0 {
+		ns = uint64(time.Now().UnixNano())
+	}
+	log := &sls.Log{
+		Time:   proto.Uint32(uint32(ns / uint64(time.Second))),
+		TimeNs: proto.Uint32(uint32(ns % uint64(time.Second))),
+	}
+	add := func(key, value string) {
+		log.Contents = append(log.Contents, &sls.LogContent{Key: proto.String(key), Value: proto.String(value)})
+	}
+	if r.Body != nil {
+		add(KeyBody, formatValue(r.Body))
+	}
+	if r.SeverityText != "" {
+		add(KeySeverityText, r.SeverityText)
+	}
+	if r.SeverityNumber != 0 {
+		add(KeySeverityNumber, strconv.Itoa(int(r.SeverityNumber)))
+	}
+	if len(r.TraceID) > 0 {
+		add(KeyTraceID, hex.EncodeToString(r.TraceID))
+	}
+	if len(r.SpanID) > 0 {
+		add(KeySpanID, hex.EncodeToString(r.SpanID))
+	}
+	if r.Flags != 0 {
+		add(KeyFlags, strconv.FormatUint(uint64(r.Flags), 10))
+	}
+	if r.EventName != "" {
+		add(KeyEventName, r.EventName)
+	}
+	for _, kv := range r.Attributes {
+		add(kv.Key, formatValue(kv.Value))
+	}
+	return log
+}
+
+// formatValue formats a value as a string, an empty value is an empty string
+func formatValue(v *AnyValue) string {
+	if v == nil {
+		return ""
+	}
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return strconv.FormatInt(*v.IntValue, 10)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	case v.BytesValue != nil:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case v.ArrayValue != nil, v.KvlistValue != nil:
+		data, err := json.Marshal(jsonValue(v))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+	return ""
+}
+
+// jsonValue converts a value into the value of encoding/json
+func jsonValue(v *AnyValue) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.BytesValue != nil:
+		return v.BytesValue
+	case v.ArrayValue != nil:
+		values := make([]interface{}, 0, len(v.ArrayValue.Values))
+		for _, item := range v.ArrayValue.Values {
+			values = append(values, jsonValue(item))
+		}
+		return values
+	case v.KvlistValue != nil:
+		values := make(map[string]interface{}, len(v.KvlistValue.Values))
+		for _, kv := range v.KvlistValue.Values {
+			values[kv.Key] = jsonValue(kv.Value)
+		}
+		return values
+	}
+	return nil
+}
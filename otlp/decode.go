@@ -0,0 +1,216 @@
+package otlp
+
+import (
+	"encoding/json"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DecodeProto decodes an ExportLogsServiceRequest in the protobuf encoding.
+// Unknown fields are skipped.
+func DecodeProto(data []byte) (*ExportLogsServiceRequest, error) {
+	req := &ExportLogsServiceRequest{}
+	err := decodeMessage(data, func(f field) error {
+		if f.num == 1 && f.typ == protowire.BytesType {
+			rl := &ResourceLogs{}
+			req.ResourceLogs = append(req.ResourceLogs, rl)
+			return decodeResourceLogs(f.bytes, rl)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// DecodeJSON decodes an ExportLogsServiceRequest in the OTLP/JSON encoding.
+func DecodeJSON(data []byte) (*ExportLogsServiceRequest, error) {
+	req := &ExportLogsServiceRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// field is one decoded field of a message, bytes is set for the bytes wire
+// type and value for the varint and fixed wire types
+type field struct {
+	num   protowire.Number
+	typ   protowire.Type
+	bytes []byte
+	value uint64
+}
+
+func decodeMessage(data []byte, fn func(f field) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		f := field{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.value, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(data)
+			f.value = uint64(v)
+		case protowire.Fixed64Type:
+			f.value, n = protowire.ConsumeFixed64(data)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeResourceLogs(data []byte, rl *ResourceLogs) error {
+	return decodeMessage(data, func(f field) error {
+		switch {
+		case f.num == 1 && f.typ == protowire.BytesType:
+			rl.Resource = &Resource{}
+			return decodeMessage(f.bytes, func(f field) error {
+				if f.num == 1 && f.typ == protowire.BytesType {
+					return appendKeyValue(&rl.Resource.Attributes, f.bytes)
+				}
+				return nil
+			})
+		case f.num == 2 && f.typ == protowire.BytesType:
+			sl := &ScopeLogs{}
+			rl.ScopeLogs = append(rl.ScopeLogs, sl)
+			return decodeScopeLogs(f.bytes, sl)
+		case f.num == 3 && f.typ == protowire.BytesType:
+			rl.SchemaURL = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func decodeScopeLogs(data []byte, sl *ScopeLogs) error {
+	return decodeMessage(data, func(f field) error {
+		switch {
+		case f.num == 1 && f.typ == protowire.BytesType:
+			sl.Scope = &InstrumentationScope{}
+			return decodeMessage(f.bytes, func(f field) error {
+				if f.typ != protowire.BytesType {
+					return nil
+				}
+				switch f.num {
+				case 1:
+					sl.Scope.Name = string(f.bytes)
+				case 2:
+					sl.Scope.Version = string(f.bytes)
+				case 3:
+					return appendKeyValue(&sl.Scope.Attributes, f.bytes)
+				}
+				return nil
+			})
+		case f.num == 2 && f.typ == protowire.BytesType:
+			r := &LogRecord{}
+			sl.LogRecords = append(sl.LogRecords, r)
+			return decodeLogRecord(f.bytes, r)
+		case f.num == 3 && f.typ == protowire.BytesType:
+			sl.SchemaURL = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func decodeLogRecord(data []byte, r *LogRecord) error {
+	return decodeMessage(data, func(f field) error {
+		switch f.num {
+		case 1:
+			r.TimeUnixNano = f.value
+		case 11:
+			r.ObservedTimeUnixNano = f.value
+		case 2:
+			r.SeverityNumber = int32(f.value)
+		case 3:
+			r.SeverityText = string(f.bytes)
+		case 5:
+			r.Body = &AnyValue{}
+			return decodeAnyValue(f.bytes, r.Body)
+		case 6:
+			return appendKeyValue(&r.Attributes, f.bytes)
+		case 8:
+			r.Flags = uint32(f.value)
+		case 9:
+			r.TraceID = append([]byte{}, f.bytes...)
+		case 10:
+			r.SpanID = append([]byte{}, f.bytes...)
+		case 12:
+			r.EventName = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func appendKeyValue(list *[]*KeyValue, data []byte) error {
+	kv := &KeyValue{}
+	*list = append(*list, kv)
+	return decodeMessage(data, func(f field) error {
+		if f.typ != protowire.BytesType {
+			return nil
+		}
+		switch f.num {
+		case 1:
+			kv.Key = string(f.bytes)
+		case 2:
+			kv.Value = &AnyValue{}
+			return decodeAnyValue(f.bytes, kv.Value)
+		}
+		return nil
+	})
+}
+
+func decodeAnyValue(data []byte, v *AnyValue) error {
+	return decodeMessage(data, func(f field) error {
+		switch f.num {
+		case 1:
+			s := string(f.bytes)
+			v.StringValue = &s
+		case 2:
+			b := f.value != 0
+			v.BoolValue = &b
+		case 3:
+			i := int64(f.value)
+			v.IntValue = &i
+		case 4:
+			d := math.Float64frombits(f.value)
+			v.DoubleValue = &d
+		case 5:
+			v.ArrayValue = &ArrayValue{}
+			return decodeMessage(f.bytes, func(f field) error {
+				if f.num == 1 && f.typ == protowire.BytesType {
+					item := &AnyValue{}
+					v.ArrayValue.Values = append(v.ArrayValue.Values, item)
+					return decodeAnyValue(f.bytes, item)
+				}
+				return nil
+			})
+		case 6:
+			v.KvlistValue = &KeyValueList{}
+			return decodeMessage(f.bytes, func(f field) error {
+				if f.num == 1 && f.typ == protowire.BytesType {
+					return appendKeyValue(&v.KvlistValue.Values, f.bytes)
+				}
+				return nil
+			})
+		case 7:
+			v.BytesValue = append([]byte{}, f.bytes...)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,136 @@
+package otlp
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	contentTypeProto = "application/x-protobuf"
+	contentTypeJSON  = "application/json"
+
+	maxRequestBodySize = 64 * 1024 * 1024
+)
+
+// the codes of google.rpc.Status used in error responses
+const (
+	statusInvalidArgument = 3
+	statusUnavailable     = 14
+)
+
+// Sender writes logs into a logstore, it is implemented by *producer.Producer.
+type Sender interface {
+	SendLogListWithTags(ctx context.Context, project, logstore, shardHash, topic, source string, logTags []*sls.LogTag, logList []*sls.Log, callback producer.CallBack) error
+}
+
+// Handler serves the OTLP/HTTP logs endpoint, it is usually registered at the
+// path /v1/logs. Requests in the protobuf and the JSON encoding are accepted,
+// optionally compressed with gzip.
+//
+// The logs are forwarded to a Sender such as a producer with the tags of the
+// converted groups.
+//
+// The handler responds after the logs are handed to the sender, it waits for
+// room in the sender until the request is done. A sender error is answered with
+// 503 so that the client retries the request, logs of the request that were
+// already handed over may then be written twice.
+type Handler struct {
+	sender   Sender
+	project  string
+	logstore string
+	options  Options
+}
+
+// NewHandler creates a Handler that writes into the logstore.
+func NewHandler(sender Sender, project, logstore string, options *Options) *Handler {
+	h := &Handler{sender: sender, project: project, logstore: logstore}
+	if options != nil {
+		h.options = *options
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != contentTypeProto && contentType != contentTypeJSON {
+		http.Error(w, fmt.Sprintf("unsupported content type %q", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			writeStatus(w, contentType, http.StatusBadRequest, statusInvalidArgument, err.Error())
+			return
+		}
+		defer gz.Close()
+		body = io.LimitReader(gz, maxRequestBodySize)
+	default:
+		http.Error(w, fmt.Sprintf("unsupported content encoding %q", r.Header.Get("Content-Encoding")), http.StatusUnsupportedMediaType)
+		return
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		writeStatus(w, contentType, http.StatusBadRequest, statusInvalidArgument, err.Error())
+		return
+	}
+
+	var req *ExportLogsServiceRequest
+	if contentType == contentTypeProto {
+		req, err = DecodeProto(data)
+	} else {
+		req, err = DecodeJSON(data)
+	}
+	if err != nil {
+		writeStatus(w, contentType, http.StatusBadRequest, statusInvalidArgument, err.Error())
+		return
+	}
+
+	for _, lg := range Convert(req, &h.options) {
+		if err := h.sender.SendLogListWithTags(r.Context(), h.project, h.logstore, "", lg.GetTopic(), lg.GetSource(), lg.LogTags, lg.Logs, nil); err != nil {
+			writeStatus(w, contentType, http.StatusServiceUnavailable, statusUnavailable, err.Error())
+			return
+		}
+	}
+
+	// an empty ExportLogsServiceResponse means all logs are accepted
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if contentType == contentTypeJSON {
+		w.Write([]byte("{}"))
+	}
+}
+
+// writeStatus writes an error response, the body is a google.rpc.Status in the encoding of the request
+func writeStatus(w http.ResponseWriter, contentType string, httpCode int, code int32, message string) {
+	var body []byte
+	if contentType == contentTypeJSON {
+		body, _ = json.Marshal(map[string]interface{}{"code": code, "message": message})
+	} else {
+		body = protowire.AppendTag(body, 1, protowire.VarintType)
+		body = protowire.AppendVarint(body, uint64(code))
+		body = protowire.AppendTag(body, 2, protowire.BytesType)
+		body = protowire.AppendString(body, message)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(httpCode)
+	w.Write(body)
+}
@@ -0,0 +1,186 @@
+// Package otlp converts OpenTelemetry logs (OTLP) into log groups, and serves
+// the OTLP/HTTP logs endpoint so that applications instrumented with an
+// OpenTelemetry SDK can write into a logstore without running a collector.
+//
+// Both the protobuf and the JSON encodings of ExportLogsServiceRequest are
+// supported. The types of this package mirror the OTLP messages that carry
+// logs, fields that are not needed for the conversion are omitted.
+package otlp
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ExportLogsServiceRequest is the request of the OTLP logs service.
+type ExportLogsServiceRequest struct {
+	ResourceLogs []*ResourceLogs `json:"resourceLogs"`
+}
+
+// ResourceLogs are the logs of one resource, eg. one process.
+type ResourceLogs struct {
+	Resource  *Resource    `json:"resource"`
+	ScopeLogs []*ScopeLogs `json:"scopeLogs"`
+	SchemaURL string       `json:"schemaUrl"`
+}
+
+// Resource describes the entity that produced the logs.
+type Resource struct {
+	Attributes []*KeyValue `json:"attributes"`
+}
+
+// ScopeLogs are the logs produced by one instrumentation scope, eg. one library.
+type ScopeLogs struct {
+	Scope      *InstrumentationScope `json:"scope"`
+	LogRecords []*LogRecord          `json:"logRecords"`
+	SchemaURL  string                `json:"schemaUrl"`
+}
+
+// InstrumentationScope describes the library that produced the logs.
+type InstrumentationScope struct {
+	Name       string      `json:"name"`
+	Version    string      `json:"version"`
+	Attributes []*KeyValue `json:"attributes"`
+}
+
+// LogRecord is one log. TraceID and SpanID are hex strings in the JSON
+// encoding, and raw bytes in the protobuf encoding.
+type LogRecord struct {
+	TimeUnixNano         uint64      `json:"timeUnixNano"`
+	ObservedTimeUnixNano uint64      `json:"observedTimeUnixNano"`
+	SeverityNumber       int32       `json:"severityNumber"`
+	SeverityText         string      `json:"severityText"`
+	Body                 *AnyValue   `json:"body"`
+	Attributes           []*KeyValue `json:"attributes"`
+	Flags                uint32      `json:"flags"`
+	TraceID              []byte      `json:"traceId"`
+	SpanID               []byte      `json:"spanId"`
+	EventName            string      `json:"eventName"`
+}
+
+// KeyValue is an attribute.
+type KeyValue struct {
+	Key   string    `json:"key"`
+	Value *AnyValue `json:"value"`
+}
+
+// AnyValue holds one of its fields, a nil AnyValue or one without any field set is empty.
+type AnyValue struct {
+	StringValue *string       `json:"stringValue"`
+	BoolValue   *bool         `json:"boolValue"`
+	IntValue    *int64        `json:"intValue"`
+	DoubleValue *float64      `json:"doubleValue"`
+	ArrayValue  *ArrayValue   `json:"arrayValue"`
+	KvlistValue *KeyValueList `json:"kvlistValue"`
+	BytesValue  []byte        `json:"bytesValue"`
+}
+
+// ArrayValue is a list of values.
+type ArrayValue struct {
+	Values []*AnyValue `json:"values"`
+}
+
+// KeyValueList is a list of attributes.
+type KeyValueList struct {
+	Values []*KeyValue `json:"values"`
+}
+
+// severityNames are the names of the severity numbers used by OTLP/JSON encoders
+// that write enums as strings, in the order of their values
+var severityNames = []string{
+	"UNSPECIFIED",
+	"TRACE", "TRACE2", "TRACE3", "TRACE4",
+	"DEBUG", "DEBUG2", "DEBUG3", "DEBUG4",
+	"INFO", "INFO2", "INFO3", "INFO4",
+	"WARN", "WARN2", "WARN3", "WARN4",
+	"ERROR", "ERROR2", "ERROR3", "ERROR4",
+	"FATAL", "FATAL2", "FATAL3", "FATAL4",
+}
+
+// UnmarshalJSON decodes a log record of OTLP/JSON, where 64 bit integers may be
+// strings, ids are hex strings and the severity number may be the enum name.
+func (r *LogRecord) UnmarshalJSON(data []byte) error {
+	type alias LogRecord
+	aux := struct {
+		*alias
+		TimeUnixNano         json.RawMessage `json:"timeUnixNano"`
+		ObservedTimeUnixNano json.RawMessage `json:"observedTimeUnixNano"`
+		SeverityNumber       json.RawMessage `json:"severityNumber"`
+		TraceID              string          `json:"traceId"`
+		SpanID               string          `json:"spanId"`
+	}{alias: (*alias)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	var err error
+	if r.TimeUnixNano, err = parseJSONUint(aux.TimeUnixNano); err != nil {
+		return fmt.Errorf("timeUnixNano: %w", err)
+	}
+	if r.ObservedTimeUnixNano, err = parseJSONUint(aux.ObservedTimeUnixNano); err != nil {
+		return fmt.Errorf("observedTimeUnixNano: %w", err)
+	}
+	if r.SeverityNumber, err = parseSeverityNumber(aux.SeverityNumber); err != nil {
+		return fmt.Errorf("severityNumber: %w", err)
+	}
+	if r.TraceID, err = hex.DecodeString(aux.TraceID); err != nil {
+		return fmt.Errorf("traceId: %w", err)
+	}
+	if r.SpanID, err = hex.DecodeString(aux.SpanID); err != nil {
+		return fmt.Errorf("spanId: %w", err)
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes a value of OTLP/JSON, where intValue may be a string.
+func (v *AnyValue) UnmarshalJSON(data []byte) error {
+	type alias AnyValue
+	aux := struct {
+		*alias
+		IntValue json.RawMessage `json:"intValue"`
+	}{alias: (*alias)(v)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if raw := unquote(aux.IntValue); raw != "" && raw != "null" {
+		i, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("intValue: %w", err)
+		}
+		v.IntValue = &i
+	}
+	return nil
+}
+
+func parseJSONUint(data json.RawMessage) (uint64, error) {
+	raw := unquote(data)
+	if raw == "" || raw == "null" {
+		return 0, nil
+	}
+	return strconv.ParseUint(raw, 10, 64)
+}
+
+func parseSeverityNumber(data json.RawMessage) (int32, error) {
+	raw := unquote(data)
+	if raw == "" || raw == "null" {
+		return 0, nil
+	}
+	name := strings.TrimPrefix(raw, "SEVERITY_NUMBER_")
+	for i, n := range severityNames {
+		if n == name {
+			return int32(i), nil
+		}
+	}
+	i, err := strconv.ParseInt(raw, 10, 32)
+	return int32(i), err
+}
+
+func unquote(data json.RawMessage) string {
+	s := strings.TrimSpace(string(data))
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,239 @@
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+	"github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// message encodes fields, each field is appended by a function
+func message(fields ...func([]byte) []byte) []byte {
+	var b []byte
+	for _, f := range fields {
+		b = f(b)
+	}
+	return b
+}
+
+func bytesField(num protowire.Number, value []byte) func([]byte) []byte {
+	return func(b []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, value)
+	}
+}
+
+func varintField(num protowire.Number, value uint64) func([]byte) []byte {
+	return func(b []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, value)
+	}
+}
+
+func fixed64Field(num protowire.Number, value uint64) func([]byte) []byte {
+	return func(b []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+		return protowire.AppendFixed64(b, value)
+	}
+}
+
+func stringAttribute(key, value string) func([]byte) []byte {
+	return bytesField(1, message(bytesField(1, []byte(key)), bytesField(2, message(bytesField(1, []byte(value))))))
+}
+
+// protoRequest is the protobuf encoding of the same request as jsonRequest
+func protoRequest() []byte {
+	record := message(
+		fixed64Field(1, 1700000000123456789),
+		varintField(2, 17),
+		bytesField(3, []byte("ERROR")),
+		bytesField(5, message(bytesField(1, []byte("request failed")))),
+		bytesField(6, message(bytesField(1, []byte("http.status")), bytesField(2, message(varintField(3, 500))))),
+		bytesField(6, message(bytesField(1, []byte("retry")), bytesField(2, message(varintField(2, 1))))),
+		bytesField(9, []byte{0x5b, 0x8e, 0xff, 0xf7, 0x98, 0x03, 0x81, 0x03, 0xd2, 0x69, 0xb6, 0x33, 0x81, 0x3f, 0xc6, 0x0c}),
+		bytesField(10, []byte{0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x74}),
+		varintField(99, 1),
+	)
+	scopeLogs := message(
+		bytesField(1, message(bytesField(1, []byte("my.library")), bytesField(2, []byte("1.0.0")))),
+		bytesField(2, record),
+	)
+	resourceLogs := message(
+		bytesField(1, message(stringAttribute("service.name", "checkout"))),
+		bytesField(2, scopeLogs),
+	)
+	return message(bytesField(1, resourceLogs))
+}
+
+const jsonRequest = `{"resourceLogs":[{
+	"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+	"scopeLogs":[{
+		"scope":{"name":"my.library","version":"1.0.0"},
+		"logRecords":[{
+			"timeUnixNano":"1700000000123456789",
+			"severityNumber":"SEVERITY_NUMBER_ERROR",
+			"severityText":"ERROR",
+			"body":{"stringValue":"request failed"},
+			"attributes":[
+				{"key":"http.status","value":{"intValue":"500"}},
+				{"key":"retry","value":{"boolValue":true}}
+			],
+			"traceId":"5b8efff798038103d269b633813fc60c",
+			"spanId":"eee19b7ec3c1b174"
+		}]
+	}]
+}]}`
+
+const wantLogGroup = `Logs:<Time:1700000000 ` +
+	`Contents:<Key:"body" Value:"request failed" > ` +
+	`Contents:<Key:"severity_text" Value:"ERROR" > ` +
+	`Contents:<Key:"severity_number" Value:"17" > ` +
+	`Contents:<Key:"trace_id" Value:"5b8efff798038103d269b633813fc60c" > ` +
+	`Contents:<Key:"span_id" Value:"eee19b7ec3c1b174" > ` +
+	`Contents:<Key:"http.status" Value:"500" > ` +
+	`Contents:<Key:"retry" Value:"true" > ` +
+	`TimeNs:123456789 > ` +
+	`Topic:"otel" Source:"" ` +
+	`LogTags:<Key:"service.name" Value:"checkout" > ` +
+	`LogTags:<Key:"otel.scope.name" Value:"my.library" > ` +
+	`LogTags:<Key:"otel.scope.version" Value:"1.0.0" > `
+
+func TestConvert(t *testing.T) {
+	protoReq, err := DecodeProto(protoRequest())
+	if err != nil {
+		t.Fatalf("DecodeProto() error = %v", err)
+	}
+	jsonReq, err := DecodeJSON([]byte(jsonRequest))
+	if err != nil {
+		t.Fatalf("DecodeJSON() error = %v", err)
+	}
+	for name, req := range map[string]*ExportLogsServiceRequest{"proto": protoReq, "json": jsonReq} {
+		groups := Convert(req, &Options{Topic: "otel"})
+		if len(groups) != 1 {
+			t.Fatalf("%q. Convert() = %d groups, want 1", name, len(groups))
+		}
+		if got := groups[0].String(); got != wantLogGroup {
+			t.Errorf("%q. Convert() = %v, want %v", name, got, wantLogGroup)
+		}
+	}
+
+	if _, err := DecodeProto([]byte{0x0a, 0xff}); err == nil {
+		t.Error("DecodeProto() expect error on truncated data")
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	s, i, d := "a", int64(-1), 1.5
+	tests := []struct {
+		name  string
+		value *AnyValue
+		want  string
+	}{
+		{"nil", nil, ""},
+		{"double", &AnyValue{DoubleValue: &d}, "1.5"},
+		{"bytes", &AnyValue{BytesValue: []byte("hi")}, "aGk="},
+		{"array", &AnyValue{ArrayValue: &ArrayValue{Values: []*AnyValue{{StringValue: &s}, {IntValue: &i}}}}, `["a",-1]`},
+		{"kvlist", &AnyValue{KvlistValue: &KeyValueList{Values: []*KeyValue{{Key: "k", Value: &AnyValue{DoubleValue: &d}}}}}, `{"k":1.5}`},
+	}
+	for _, tt := range tests {
+		if got := formatValue(tt.value); got != tt.want {
+			t.Errorf("%q. formatValue() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+type fakeSender struct {
+	logs []*sls.Log
+	tags []*sls.LogTag
+	err  error
+}
+
+func (s *fakeSender) SendLogListWithTags(ctx context.Context, project, logstore, shardHash, topic, source string, logTags []*sls.LogTag, logList []*sls.Log, callback producer.CallBack) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.logs = append(s.logs, logList...)
+	s.tags = logTags
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(protoRequest())
+	gz.Close()
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		encoding    string
+		body        []byte
+		sendErr     error
+		wantCode    int
+		wantLogs    int
+	}{
+		{"proto", http.MethodPost, "application/x-protobuf", "", protoRequest(), nil, http.StatusOK, 1},
+		{"json", http.MethodPost, "application/json; charset=utf-8", "", []byte(jsonRequest), nil, http.StatusOK, 1},
+		{"gzip", http.MethodPost, "application/x-protobuf", "gzip", gzipped.Bytes(), nil, http.StatusOK, 1},
+		{"bad body", http.MethodPost, "application/json", "", []byte("{"), nil, http.StatusBadRequest, 0},
+		{"bad type", http.MethodPost, "text/plain", "", []byte(jsonRequest), nil, http.StatusUnsupportedMediaType, 0},
+		{"bad method", http.MethodGet, "application/json", "", nil, nil, http.StatusMethodNotAllowed, 0},
+		{"send error", http.MethodPost, "application/json", "", []byte(jsonRequest), errors.New("timeout"), http.StatusServiceUnavailable, 0},
+	}
+	for _, tt := range tests {
+		sender := &fakeSender{err: tt.sendErr}
+		req := httptest.NewRequest(tt.method, "/v1/logs", bytes.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		if tt.encoding != "" {
+			req.Header.Set("Content-Encoding", tt.encoding)
+		}
+		w := httptest.NewRecorder()
+		NewHandler(sender, "p", "l", nil).ServeHTTP(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("%q. ServeHTTP() code = %v, want %v, body %s", tt.name, w.Code, tt.wantCode, w.Body.String())
+		}
+		if len(sender.logs) != tt.wantLogs {
+			t.Errorf("%q. ServeHTTP() sent %d logs, want %v", tt.name, len(sender.logs), tt.wantLogs)
+		}
+		if tt.wantLogs > 0 {
+			tags := sender.tags
+			if last := tags[len(tags)-1]; last.GetKey() != "otel.scope.version" || last.GetValue() != "1.0.0" {
+				t.Errorf("%q. ServeHTTP() last tag = %v", tt.name, last)
+			}
+		}
+	}
+}
+
+func TestHandlerContext(t *testing.T) {
+	config := producer.GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.MaxBlockSec = 60
+	config.TotalSizeLnBytes = 1
+	p := producer.InitProducer(config)
+	log := &sls.Log{Time: proto.Uint32(1), Contents: []*sls.LogContent{{Key: proto.String("k"), Value: proto.String("v")}}}
+	if err := p.SendLog("p", "l", "", "", log); err != nil {
+		t.Fatalf("SendLog() error = %v", err)
+	}
+
+	// the full producer is waited for until the request is done, not MaxBlockSec
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPost, "/v1/logs", bytes.NewReader(protoRequest())).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	NewHandler(p, "p", "l", nil).ServeHTTP(w, req)
+	if elapsed := time.Since(start); w.Code != http.StatusServiceUnavailable || elapsed > 5*time.Second {
+		t.Errorf("ServeHTTP() code = %v after %v, want %v", w.Code, elapsed, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,443 @@
+package producer
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/cenkalti/backoff"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gogo/protobuf/proto"
+)
+
+// DeadLetter is a batch that failed terminally, after the retries or with a
+// status in NoRetryStatusCodeList.
+type DeadLetter struct {
+	Project  string
+	Logstore string
+	// ShardHash is the hash key of the batch, empty if it has none
+	ShardHash string
+	LogGroup  *sls.LogGroup
+	// Attempts are the reserved attempts of the batch, see MaxReservedAttempts
+	Attempts []*Attempt
+}
+
+// DeadLetterSink keeps the batches that fail terminally, see
+// ProducerConfig.DeadLetterSink. Write is called before the Fail callbacks.
+type DeadLetterSink interface {
+	Write(letter *DeadLetter) error
+}
+
+// DeadLetterFunc is a DeadLetterSink of a function.
+type DeadLetterFunc func(letter *DeadLetter) error
+
+func (f DeadLetterFunc) Write(letter *DeadLetter) error {
+	return f(letter)
+}
+
+// DeadLetterFormat is the format of a dead letter file.
+type DeadLetterFormat int
+
+const (
+	// DeadLetterJSON writes a JSON object per line
+	DeadLetterJSON DeadLetterFormat = iota
+	// DeadLetterProtobuf writes the LogGroup as protobuf with a JSON header of the
+	// other fields, in the length and crc32 prefixed records of the spool
+	DeadLetterProtobuf
+)
+
+// FileDeadLetterSink appends the dead letters to a local file.
+type FileDeadLetterSink struct {
+	format DeadLetterFormat
+	lock   sync.Mutex
+	file   *os.File
+}
+
+// NewFileDeadLetterSink opens the file to append the dead letters in the format.
+func NewFileDeadLetterSink(path string, format DeadLetterFormat) (*FileDeadLetterSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileDeadLetterSink{format: format, file: file}, nil
+}
+
+func (s *FileDeadLetterSink) Write(letter *DeadLetter) error {
+	var record []byte
+	var err error
+	if s.format == DeadLetterProtobuf {
+		record, err = encodeDeadLetterRecord(letter)
+	} else {
+		record, err = json.Marshal(toDeadLetterJSON(letter))
+		record = append(record, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.file.Write(record)
+	return err
+}
+
+// Close closes the file.
+func (s *FileDeadLetterSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+const (
+	defaultDeadLetterQueueSize        = 1024
+	defaultDeadLetterRetryElapsedTime = time.Minute
+)
+
+var errDeadLetterQueueFull = errors.New("the dead letter queue is full")
+
+// LogstoreDeadLetterSinkOptions configures a LogstoreDeadLetterSink.
+type LogstoreDeadLetterSinkOptions struct {
+	// MaxRetryElapsedTime bounds the retries of a letter failing with a
+	// retryable error, default 1 minute
+	MaxRetryElapsedTime time.Duration
+	// OnError is called by the goroutine of the sink with the letters that fail
+	// to be put after the retries, eg. to write them to a FileDeadLetterSink
+	OnError func(letter *DeadLetter, err error)
+}
+
+// LogstoreDeadLetterSink writes the dead letters to another logstore, the
+// destination and the last error of a letter are the tags __dead_letter_project__,
+// __dead_letter_logstore__, __dead_letter_error_code__ and __dead_letter_error_message__.
+// The letters are put by a goroutine so the sends of the producer are not held
+// up, Write fails when 1024 letters are queued. Close it after the producer.
+type LogstoreDeadLetterSink struct {
+	client   sls.ClientInterface
+	project  string
+	logstore string
+	options  LogstoreDeadLetterSinkOptions
+
+	lock   sync.Mutex
+	closed bool
+	queue  chan *DeadLetter
+	done   chan struct{}
+	failed int64
+}
+
+// NewLogstoreDeadLetterSink creates a LogstoreDeadLetterSink writing with the
+// client, options may be nil.
+func NewLogstoreDeadLetterSink(client sls.ClientInterface, project, logstore string, options *LogstoreDeadLetterSinkOptions) *LogstoreDeadLetterSink {
+	s := &LogstoreDeadLetterSink{
+		client:   client,
+		project:  project,
+		logstore: logstore,
+		queue:    make(chan *DeadLetter, defaultDeadLetterQueueSize),
+		done:     make(chan struct{}),
+	}
+	if options != nil {
+		s.options = *options
+	}
+	if s.options.MaxRetryElapsedTime <= 0 {
+		s.options.MaxRetryElapsedTime = defaultDeadLetterRetryElapsedTime
+	}
+	go s.run()
+	return s
+}
+
+func (s *LogstoreDeadLetterSink) run() {
+	defer close(s.done)
+	for letter := range s.queue {
+		b := backoff.NewExponentialBackOff()
+		b.MaxElapsedTime = s.options.MaxRetryElapsedTime
+		logGroup := s.logGroup(letter)
+		err := sls.RetryWithCondition(context.Background(), b, func() (bool, error) {
+			err := s.client.PutLogs(s.project, s.logstore, logGroup)
+			return err != nil && retryableDeadLetterError(err), err
+		})
+		if err != nil {
+			atomic.AddInt64(&s.failed, 1)
+			if s.options.OnError != nil {
+				s.options.OnError(letter, err)
+			}
+		}
+	}
+}
+
+// retryableDeadLetterError reports whether a failed put may succeed later, the
+// client errors other than throttling and the write quota are permanent
+func retryableDeadLetterError(err error) bool {
+	status, ok := errorStatusCode(err)
+	if !ok || status < 400 || status >= 500 || status == 429 {
+		return true
+	}
+	var slsErr *sls.Error
+	return errors.As(err, &slsErr) && (slsErr.Code == sls.WRITE_QUOTA_EXCEED || slsErr.Code == sls.SHARD_WRITE_QUOTA_EXCEED)
+}
+
+// Failed returns the number of the letters that failed to be put after the retries.
+func (s *LogstoreDeadLetterSink) Failed() int64 {
+	return atomic.LoadInt64(&s.failed)
+}
+
+// Close puts the queued letters and stops the sink, the letters written after
+// Close fail. It waits for the retries of the queued letters.
+func (s *LogstoreDeadLetterSink) Close() error {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.lock.Unlock()
+	<-s.done
+	return nil
+}
+
+func (s *LogstoreDeadLetterSink) Write(letter *DeadLetter) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return errors.New("the dead letter sink is closed")
+	}
+	select {
+	case s.queue <- letter:
+		return nil
+	default:
+		return errDeadLetterQueueFull
+	}
+}
+
+// logGroup returns the log group of a letter with the tags of its destination and error
+func (s *LogstoreDeadLetterSink) logGroup(letter *DeadLetter) *sls.LogGroup {
+	tag := func(key, value string) *sls.LogTag {
+		return &sls.LogTag{Key: proto.String(key), Value: proto.String(value)}
+	}
+	logGroup := &sls.LogGroup{
+		Logs:    letter.LogGroup.Logs,
+		Topic:   letter.LogGroup.Topic,
+		Source:  letter.LogGroup.Source,
+		LogTags: append([]*sls.LogTag{}, letter.LogGroup.LogTags...),
+	}
+	logGroup.LogTags = append(logGroup.LogTags, tag("__dead_letter_project__", letter.Project), tag("__dead_letter_logstore__", letter.Logstore))
+	if n := len(letter.Attempts); n > 0 {
+		last := letter.Attempts[n-1]
+		logGroup.LogTags = append(logGroup.LogTags, tag("__dead_letter_error_code__", last.ErrorCode), tag("__dead_letter_error_message__", last.ErrorMessage))
+	}
+	return logGroup
+}
+
+// ReadDeadLetters calls f with the dead letters of a file written by a
+// FileDeadLetterSink, it stops at the first error of f.
+func ReadDeadLetters(r io.Reader, format DeadLetterFormat, f func(letter *DeadLetter) error) error {
+	if format == DeadLetterProtobuf {
+		return readDeadLetterRecords(r, f)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var letter deadLetterJSON
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			return err
+		}
+		if err := f(letter.deadLetter()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ReplayDeadLetters sends the dead letters of a file through the producer with
+// SendLogListWithTags, with the topic, source, hash key and tags of the batches
+// except __pack_id__ and the tags the producer adds itself, and returns the
+// number of the letters. It blocks while the producer is full. The Processors
+// run on the logs again.
+func ReplayDeadLetters(path string, format DeadLetterFormat, producer *Producer, callback CallBack) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	count := 0
+	err = ReadDeadLetters(file, format, func(letter *DeadLetter) error {
+		logGroup := letter.LogGroup
+		var tags []*sls.LogTag
+		for _, tag := range logGroup.LogTags {
+			if !producer.addsTag(tag.GetKey()) {
+				tags = append(tags, tag)
+			}
+		}
+		err := producer.SendLogListWithTags(context.Background(), letter.Project, letter.Logstore, letter.ShardHash, logGroup.GetTopic(), logGroup.GetSource(), tags, logGroup.Logs, callback)
+		if err == nil {
+			count++
+		}
+		return err
+	})
+	return count, err
+}
+
+// addsTag reports whether the producer adds the tag to its log groups
+func (producer *Producer) addsTag(key string) bool {
+	if key == "__pack_id__" {
+		return true
+	}
+	for _, tag := range producer.producerConfig.LogTags {
+		if tag.GetKey() == key {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDeadLetter writes a batch that fails terminally to the DeadLetterSink
+func (producer *Producer) writeDeadLetter(producerBatch *ProducerBatch) {
+	sink := producer.producerConfig.DeadLetterSink
+	if sink == nil {
+		return
+	}
+	if err := sink.Write(newDeadLetter(producerBatch)); err != nil {
+		level.Warn(producer.logger).Log("msg", "Failed to write the dead letter", "project", producerBatch.getProject(), "logstore", producerBatch.getLogstore(), "error", err)
+	}
+}
+
+func newDeadLetter(producerBatch *ProducerBatch) *DeadLetter {
+	letter := &DeadLetter{
+		Project:  producerBatch.getProject(),
+		Logstore: producerBatch.getLogstore(),
+		LogGroup: producerBatch.logGroup,
+		Attempts: producerBatch.result.GetReservedAttempts(),
+	}
+	if shardHash := producerBatch.getShardHash(); shardHash != nil {
+		letter.ShardHash = *shardHash
+	}
+	return letter
+}
+
+type deadLetterJSON struct {
+	Project   string           `json:"project"`
+	Logstore  string           `json:"logstore"`
+	ShardHash string           `json:"shardHash,omitempty"`
+	Topic     string           `json:"topic"`
+	Source    string           `json:"source"`
+	LogTags   []deadLetterPair `json:"logTags,omitempty"`
+	Logs      []deadLetterLog  `json:"logs"`
+	Attempts  []*Attempt       `json:"attempts,omitempty"`
+}
+
+type deadLetterLog struct {
+	Time     uint32           `json:"time"`
+	TimeNs   *uint32          `json:"timeNs,omitempty"`
+	Contents []deadLetterPair `json:"contents"`
+}
+
+// deadLetterPair keeps the order and the duplicates of the contents
+type deadLetterPair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func toDeadLetterJSON(letter *DeadLetter) *deadLetterJSON {
+	j := &deadLetterJSON{
+		Project:   letter.Project,
+		Logstore:  letter.Logstore,
+		ShardHash: letter.ShardHash,
+		Topic:     letter.LogGroup.GetTopic(),
+		Source:    letter.LogGroup.GetSource(),
+		Logs:      make([]deadLetterLog, 0, len(letter.LogGroup.Logs)),
+		Attempts:  letter.Attempts,
+	}
+	for _, tag := range letter.LogGroup.LogTags {
+		j.LogTags = append(j.LogTags, deadLetterPair{tag.GetKey(), tag.GetValue()})
+	}
+	for _, log := range letter.LogGroup.Logs {
+		l := deadLetterLog{Time: log.GetTime(), TimeNs: log.TimeNs, Contents: make([]deadLetterPair, 0, len(log.Contents))}
+		for _, c := range log.Contents {
+			l.Contents = append(l.Contents, deadLetterPair{c.GetKey(), c.GetValue()})
+		}
+		j.Logs = append(j.Logs, l)
+	}
+	return j
+}
+
+func (j *deadLetterJSON) deadLetter() *DeadLetter {
+	logGroup := &sls.LogGroup{Topic: proto.String(j.Topic), Source: proto.String(j.Source)}
+	for _, tag := range j.LogTags {
+		logGroup.LogTags = append(logGroup.LogTags, &sls.LogTag{Key: proto.String(tag.Key), Value: proto.String(tag.Value)})
+	}
+	for _, l := range j.Logs {
+		log := &sls.Log{Time: proto.Uint32(l.Time), TimeNs: l.TimeNs}
+		for _, c := range l.Contents {
+			log.Contents = append(log.Contents, &sls.LogContent{Key: proto.String(c.Key), Value: proto.String(c.Value)})
+		}
+		logGroup.Logs = append(logGroup.Logs, log)
+	}
+	return &DeadLetter{Project: j.Project, Logstore: j.Logstore, ShardHash: j.ShardHash, LogGroup: logGroup, Attempts: j.Attempts}
+}
+
+// encodeDeadLetterRecord encodes | length uint32 | crc32 uint32 | header length uvarint | JSON header | LogGroup |
+func encodeDeadLetterRecord(letter *DeadLetter) ([]byte, error) {
+	header, err := json.Marshal(&deadLetterJSON{
+		Project:   letter.Project,
+		Logstore:  letter.Logstore,
+		ShardHash: letter.ShardHash,
+		Attempts:  letter.Attempts,
+	})
+	if err != nil {
+		return nil, err
+	}
+	logGroup, err := letter.LogGroup.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	payload := binary.AppendUvarint(make([]byte, 0, len(header)+len(logGroup)+8), uint64(len(header)))
+	payload = append(append(payload, header...), logGroup...)
+	record := make([]byte, spoolRecordHeaderSize, spoolRecordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	return append(record, payload...), nil
+}
+
+func readDeadLetterRecords(r io.Reader, f func(letter *DeadLetter) error) error {
+	reader := bufio.NewReader(r)
+	header := make([]byte, spoolRecordHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		payload := make([]byte, binary.LittleEndian.Uint32(header))
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return err
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+			return errors.New("invalid dead letter checksum")
+		}
+		n, l := binary.Uvarint(payload)
+		if l <= 0 || uint64(len(payload)-l) < n {
+			return errors.New("invalid dead letter record")
+		}
+		var j deadLetterJSON
+		if err := json.Unmarshal(payload[l:l+int(n)], &j); err != nil {
+			return err
+		}
+		logGroup := &sls.LogGroup{}
+		if err := logGroup.Unmarshal(payload[l+int(n):]); err != nil {
+			return err
+		}
+		letter := j.deadLetter()
+		letter.LogGroup = logGroup
+		if err := f(letter); err != nil {
+			return err
+		}
+	}
+}
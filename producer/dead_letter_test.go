@@ -0,0 +1,254 @@
+package producer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+func testDeadLetter() *DeadLetter {
+	return &DeadLetter{
+		Project:   "p",
+		Logstore:  "l",
+		ShardHash: "hash",
+		LogGroup: &sls.LogGroup{
+			Topic:   proto.String("topic"),
+			Source:  proto.String("source"),
+			LogTags: []*sls.LogTag{{Key: proto.String("tag"), Value: proto.String("v")}},
+			Logs: []*sls.Log{{
+				Time:   proto.Uint32(1),
+				TimeNs: proto.Uint32(2),
+				// the order and the duplicated keys are kept
+				Contents: []*sls.LogContent{
+					{Key: proto.String("b"), Value: proto.String("1")},
+					{Key: proto.String("a"), Value: proto.String("2")},
+					{Key: proto.String("b"), Value: proto.String("3")},
+				},
+			}},
+		},
+		Attempts: []*Attempt{{Success: false, ErrorCode: "BadGateway", ErrorMessage: "unavailable", TimeStampMs: 100}},
+	}
+}
+
+func TestFileDeadLetterSink(t *testing.T) {
+	for _, format := range []DeadLetterFormat{DeadLetterJSON, DeadLetterProtobuf} {
+		path := filepath.Join(t.TempDir(), "dead")
+		sink, err := NewFileDeadLetterSink(path, format)
+		if err != nil {
+			t.Fatalf("NewFileDeadLetterSink() error = %v", err)
+		}
+		want := testDeadLetter()
+		for i := 0; i < 2; i++ {
+			if err := sink.Write(want); err != nil {
+				t.Fatalf("%d. Write() error = %v", format, err)
+			}
+		}
+		sink.Close()
+
+		file, _ := os.Open(path)
+		var letters []*DeadLetter
+		err = ReadDeadLetters(file, format, func(letter *DeadLetter) error {
+			letters = append(letters, letter)
+			return nil
+		})
+		file.Close()
+		if err != nil || len(letters) != 2 {
+			t.Fatalf("%d. ReadDeadLetters() = %d letters, %v, want 2", format, len(letters), err)
+		}
+		got := letters[1]
+		if got.Project != want.Project || got.Logstore != want.Logstore || got.ShardHash != want.ShardHash {
+			t.Errorf("%d. ReadDeadLetters() = %+v, want %+v", format, got, want)
+		}
+		if got.LogGroup.String() != want.LogGroup.String() {
+			t.Errorf("%d. ReadDeadLetters() LogGroup = %v, want %v", format, got.LogGroup, want.LogGroup)
+		}
+		if len(got.Attempts) != 1 || *got.Attempts[0] != *want.Attempts[0] {
+			t.Errorf("%d. ReadDeadLetters() Attempts = %v, want %v", format, got.Attempts, want.Attempts)
+		}
+	}
+}
+
+type putLogsClient struct {
+	sls.ClientInterface
+	lock      sync.Mutex
+	blocked   chan struct{}
+	errs      []error
+	calls     int
+	project   string
+	logstore  string
+	logGroups []*sls.LogGroup
+}
+
+// PutLogs fails with the errs in turn before it succeeds
+func (c *putLogsClient) PutLogs(project, logstore string, lg *sls.LogGroup) error {
+	if c.blocked != nil {
+		<-c.blocked
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return err
+	}
+	c.project, c.logstore = project, logstore
+	c.logGroups = append(c.logGroups, lg)
+	return nil
+}
+
+func TestLogstoreDeadLetterSink(t *testing.T) {
+	client := &putLogsClient{}
+	letter := testDeadLetter()
+	sink := NewLogstoreDeadLetterSink(client, "dp", "dl", nil)
+	if err := sink.Write(letter); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	sink.Close()
+	if err := sink.Write(letter); err == nil {
+		t.Errorf("Write() after Close() succeeds")
+	}
+	if client.project != "dp" || client.logstore != "dl" || len(client.logGroups) != 1 {
+		t.Fatalf("PutLogs() = %s/%s %d log groups", client.project, client.logstore, len(client.logGroups))
+	}
+	tags := map[string]string{}
+	for _, tag := range client.logGroups[0].LogTags {
+		tags[tag.GetKey()] = tag.GetValue()
+	}
+	want := map[string]string{
+		"tag":                           "v",
+		"__dead_letter_project__":       "p",
+		"__dead_letter_logstore__":      "l",
+		"__dead_letter_error_code__":    "BadGateway",
+		"__dead_letter_error_message__": "unavailable",
+	}
+	for key, value := range want {
+		if tags[key] != value {
+			t.Errorf("tag %q = %q, want %q", key, tags[key], value)
+		}
+	}
+	if len(letter.LogGroup.LogTags) != 1 {
+		t.Errorf("Write() changed the tags of the letter: %v", letter.LogGroup.LogTags)
+	}
+}
+
+func TestLogstoreDeadLetterSinkFull(t *testing.T) {
+	client := &putLogsClient{blocked: make(chan struct{})}
+	sink := NewLogstoreDeadLetterSink(client, "dp", "dl", nil)
+	// Write does not wait for the blocked PutLogs
+	written := 0
+	for ; written <= defaultDeadLetterQueueSize+1; written++ {
+		if err := sink.Write(testDeadLetter()); err != nil {
+			if err != errDeadLetterQueueFull {
+				t.Fatalf("Write() error = %v, want %v", err, errDeadLetterQueueFull)
+			}
+			break
+		}
+	}
+	if written < defaultDeadLetterQueueSize || written > defaultDeadLetterQueueSize+1 {
+		t.Errorf("Write() queues %d letters, want %d", written, defaultDeadLetterQueueSize)
+	}
+	close(client.blocked)
+	sink.Close()
+	if len(client.logGroups) != written || sink.Failed() != 0 {
+		t.Errorf("PutLogs() = %d log groups, %d failed, want %d", len(client.logGroups), sink.Failed(), written)
+	}
+}
+
+func TestLogstoreDeadLetterSinkRetry(t *testing.T) {
+	client := &putLogsClient{errs: []error{&sls.Error{HTTPCode: 502, Code: "BadGateway"}, &sls.Error{HTTPCode: 403, Code: sls.WRITE_QUOTA_EXCEED}}}
+	var failed []error
+	sink := NewLogstoreDeadLetterSink(client, "dp", "dl", &LogstoreDeadLetterSinkOptions{
+		OnError: func(letter *DeadLetter, err error) { failed = append(failed, err) },
+	})
+	// the transient errors are retried
+	sink.Write(testDeadLetter())
+	sink.Close()
+	if client.calls != 3 || len(client.logGroups) != 1 || len(failed) != 0 {
+		t.Fatalf("PutLogs() is called %d times, %d log groups, errors %v, want 3, 1", client.calls, len(client.logGroups), failed)
+	}
+
+	// the permanent errors are not
+	client = &putLogsClient{errs: []error{&sls.Error{HTTPCode: 404, Code: "LogStoreNotExist"}}}
+	sink = NewLogstoreDeadLetterSink(client, "dp", "dl", &LogstoreDeadLetterSinkOptions{
+		OnError: func(letter *DeadLetter, err error) { failed = append(failed, err) },
+	})
+	sink.Write(testDeadLetter())
+	sink.Close()
+	if client.calls != 1 || len(failed) != 1 || sink.Failed() != 1 {
+		t.Errorf("PutLogs() is called %d times, errors %v, want 1 call and 1 error", client.calls, failed)
+	}
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	sink, err := NewFileDeadLetterSink(path, DeadLetterJSON)
+	if err != nil {
+		t.Fatalf("NewFileDeadLetterSink() error = %v", err)
+	}
+	var lock sync.Mutex
+	var letters []*DeadLetter
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.LingerMs = 3000
+	config.Retries = 0
+	config.GeneratePackId = true
+	config.LogTags = []*sls.LogTag{{Key: proto.String("env"), Value: proto.String("prod")}}
+	config.DeadLetterSink = DeadLetterFunc(func(letter *DeadLetter) error {
+		lock.Lock()
+		letters = append(letters, letter)
+		lock.Unlock()
+		// a tag of another producer
+		copied := *letter
+		copied.LogGroup = &sls.LogGroup{Logs: letter.LogGroup.Logs, Topic: letter.LogGroup.Topic, Source: letter.LogGroup.Source,
+			LogTags: append(append([]*sls.LogTag{}, letter.LogGroup.LogTags...), &sls.LogTag{Key: proto.String("host"), Value: proto.String("h1")})}
+		return sink.Write(&copied)
+	})
+	producer := InitProducer(config)
+	client := &flakyClient{}
+	producer.threadPool.ioworker.client = client
+	producer.Start()
+	defer producer.SafeClose()
+
+	log := GenerateLog(uint32(time.Now().Unix()), map[string]string{"content": "test"})
+	producer.SendLog("p", "l", "topic", "", log)
+	if _, err := producer.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	sink.Close()
+	lock.Lock()
+	if len(letters) != 1 || letters[0].Logstore != "l" || len(letters[0].LogGroup.Logs) != 1 ||
+		len(letters[0].Attempts) != 1 || letters[0].Attempts[0].ErrorCode != "BadGateway" {
+		t.Fatalf("DeadLetterSink got %+v, want a failed batch", letters)
+	}
+	lock.Unlock()
+
+	client.setHealthy()
+	n, err := ReplayDeadLetters(path, DeadLetterJSON, producer, nil)
+	if err != nil || n != 1 {
+		t.Fatalf("ReplayDeadLetters() = %d, %v, want 1", n, err)
+	}
+	if result, err := producer.Flush(context.Background()); err != nil || result.Batches != 1 || result.FailedBatches != 0 {
+		t.Errorf("Flush() = %+v, %v, want a sent batch", result, err)
+	}
+	if client.sentCount() != 1 || client.sent[0].GetTopic() != "topic" {
+		t.Fatalf("sent %v, want the replayed log group", client.sent)
+	}
+	// the tags the producer adds are not copied
+	tags := map[string]string{}
+	for _, tag := range client.sent[0].LogTags {
+		tags[tag.GetKey()] = tag.GetValue()
+	}
+	if len(client.sent[0].LogTags) != 3 || tags["env"] != "prod" || tags["host"] != "h1" || tags["__pack_id__"] == "" {
+		t.Errorf("replayed tags = %v, want env, host and a pack id", client.sent[0].LogTags)
+	}
+	if got := contentsOf(client.sent[0].Logs[0]); len(got) != 1 {
+		t.Errorf("replayed contents = %v", got)
+	}
+}
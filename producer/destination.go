@@ -0,0 +1,212 @@
+package producer
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+const defaultCircuitBreakerOpenMs = 30 * 1000
+
+// the state of a destination without logs, requests and failures is removed
+// after it is not used for destinationIdleMs, see destinations.evict
+const destinationIdleMs = 10 * 60 * 1000
+
+// a request is slow when it takes longer than the tolerance times the lowest
+// latency of the destination plus the slack, see destinations.adapt
+const (
+	adaptiveLatencyTolerance = 2
+	adaptiveLatencySlackMs   = 10
+)
+
+// destinationState is the buffer and the circuit breaker of a project and logstore
+type destinationState struct {
+	bytes int64
+	// the consecutive failed requests
+	failures int
+	// the destination is suspended until openUntilMs, then one batch is sent as a probe
+	openUntilMs int64
+	probing     bool
+
+	// the requests being sent and their limit with AdaptiveConcurrency
+	inflight int
+	limit    float64
+	// the baseline latency, it follows the lowest latency
+	minCostMs float64
+	// the limit grows by one per success until the first decrease
+	slowStart  bool
+	lastUsedMs int64
+}
+
+// destinations isolates the project and logstore pairs of a producer, see
+// ProducerConfig.MaxDestinationBytes and CircuitBreakerFailures.
+type destinations struct {
+	lock             sync.Mutex
+	states           map[destinationKey]*destinationState
+	maxBytes         int64
+	failureThreshold int
+	openMs           int64
+	adaptive         bool
+	minLimit         float64
+	maxLimit         float64
+	lastEvictMs      int64
+}
+
+func newDestinations(config *ProducerConfig) *destinations {
+	return &destinations{
+		states:           map[destinationKey]*destinationState{},
+		maxBytes:         config.MaxDestinationBytes,
+		failureThreshold: config.CircuitBreakerFailures,
+		openMs:           config.CircuitBreakerOpenMs,
+		adaptive:         config.AdaptiveConcurrency,
+		minLimit:         float64(config.AdaptiveMinConcurrency),
+		maxLimit:         float64(config.AdaptiveMaxConcurrency),
+	}
+}
+
+// state returns the state of a destination, d.lock is held
+func (d *destinations) state(key destinationKey) *destinationState {
+	nowMs := GetTimeMs(time.Now().UnixNano())
+	if nowMs-d.lastEvictMs >= destinationIdleMs {
+		d.evict(nowMs)
+	}
+	state, ok := d.states[key]
+	if !ok {
+		state = &destinationState{limit: d.minLimit, slowStart: true}
+		d.states[key] = state
+	}
+	state.lastUsedMs = nowMs
+	return state
+}
+
+// evict removes the idle states, so a producer writing to many destinations
+// over time does not keep all of them, d.lock is held
+func (d *destinations) evict(nowMs int64) {
+	d.lastEvictMs = nowMs
+	for key, state := range d.states {
+		if state.bytes == 0 && state.inflight == 0 && state.failures == 0 && !state.probing && nowMs-state.lastUsedMs >= destinationIdleMs {
+			delete(d.states, key)
+		}
+	}
+}
+
+func (d *destinations) addSize(key destinationKey, size int64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.state(key).bytes += size
+}
+
+// isFull reports whether the logs of a destination exceed MaxDestinationBytes
+func (d *destinations) isFull(key destinationKey) bool {
+	if d.maxBytes <= 0 {
+		return false
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	state, ok := d.states[key]
+	return ok && state.bytes > d.maxBytes
+}
+
+// isSuspended reports whether the circuit of a destination is open
+func (d *destinations) isSuspended(key destinationKey) bool {
+	if d.failureThreshold <= 0 {
+		return false
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	state, ok := d.states[key]
+	return ok && state.failures >= d.failureThreshold && (state.probing || GetTimeMs(time.Now().UnixNano()) < state.openUntilMs)
+}
+
+// allow reports whether a batch of a destination may be sent now and counts
+// it as in flight, force skips the checks when the producer is closing. The
+// first batch after the circuit is open for CircuitBreakerOpenMs is the probe.
+func (d *destinations) allow(key destinationKey, force bool) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	state := d.state(key)
+	if !force {
+		if d.adaptive && float64(state.inflight) >= math.Floor(state.limit) {
+			return false
+		}
+		if d.failureThreshold > 0 && state.failures >= d.failureThreshold {
+			if state.probing || GetTimeMs(time.Now().UnixNano()) < state.openUntilMs {
+				return false
+			}
+			state.probing = true
+		}
+	}
+	state.inflight++
+	return true
+}
+
+// release ends a batch counted by allow that is not sent
+func (d *destinations) release(key destinationKey) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.state(key).inflight--
+}
+
+// report ends a request counted by allow. The circuit is open after
+// CircuitBreakerFailures consecutive failures and closed after a success,
+// and the concurrency limit is adapted to the latency and the quota errors.
+func (d *destinations) report(key destinationKey, costMs int64, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	state := d.state(key)
+	state.inflight--
+	if d.adaptive {
+		d.adapt(state, costMs, err)
+	}
+	if d.failureThreshold <= 0 {
+		return
+	}
+	state.probing = false
+	if err == nil {
+		state.failures = 0
+		return
+	}
+	state.failures++
+	if state.failures >= d.failureThreshold {
+		state.openUntilMs = GetTimeMs(time.Now().UnixNano()) + d.openMs
+	}
+}
+
+// adapt is AIMD, the limit is halved by a quota error and cut by a tenth by a
+// slow request, and grows by 1/limit per fast success, d.lock is held
+func (d *destinations) adapt(state *destinationState, costMs int64, err error) {
+	if err != nil {
+		var slsError *sls.Error
+		if errors.As(err, &slsError) && (slsError.Code == sls.SHARD_WRITE_QUOTA_EXCEED || slsError.Code == sls.WRITE_QUOTA_EXCEED) {
+			state.limit = math.Max(d.minLimit, state.limit/2)
+			state.slowStart = false
+		}
+		// the other errors do not tell about the load
+		return
+	}
+	cost := float64(costMs)
+	if state.minCostMs == 0 || cost < state.minCostMs {
+		state.minCostMs = cost
+	} else {
+		// follow a latency that rises for good, eg. a larger batch size
+		state.minCostMs += (cost - state.minCostMs) * 0.01
+	}
+	if cost > state.minCostMs*adaptiveLatencyTolerance+adaptiveLatencySlackMs {
+		state.limit = math.Max(d.minLimit, state.limit*0.9)
+		state.slowStart = false
+		return
+	}
+	if state.slowStart {
+		state.limit++
+	} else {
+		state.limit += 1 / state.limit
+	}
+	state.limit = math.Min(d.maxLimit, state.limit)
+}
+
+func batchDestination(producerBatch *ProducerBatch) destinationKey {
+	return destinationKey{producerBatch.getProject(), producerBatch.getLogstore()}
+}
@@ -0,0 +1,153 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+var errUnavailable = &sls.Error{HTTPCode: 502, Code: "BadGateway"}
+
+func TestDestinationsCircuitBreaker(t *testing.T) {
+	d := newDestinations(&ProducerConfig{CircuitBreakerFailures: 2, CircuitBreakerOpenMs: 50})
+	key := destinationKey{"p", "l"}
+	d.report(key, 0, errUnavailable)
+	if !d.allow(key, false) {
+		t.Fatal("allow() = false before the threshold")
+	}
+	d.report(key, 0, errUnavailable)
+	if d.allow(key, false) || !d.isSuspended(key) {
+		t.Fatal("allow() = true after the threshold")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !d.allow(key, false) {
+		t.Fatal("allow() = false for the probe")
+	}
+	if d.allow(key, false) {
+		t.Fatal("allow() = true while probing")
+	}
+	d.report(key, 0, nil)
+	if !d.allow(key, false) || d.isSuspended(key) {
+		t.Fatal("allow() = false after a success")
+	}
+}
+
+func TestIoThreadPoolFairness(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.CircuitBreakerFailures = 1
+	producer := InitProducer(config)
+	pool := producer.threadPool
+	for _, logstore := range []string{"a", "a", "a", "b", "c"} {
+		pool.addTask(&ProducerBatch{project: "p", logstore: logstore})
+	}
+	producer.destinations.report(destinationKey{"p", "c"}, 0, errUnavailable)
+
+	var got []string
+	for batch := pool.popTask(); batch != nil; batch = pool.popTask() {
+		got = append(got, batch.logstore)
+	}
+	if want := []string{"a", "b", "a", "a"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[3] != want[3] {
+		t.Errorf("popTask() order = %v, want %v", got, want)
+	}
+	if !pool.hasTask() {
+		t.Error("hasTask() = false, want the batch of the suspended destination")
+	}
+	pool.threadPoolShutDownFlag.Store(true)
+	if batch := pool.popTask(); batch == nil || batch.logstore != "c" || pool.hasTask() {
+		t.Errorf("popTask() = %v when closing, want the suspended batch", batch)
+	}
+}
+
+func TestMaxDestinationBytes(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.MaxDestinationBytes = 10
+	producer := InitProducer(config)
+	log := GenerateLog(uint32(time.Now().Unix()), map[string]string{"content": "more than ten bytes"})
+	if err := producer.SendLog("p", "slow", "", "", log); err != nil {
+		t.Fatalf("SendLog() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var blocked *BlockedError
+	if err := producer.SendLogContext(ctx, "p", "slow", "", "", log, nil); !errors.As(err, &blocked) {
+		t.Errorf("SendLogContext() error = %v, want a BlockedError over the quota", err)
+	}
+	if err := producer.SendLogContext(ctx, "p", "fast", "", "", log, nil); err != nil {
+		t.Errorf("SendLogContext() error = %v of another destination", err)
+	}
+}
+
+func TestDestinationsAdaptiveConcurrency(t *testing.T) {
+	d := newDestinations(&ProducerConfig{AdaptiveConcurrency: true, AdaptiveMinConcurrency: 1, AdaptiveMaxConcurrency: 8})
+	key := destinationKey{"p", "l"}
+	limit := func() float64 {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		return d.state(key).limit
+	}
+	if !d.allow(key, false) || d.allow(key, false) {
+		t.Fatal("allow() does not limit the requests to AdaptiveMinConcurrency")
+	}
+	d.report(key, 20, nil)
+
+	// slow start up to the max
+	for i := 0; i < 10; i++ {
+		d.allow(key, false)
+		d.report(key, 20, nil)
+	}
+	if limit() != 8 {
+		t.Errorf("limit = %v after fast requests, want 8", limit())
+	}
+	d.allow(key, false)
+	d.report(key, 100, nil)
+	if got := limit(); got != 7.2 {
+		t.Errorf("limit = %v after a slow request, want 7.2", got)
+	}
+	d.allow(key, false)
+	d.report(key, 20, fmt.Errorf("post logs: %w", &sls.Error{HTTPCode: 403, Code: sls.SHARD_WRITE_QUOTA_EXCEED}))
+	if got := limit(); got != 3.6 {
+		t.Errorf("limit = %v after a wrapped quota error, want 3.6", got)
+	}
+	d.allow(key, false)
+	d.report(key, 20, nil)
+	if got := limit(); got <= 3.6 || got >= 4 {
+		t.Errorf("limit = %v after a fast request, want an additive increase", got)
+	}
+	for i := 0; i < 3; i++ {
+		if !d.allow(key, false) {
+			t.Fatalf("allow() = false for request %d under the limit", i)
+		}
+	}
+	if d.allow(key, false) {
+		t.Error("allow() = true over the limit")
+	}
+}
+
+func TestDestinationsEvict(t *testing.T) {
+	d := newDestinations(&ProducerConfig{CircuitBreakerFailures: 1, CircuitBreakerOpenMs: defaultCircuitBreakerOpenMs})
+	idle, buffered, open := destinationKey{"p", "idle"}, destinationKey{"p", "buffered"}, destinationKey{"p", "open"}
+	d.addSize(idle, 10)
+	d.addSize(idle, -10)
+	d.addSize(buffered, 10)
+	d.allow(open, false)
+	d.report(open, 20, errUnavailable)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, state := range d.states {
+		state.lastUsedMs -= destinationIdleMs
+	}
+	d.lastEvictMs -= destinationIdleMs
+	d.state(destinationKey{"p", "new"})
+	// the destinations with logs or an open circuit are kept
+	if _, ok := d.states[idle]; ok || len(d.states) != 3 {
+		t.Errorf("states = %v, want the idle destination evicted", d.states)
+	}
+}
@@ -0,0 +1,121 @@
+package producer
+
+import (
+	"context"
+	"sync"
+)
+
+// FlushResult summarizes the batches Flush waits for.
+type FlushResult struct {
+	Batches        int
+	Logs           int
+	FailedBatches  int
+	FailedLogs     int
+	SpooledBatches int
+	SpooledLogs    int
+	// Failures are the batches that failed, in the order they failed
+	Failures []*FlushFailure
+}
+
+// FlushFailure is a batch that failed during Flush.
+type FlushFailure struct {
+	Project  string
+	Logstore string
+	Logs     int
+	Result   *Result
+}
+
+// flushWaiter counts the batches of a Flush that are not finished
+type flushWaiter struct {
+	lock    sync.Mutex
+	pending int
+	result  FlushResult
+	done    chan struct{}
+}
+
+func (w *flushWaiter) finish(producerBatch *ProducerBatch, spooled bool) {
+	logs := len(producerBatch.logGroup.GetLogs())
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.result.Batches++
+	w.result.Logs += logs
+	if spooled {
+		w.result.SpooledBatches++
+		w.result.SpooledLogs += logs
+	} else if !producerBatch.result.IsSuccessful() {
+		w.result.FailedBatches++
+		w.result.FailedLogs += logs
+		w.result.Failures = append(w.result.Failures, &FlushFailure{
+			Project:  producerBatch.getProject(),
+			Logstore: producerBatch.getLogstore(),
+			Logs:     logs,
+			Result:   producerBatch.result,
+		})
+	}
+	w.pending--
+	if w.pending == 0 {
+		close(w.done)
+	}
+}
+
+func (w *flushWaiter) snapshot() *FlushResult {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	result := w.result
+	result.Failures = append([]*FlushFailure{}, w.result.Failures...)
+	return &result
+}
+
+// Flush sends or spools the batches being accumulated without waiting for
+// LingerMs, and waits until they and the batches being sent or retried are
+// delivered, failed or spooled. The producer keeps running, and the logs sent during Flush are not
+// waited for. It returns the partial result and ctx.Err() if ctx is done first.
+func (producer *Producer) Flush(ctx context.Context) (*FlushResult, error) {
+	waiter := &flushWaiter{done: make(chan struct{})}
+	producer.batchLock.Lock()
+	for producerBatch := range producer.liveBatches {
+		producerBatch.flushWaiters = append(producerBatch.flushWaiters, waiter)
+	}
+	waiter.pending = len(producer.liveBatches)
+	pending := waiter.pending
+	producer.batchLock.Unlock()
+	if pending == 0 {
+		return &FlushResult{}, nil
+	}
+
+	accumulator := producer.logAccumulator
+	accumulator.lock.Lock()
+	for key, producerBatch := range accumulator.logGroupData {
+		accumulator.innerSendToServer(key, producerBatch)
+	}
+	accumulator.lock.Unlock()
+	if producer.spool != nil {
+		accumulator.spoolBatches(true)
+	}
+
+	select {
+	case <-waiter.done:
+		return waiter.snapshot(), nil
+	case <-ctx.Done():
+		return waiter.snapshot(), ctx.Err()
+	}
+}
+
+// registerBatch keeps a batch in memory until completeBatch, so Flush can wait for it
+func (producer *Producer) registerBatch(producerBatch *ProducerBatch) {
+	producer.batchLock.Lock()
+	defer producer.batchLock.Unlock()
+	producer.liveBatches[producerBatch] = struct{}{}
+}
+
+// completeBatch is called when a batch is delivered, failed or spooled
+func (producer *Producer) completeBatch(producerBatch *ProducerBatch, spooled bool) {
+	producer.batchLock.Lock()
+	delete(producer.liveBatches, producerBatch)
+	waiters := producerBatch.flushWaiters
+	producerBatch.flushWaiters = nil
+	producer.batchLock.Unlock()
+	for _, waiter := range waiters {
+		waiter.finish(producerBatch, spooled)
+	}
+}
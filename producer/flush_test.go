@@ -0,0 +1,56 @@
+package producer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFlush(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.LingerMs = 3000
+	config.Retries = 0
+	producer := InitProducer(config)
+	client := &flakyClient{}
+	producer.threadPool.ioworker.client = client
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if result, err := producer.Flush(ctx); err != nil || result.Batches != 0 {
+		t.Errorf("Flush() = %+v, %v of an empty producer", result, err)
+	}
+	log := GenerateLog(uint32(time.Now().Unix()), map[string]string{"content": "test"})
+	producer.SendLog("p", "l", "", "", log)
+	// the producer is not started
+	if _, err := producer.Flush(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Flush() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	producer.Start()
+	defer producer.SafeClose()
+	result, err := producer.Flush(context.Background())
+	if err != nil || result.Batches != 1 || result.FailedBatches != 1 || len(result.Failures) != 1 {
+		t.Fatalf("Flush() = %+v, %v, want a failed batch", result, err)
+	}
+	if failure := result.Failures[0]; failure.Logstore != "l" || failure.Logs != 1 || failure.Result.GetErrorCode() != "BadGateway" {
+		t.Errorf("Flush() failure = %+v", failure)
+	}
+
+	// LingerMs is not waited for, and the producer keeps running
+	client.setHealthy()
+	for i := 0; i < 2; i++ {
+		producer.SendLog("p", "l", "", "", log)
+		start := time.Now()
+		result, err = producer.Flush(context.Background())
+		if err != nil || result.Batches != 1 || result.Logs != 1 || result.FailedBatches != 0 {
+			t.Errorf("Flush() = %+v, %v, want a sent batch", result, err)
+		}
+		if time.Since(start) > time.Second {
+			t.Errorf("Flush() takes %v", time.Since(start))
+		}
+	}
+	if client.sentCount() != 2 {
+		t.Errorf("sent %d log groups, want 2", client.sentCount())
+	}
+}
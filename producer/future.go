@@ -0,0 +1,111 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+// Future is the result of the batch of the logs sent by SendLogFuture, it is
+// resolved when the batch succeeds or fails terminally.
+type Future struct {
+	once   sync.Once
+	done   chan struct{}
+	result *Result
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) Success(result *Result) {
+	f.resolve(result)
+}
+
+func (f *Future) Fail(result *Result) {
+	f.resolve(result)
+}
+
+func (f *Future) resolve(result *Result) {
+	f.once.Do(func() {
+		f.result = result
+		close(f.done)
+	})
+}
+
+// Done is closed when the future is resolved.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result returns the result of the batch, nil before Done is closed.
+func (f *Future) Result() *Result {
+	select {
+	case <-f.done:
+		return f.result
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the future is resolved or ctx is done. It returns a
+// *SendFailedError with the result if the batch failed, and ctx.Err() if ctx is
+// done first.
+func (f *Future) Wait(ctx context.Context) (*Result, error) {
+	select {
+	case <-f.done:
+		if !f.result.IsSuccessful() {
+			return f.result, &SendFailedError{Result: f.result}
+		}
+		return f.result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// SendFailedError is returned by Future.Wait when the batch failed.
+type SendFailedError struct {
+	Result *Result
+}
+
+func (e *SendFailedError) Error() string {
+	return fmt.Sprintf("send logs failed, code: %s, message: %s, request id: %s", e.Result.GetErrorCode(), e.Result.GetErrorMessage(), e.Result.GetRequestId())
+}
+
+// SendLogFuture is SendLogWithCallBack with a Future as the callback.
+func (producer *Producer) SendLogFuture(project, logstore, topic, source string, log *sls.Log) (*Future, error) {
+	future := newFuture()
+	if err := producer.SendLogWithCallBack(project, logstore, topic, source, log, future); err != nil {
+		return nil, err
+	}
+	return future, nil
+}
+
+// SendLogListFuture is SendLogListWithCallBack with a Future as the callback.
+func (producer *Producer) SendLogListFuture(project, logstore, topic, source string, logList []*sls.Log) (*Future, error) {
+	future := newFuture()
+	if err := producer.SendLogListWithCallBack(project, logstore, topic, source, logList, future); err != nil {
+		return nil, err
+	}
+	return future, nil
+}
+
+// HashSendLogFuture is HashSendLogWithCallBack with a Future as the callback.
+func (producer *Producer) HashSendLogFuture(project, logstore, shardHash, topic, source string, log *sls.Log) (*Future, error) {
+	future := newFuture()
+	if err := producer.HashSendLogWithCallBack(project, logstore, shardHash, topic, source, log, future); err != nil {
+		return nil, err
+	}
+	return future, nil
+}
+
+// HashSendLogListFuture is HashSendLogListWithCallBack with a Future as the callback.
+func (producer *Producer) HashSendLogListFuture(project, logstore, shardHash, topic, source string, logList []*sls.Log) (*Future, error) {
+	future := newFuture()
+	if err := producer.HashSendLogListWithCallBack(project, logstore, shardHash, topic, source, logList, future); err != nil {
+		return nil, err
+	}
+	return future, nil
+}
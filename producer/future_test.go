@@ -0,0 +1,62 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSendLogFuture(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.LingerMs = 100
+	config.Retries = 0
+	producer := InitProducer(config)
+	client := &flakyClient{}
+	producer.threadPool.ioworker.client = client
+
+	log := GenerateLog(uint32(time.Now().Unix()), map[string]string{"content": "test"})
+	future, err := producer.SendLogFuture("p", "l", "", "", log)
+	if err != nil {
+		t.Fatalf("SendLogFuture() error = %v", err)
+	}
+	if future.Result() != nil {
+		t.Errorf("Result() = %v before the batch is sent", future.Result())
+	}
+	// the producer is not started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := future.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	producer.Start()
+	defer producer.SafeClose()
+	result, err := future.Wait(context.Background())
+	var failed *SendFailedError
+	if !errors.As(err, &failed) || result.IsSuccessful() || failed.Result.GetErrorCode() != "BadGateway" {
+		t.Errorf("Wait() = %v, %v, want a *SendFailedError", result, err)
+	}
+
+	client.setHealthy()
+	futures := make([]*Future, 3)
+	for i := range futures {
+		if futures[i], err = producer.HashSendLogFuture("p", "l", "hash", "", "", log); err != nil {
+			t.Fatalf("HashSendLogFuture() error = %v", err)
+		}
+	}
+	for i, future := range futures {
+		if result, err := future.Wait(context.Background()); err != nil || !result.IsSuccessful() {
+			t.Errorf("%d. Wait() = %v, %v, want a success", i, result, err)
+		}
+		<-future.Done()
+		if future.Result() == nil {
+			t.Errorf("%d. Result() = nil after Done", i)
+		}
+	}
+	// the logs are sent in a batch
+	if client.sentCount() != 1 {
+		t.Errorf("sent %d log groups, want 1", client.sentCount())
+	}
+}
@@ -10,9 +10,14 @@ import (
 	"go.uber.org/atomic"
 )
 
+// IoThreadPool queues the batches by project and logstore and takes them in
+// turn, so a destination with many batches does not delay the others.
 type IoThreadPool struct {
 	threadPoolShutDownFlag *atomic.Bool
-	queue                  *list.List
+	queues                 map[destinationKey]*list.List
+	order                  []destinationKey
+	next                   int
+	size                   int
 	lock                   sync.RWMutex
 	ioworker               *IoWorker
 	logger                 log.Logger
@@ -21,7 +26,7 @@ type IoThreadPool struct {
 func initIoThreadPool(ioworker *IoWorker, logger log.Logger) *IoThreadPool {
 	return &IoThreadPool{
 		threadPoolShutDownFlag: atomic.NewBool(false),
-		queue:                  list.New(),
+		queues:                 map[destinationKey]*list.List{},
 		ioworker:               ioworker,
 		logger:                 logger,
 	}
@@ -30,24 +35,72 @@ func initIoThreadPool(ioworker *IoWorker, logger log.Logger) *IoThreadPool {
 func (threadPool *IoThreadPool) addTask(batch *ProducerBatch) {
 	defer threadPool.lock.Unlock()
 	threadPool.lock.Lock()
-	threadPool.queue.PushBack(batch)
+	key := batchDestination(batch)
+	queue, ok := threadPool.queues[key]
+	if !ok {
+		queue = list.New()
+		threadPool.queues[key] = queue
+		threadPool.order = append(threadPool.order, key)
+	}
+	queue.PushBack(batch)
+	threadPool.size++
 }
 
+// popTask takes the next batch in turn, skipping the destinations suspended by
+// the circuit breaker or at their concurrency limit unless the producer is closing.
 func (threadPool *IoThreadPool) popTask() *ProducerBatch {
 	defer threadPool.lock.Unlock()
 	threadPool.lock.Lock()
-	if threadPool.queue.Len() <= 0 {
-		return nil
+	shutDown := threadPool.threadPoolShutDownFlag.Load()
+	for i := 0; i < len(threadPool.order); i++ {
+		index := (threadPool.next + i) % len(threadPool.order)
+		key := threadPool.order[index]
+		queue := threadPool.queues[key]
+		if queue.Len() == 0 {
+			continue
+		}
+		if !threadPool.ioworker.producer.destinations.allow(key, shutDown) {
+			continue
+		}
+		threadPool.next = index + 1
+		ele := queue.Front()
+		queue.Remove(ele)
+		threadPool.size--
+		if queue.Len() == 0 {
+			threadPool.removeQueue(index)
+		}
+		return ele.Value.(*ProducerBatch)
+	}
+	return nil
+}
+
+// removeQueue drops the empty queue of a destination, threadPool.lock is held
+func (threadPool *IoThreadPool) removeQueue(index int) {
+	delete(threadPool.queues, threadPool.order[index])
+	threadPool.order = append(threadPool.order[:index], threadPool.order[index+1:]...)
+	if threadPool.next > index {
+		threadPool.next--
+	}
+	if threadPool.next >= len(threadPool.order) {
+		threadPool.next = 0
 	}
-	ele := threadPool.queue.Front()
-	threadPool.queue.Remove(ele)
-	return ele.Value.(*ProducerBatch)
 }
 
 func (threadPool *IoThreadPool) hasTask() bool {
 	defer threadPool.lock.RUnlock()
 	threadPool.lock.RLock()
-	return threadPool.queue.Len() > 0
+	return threadPool.size > 0
+}
+
+// forEachTask calls f with the queued batches
+func (threadPool *IoThreadPool) forEachTask(f func(*ProducerBatch)) {
+	defer threadPool.lock.RUnlock()
+	threadPool.lock.RLock()
+	for _, key := range threadPool.order {
+		for e := threadPool.queues[key].Front(); e != nil; e = e.Next() {
+			f(e.Value.(*ProducerBatch))
+		}
+	}
 }
 
 func (threadPool *IoThreadPool) start(ioWorkerWaitGroup *sync.WaitGroup, ioThreadPoolwait *sync.WaitGroup) {
@@ -1,7 +1,6 @@
 package producer
 
 import (
-	"math"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -24,11 +23,11 @@ type IoWorker struct {
 	retryQueueShutDownFlag *uberatomic.Bool
 	logger                 log.Logger
 	maxIoWorker            chan int64
-	noRetryStatusCodeMap   map[int]*string
+	retryPolicy            ProducerRetryPolicy
 	producer               *Producer
 }
 
-func initIoWorker(client sls.ClientInterface, retryQueue *RetryQueue, logger log.Logger, maxIoWorkerCount int64, errorStatusMap map[int]*string, producer *Producer) *IoWorker {
+func initIoWorker(client sls.ClientInterface, retryQueue *RetryQueue, logger log.Logger, maxIoWorkerCount int64, retryPolicy ProducerRetryPolicy, producer *Producer) *IoWorker {
 	return &IoWorker{
 		client:                 client,
 		retryQueue:             retryQueue,
@@ -36,7 +35,7 @@ func initIoWorker(client sls.ClientInterface, retryQueue *RetryQueue, logger log
 		retryQueueShutDownFlag: uberatomic.NewBool(false),
 		logger:                 logger,
 		maxIoWorker:            make(chan int64, maxIoWorkerCount),
-		noRetryStatusCodeMap:   errorStatusMap,
+		retryPolicy:            retryPolicy,
 		producer:               producer,
 	}
 }
@@ -64,55 +63,58 @@ func (ioWorker *IoWorker) sendToServer(producerBatch *ProducerBatch) {
 			producerBatch.result.attemptList = append(producerBatch.result.attemptList, attempt)
 		}
 		producerBatch.result.successful = true
+		costMs := GetTimeMs(time.Now().UnixNano()) - beginMs
+		ioWorker.producer.stats.recordSent(producerBatch, costMs)
+		ioWorker.producer.destinations.report(batchDestination(producerBatch), costMs, nil)
 		// After successful delivery, producer removes the batch size sent out
-		atomic.AddInt64(&ioWorker.producer.producerLogGroupSize, -producerBatch.totalDataSize)
+		ioWorker.producer.releaseBatch(producerBatch)
 		if len(producerBatch.callBackList) > 0 {
 			for _, callBack := range producerBatch.callBackList {
 				callBack.Success(producerBatch.result)
 			}
 		}
+		ioWorker.finishSpooled(producerBatch)
+		ioWorker.producer.completeBatch(producerBatch, false)
 	} else {
+		costMs := GetTimeMs(time.Now().UnixNano()) - beginMs
+		ioWorker.producer.stats.recordError(producerBatch, err, costMs)
+		ioWorker.producer.destinations.report(batchDestination(producerBatch), costMs, err)
 		if ioWorker.retryQueueShutDownFlag.Load() {
-			if len(producerBatch.callBackList) > 0 {
-				for _, callBack := range producerBatch.callBackList {
-					ioWorker.addErrorMessageToBatchAttempt(producerBatch, err, false, beginMs)
-					callBack.Fail(producerBatch.result)
-				}
+			// not retried when closing, like a batch that exhausts the retries
+			ioWorker.addErrorMessageToBatchAttempt(producerBatch, err, false, beginMs)
+			if !ioWorker.spoolBatch(producerBatch) {
+				ioWorker.excuteFailedCallback(producerBatch)
 			}
 			return
 		}
 		level.Info(ioWorker.logger).Log("msg", "sendToServer failed", "error", err)
-		if slsError, ok := err.(*sls.Error); ok {
-			if _, ok := ioWorker.noRetryStatusCodeMap[int(slsError.HTTPCode)]; ok {
-				ioWorker.addErrorMessageToBatchAttempt(producerBatch, err, false, beginMs)
-				ioWorker.excuteFailedCallback(producerBatch)
-				return
-			}
+		if !ioWorker.retryPolicy.IsRetryable(err) {
+			ioWorker.addErrorMessageToBatchAttempt(producerBatch, err, false, beginMs)
+			ioWorker.excuteFailedCallback(producerBatch)
+			return
 		}
-		if producerBatch.attemptCount < producerBatch.maxRetryTimes {
+		if backoff, ok := ioWorker.retryPolicy.Backoff(err, producerBatch.attemptCount+1); ok {
 			ioWorker.addErrorMessageToBatchAttempt(producerBatch, err, true, beginMs)
-			retryWaitTime := producerBatch.baseRetryBackoffMs * int64(math.Pow(2, float64(producerBatch.attemptCount)-1))
-			if retryWaitTime < producerBatch.maxRetryIntervalInMs {
-				producerBatch.nextRetryMs = GetTimeMs(time.Now().UnixNano()) + retryWaitTime
-			} else {
-				producerBatch.nextRetryMs = GetTimeMs(time.Now().UnixNano()) + producerBatch.maxRetryIntervalInMs
-			}
+			producerBatch.nextRetryMs = GetTimeMs(time.Now().UnixNano()) + backoff.Milliseconds()
 			level.Debug(ioWorker.logger).Log("msg", "Submit to the retry queue after meeting the retry criteria。")
 			ioWorker.retryQueue.sendToRetryQueue(producerBatch, ioWorker.logger)
 		} else {
-			ioWorker.excuteFailedCallback(producerBatch)
+			// the last attempt is in the result of the failed callback too
+			ioWorker.addErrorMessageToBatchAttempt(producerBatch, err, false, beginMs)
+			if !ioWorker.spoolBatch(producerBatch) {
+				ioWorker.excuteFailedCallback(producerBatch)
+			}
 		}
 	}
 }
 
 func (ioWorker *IoWorker) addErrorMessageToBatchAttempt(producerBatch *ProducerBatch, err error, retryInfo bool, beginMs int64) {
 	if producerBatch.attemptCount < producerBatch.maxReservedAttempts {
-		slsError := err.(*sls.Error)
+		nowMs := GetTimeMs(time.Now().UnixNano())
+		attempt := newErrorAttempt(err, nowMs, nowMs-beginMs)
 		if retryInfo {
-			level.Info(ioWorker.logger).Log("msg", "sendToServer failed,start retrying", "retry times", producerBatch.attemptCount, "requestId", slsError.RequestID, "error code", slsError.Code, "error message", slsError.Message)
+			level.Info(ioWorker.logger).Log("msg", "sendToServer failed,start retrying", "retry times", producerBatch.attemptCount, "requestId", attempt.RequestId, "error code", attempt.ErrorCode, "error message", attempt.ErrorMessage)
 		}
-		nowMs := GetTimeMs(time.Now().UnixNano())
-		attempt := createAttempt(false, slsError.RequestID, slsError.Code, slsError.Message, nowMs, nowMs-beginMs)
 		producerBatch.result.attemptList = append(producerBatch.result.attemptList, attempt)
 	}
 	producerBatch.result.successful = false
@@ -133,10 +135,47 @@ func (ioWorker *IoWorker) startSendTask(ioWorkerWaitGroup *sync.WaitGroup) {
 
 func (ioWorker *IoWorker) excuteFailedCallback(producerBatch *ProducerBatch) {
 	level.Info(ioWorker.logger).Log("msg", "sendToServer failed,Execute failed callback function")
-	atomic.AddInt64(&ioWorker.producer.producerLogGroupSize, -producerBatch.totalDataSize)
+	ioWorker.producer.stats.recordFailed(producerBatch)
+	ioWorker.producer.writeDeadLetter(producerBatch)
+	ioWorker.producer.releaseBatch(producerBatch)
 	if len(producerBatch.callBackList) > 0 {
 		for _, callBack := range producerBatch.callBackList {
 			callBack.Fail(producerBatch.result)
 		}
 	}
+	ioWorker.finishSpooled(producerBatch)
+	ioWorker.producer.completeBatch(producerBatch, false)
+}
+
+// spoolBatch writes a batch that cannot be sent to the spool, it returns false
+// if the spool is not enabled or fails.
+func (ioWorker *IoWorker) spoolBatch(producerBatch *ProducerBatch) bool {
+	spool := ioWorker.producer.spool
+	if spool == nil {
+		return false
+	}
+	if producerBatch.spoolReplays >= ioWorker.producer.producerConfig.SpoolMaxReplays {
+		level.Warn(ioWorker.logger).Log("msg", "the batch exceeds SpoolMaxReplays and is not spooled again", "replays", producerBatch.spoolReplays)
+		return false
+	}
+	if err := spool.write(producerBatch); err != nil {
+		level.Warn(ioWorker.logger).Log("msg", "Failed to spool the batch", "error", err)
+		if err == errSpoolFull {
+			nowMs := GetTimeMs(time.Now().UnixNano())
+			producerBatch.result.attemptList = append(producerBatch.result.attemptList, createAttempt(false, "", SpoolFullError, err.Error(), nowMs, 0))
+		}
+		return false
+	}
+	level.Info(ioWorker.logger).Log("msg", "sendToServer failed,the batch is spooled")
+	ioWorker.producer.releaseBatch(producerBatch)
+	ioWorker.finishSpooled(producerBatch)
+	ioWorker.producer.completeBatch(producerBatch, true)
+	return true
+}
+
+// finishSpooled removes a replayed segment after all of its batches are finished
+func (ioWorker *IoWorker) finishSpooled(producerBatch *ProducerBatch) {
+	if producerBatch.spoolSegment != nil {
+		ioWorker.producer.spool.finish(producerBatch)
+	}
 }
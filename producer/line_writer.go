@@ -0,0 +1,194 @@
+package producer
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+const defaultLineWriterFlushInterval = time.Second
+
+// LineWriterConfig configures a LineWriter.
+type LineWriterConfig struct {
+	Project  string
+	Logstore string
+	Topic    string
+	Source   string
+	// Parser optionally parses every record with the rules of a file config,
+	// including the time extraction and the LogBeginRegex of a regex config.
+	// Records that fail to parse are sent as __raw_log__ unless Parser.DiscardUnmatch is set,
+	// records dropped by the FilterKeys of the config are not sent.
+	Parser *sls.LogParser
+	// LogBeginRegex groups lines into multiline records when Parser is nil, a line
+	// matching the regex begins a new record, eg. `\d+-\d+-\d+ .*` for stack traces
+	LogBeginRegex string
+	// ContentKey is the key of a record when Parser is nil, default "content"
+	ContentKey string
+	// FlushInterval is how long the last record waits for more lines before it
+	// is sent, default 1s
+	FlushInterval time.Duration
+	// CallBack optionally receives the results of the batches of the logs
+	CallBack CallBack
+}
+
+// LineWriter is an io.WriteCloser that splits the written stream into lines,
+// groups them into records and sends every record as a log, eg. to capture the
+// output of a subprocess. It is safe for concurrent use.
+type LineWriter struct {
+	producer   *Producer
+	config     LineWriterConfig
+	beginRegex *regexp.Regexp
+
+	lock    sync.Mutex
+	partial []byte   // an incomplete line
+	record  []string // the lines of the current record
+	timer   *time.Timer
+	closed  bool
+}
+
+// NewLineWriter creates a LineWriter that sends through producer.
+func NewLineWriter(producer *Producer, config *LineWriterConfig) (*LineWriter, error) {
+	w := &LineWriter{producer: producer, config: *config}
+	if w.config.ContentKey == "" {
+		w.config.ContentKey = "content"
+	}
+	if w.config.FlushInterval <= 0 {
+		w.config.FlushInterval = defaultLineWriterFlushInterval
+	}
+	if w.config.Parser == nil && w.config.LogBeginRegex != "" && w.config.LogBeginRegex != ".*" {
+		regex, err := regexp.Compile("^(?:" + w.config.LogBeginRegex + ")$")
+		if err != nil {
+			return nil, err
+		}
+		w.beginRegex = regex
+	}
+	w.timer = time.AfterFunc(time.Hour, w.flushIdle)
+	w.timer.Stop()
+	return w, nil
+}
+
+// Write splits p into lines, a record is sent when the next record begins.
+// The error is the first error of sending the records completed by p.
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return 0, errors.New("write to closed LineWriter")
+	}
+	w.timer.Stop()
+	var err error
+	data := p
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		var line string
+		if len(w.partial) > 0 {
+			line = string(append(w.partial, data[:i]...))
+			w.partial = w.partial[:0]
+		} else {
+			line = string(data[:i])
+		}
+		if e := w.addLine(strings.TrimSuffix(line, "\r")); e != nil && err == nil {
+			err = e
+		}
+		data = data[i+1:]
+	}
+	w.partial = append(w.partial, data...)
+	if len(w.record) > 0 || len(w.partial) > 0 {
+		w.timer.Reset(w.config.FlushInterval)
+	}
+	return len(p), err
+}
+
+// Close sends the incomplete line and the current record, it does not close the producer.
+func (w *LineWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.timer.Stop()
+	return w.flush()
+}
+
+func (w *LineWriter) addLine(line string) error {
+	if w.isBeginLine(line) || len(w.record) == 0 {
+		err := w.sendRecord()
+		w.record = append(w.record, line)
+		return err
+	}
+	w.record = append(w.record, line)
+	return nil
+}
+
+func (w *LineWriter) isBeginLine(line string) bool {
+	if w.config.Parser != nil {
+		return w.config.Parser.IsBeginLine(line)
+	}
+	return w.beginRegex == nil || w.beginRegex.MatchString(line)
+}
+
+// flushIdle sends the pending data when no line is written for FlushInterval
+func (w *LineWriter) flushIdle() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.closed {
+		w.flush()
+	}
+}
+
+func (w *LineWriter) flush() error {
+	var err error
+	if len(w.partial) > 0 {
+		line := strings.TrimSuffix(string(w.partial), "\r")
+		w.partial = w.partial[:0]
+		err = w.addLine(line)
+	}
+	if e := w.sendRecord(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+func (w *LineWriter) sendRecord() error {
+	if len(w.record) == 0 {
+		return nil
+	}
+	record := strings.Join(w.record, "\n")
+	w.record = w.record[:0]
+
+	var log *sls.Log
+	if w.config.Parser != nil {
+		var err error
+		if log, err = w.config.Parser.Parse(record); err != nil {
+			if w.config.Parser.DiscardUnmatch {
+				return nil
+			}
+			log = contentLog(sls.RawLogKey, record)
+		} else if log == nil {
+			// dropped by the filters of the config
+			return nil
+		}
+	} else {
+		log = contentLog(w.config.ContentKey, record)
+	}
+	return w.producer.SendLogWithCallBack(w.config.Project, w.config.Logstore, w.config.Topic, w.config.Source, log, w.config.CallBack)
+}
+
+func contentLog(key, value string) *sls.Log {
+	now := time.Now()
+	return &sls.Log{
+		Time:     proto.Uint32(uint32(now.Unix())),
+		TimeNs:   proto.Uint32(uint32(now.Nanosecond())),
+		Contents: []*sls.LogContent{{Key: proto.String(key), Value: proto.String(value)}},
+	}
+}
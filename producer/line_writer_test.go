@@ -0,0 +1,101 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+// pendingLogs returns the logs in the batches of a producer that is not started
+func pendingLogs(producer *Producer) []*sls.Log {
+	var logs []*sls.Log
+	for _, batch := range producer.logAccumulator.logGroupData {
+		logs = append(logs, batch.logGroup.Logs...)
+	}
+	return logs
+}
+
+func contentsOf(log *sls.Log) map[string]string {
+	contents := map[string]string{}
+	for _, c := range log.Contents {
+		contents[c.GetKey()] = c.GetValue()
+	}
+	return contents
+}
+
+func newTestProducer() *Producer {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	return InitProducer(config)
+}
+
+func TestLineWriter(t *testing.T) {
+	producer := newTestProducer()
+	w, err := NewLineWriter(producer, &LineWriterConfig{Project: "p", Logstore: "l", LogBeginRegex: `\d{4}-.*`})
+	if err != nil {
+		t.Fatalf("NewLineWriter() error = %v", err)
+	}
+	for _, chunk := range []string{"2024-01-01 panic: boom\r\n\tat ", "main.go:1\n", "2024-01-02 ok\nnext"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if logs := pendingLogs(producer); len(logs) != 1 || contentsOf(logs[0])["content"] != "2024-01-01 panic: boom\n\tat main.go:1" {
+		t.Errorf("Write() logs = %v", logs)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if logs := pendingLogs(producer); len(logs) != 2 || contentsOf(logs[1])["content"] != "2024-01-02 ok\nnext" {
+		t.Errorf("Close() logs = %v", logs)
+	}
+	if _, err := w.Write([]byte("x\n")); err == nil {
+		t.Error("Write() after Close expect error")
+	}
+}
+
+func TestLineWriterParser(t *testing.T) {
+	detail := &sls.RegexConfigInputDetail{}
+	sls.InitRegexConfigInputDetail(detail)
+	detail.Regex = `(\S+ \S+) (\w+) (.*)`
+	detail.Key = []string{"time", "level", "message"}
+	detail.LogBeginRegex = `\d+-.*`
+	detail.TimeFormat = "%Y-%m-%d %H:%M:%S"
+	detail.DiscardUnmatch = false
+	parser, err := sls.NewLogParser(detail)
+	if err != nil {
+		t.Fatalf("NewLogParser() error = %v", err)
+	}
+	parser.Location = time.UTC
+
+	producer := newTestProducer()
+	w, _ := NewLineWriter(producer, &LineWriterConfig{Project: "p", Logstore: "l", Parser: parser})
+	w.Write([]byte("2024-01-02 03:04:05 ERROR failed\n  stack\n2024-99-99 00:00:00 INFO bad time\n"))
+	w.Close()
+
+	logs := pendingLogs(producer)
+	if len(logs) != 2 {
+		t.Fatalf("LineWriter sent %d logs, want 2", len(logs))
+	}
+	if got := contentsOf(logs[0]); got["level"] != "ERROR" || got["message"] != "failed\n  stack" || logs[0].GetTime() != 1704164645 {
+		t.Errorf("LineWriter log = %v", logs[0])
+	}
+	if got := contentsOf(logs[1]); got[sls.RawLogKey] != "2024-99-99 00:00:00 INFO bad time" {
+		t.Errorf("LineWriter raw log = %v", logs[1])
+	}
+}
+
+func TestLineWriterFlushInterval(t *testing.T) {
+	producer := newTestProducer()
+	w, _ := NewLineWriter(producer, &LineWriterConfig{Project: "p", Logstore: "l", FlushInterval: 10 * time.Millisecond})
+	defer w.Close()
+	w.Write([]byte("a\nb"))
+	time.Sleep(100 * time.Millisecond)
+	producer.logAccumulator.lock.Lock()
+	logs := pendingLogs(producer)
+	producer.logAccumulator.lock.Unlock()
+	if len(logs) != 2 {
+		t.Errorf("LineWriter idle flush sent %d logs, want 2", len(logs))
+	}
+}
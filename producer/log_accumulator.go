@@ -5,6 +5,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	sls "github.com/aliyun/aliyun-log-go-sdk"
 	"github.com/go-kit/kit/log"
@@ -13,8 +14,10 @@ import (
 )
 
 type LogAccumulator struct {
-	lock           sync.RWMutex
-	logGroupData   map[string]*ProducerBatch
+	lock         sync.RWMutex
+	logGroupData map[string]*ProducerBatch
+	// the batches of the logs overflowing TotalSizeLnBytes, see spoolBatches
+	spoolData      map[string]*ProducerBatch
 	producerConfig *ProducerConfig
 	ioWorker       *IoWorker
 	shutDownFlag   *uberatomic.Bool
@@ -26,6 +29,7 @@ type LogAccumulator struct {
 func initLogAccumulator(config *ProducerConfig, ioWorker *IoWorker, logger log.Logger, threadPool *IoThreadPool, producer *Producer) *LogAccumulator {
 	return &LogAccumulator{
 		logGroupData:   make(map[string]*ProducerBatch),
+		spoolData:      make(map[string]*ProducerBatch),
 		producerConfig: config,
 		ioWorker:       ioWorker,
 		shutDownFlag:   uberatomic.NewBool(false),
@@ -35,7 +39,7 @@ func initLogAccumulator(config *ProducerConfig, ioWorker *IoWorker, logger log.L
 	}
 }
 
-func (logAccumulator *LogAccumulator) addOrSendProducerBatch(key, project, logstore, logTopic, logSource, shardHash string, producerBatch *ProducerBatch, log interface{}, callback CallBack) {
+func (logAccumulator *LogAccumulator) addOrSendProducerBatch(key, project, logstore, logTopic, logSource, shardHash string, logTags []*sls.LogTag, producerBatch *ProducerBatch, log interface{}, callback CallBack) {
 	totalDataCount := producerBatch.getLogGroupCount() + 1
 	if int64(producerBatch.totalDataSize) > logAccumulator.producerConfig.MaxBatchSize && producerBatch.totalDataSize < 5242880 && totalDataCount <= logAccumulator.producerConfig.MaxBatchCount {
 		producerBatch.addLogToLogGroup(log)
@@ -50,39 +54,58 @@ func (logAccumulator *LogAccumulator) addOrSendProducerBatch(key, project, logst
 		}
 	} else {
 		logAccumulator.innerSendToServer(key, producerBatch)
-		logAccumulator.createNewProducerBatch(log, callback, key, project, logstore, logTopic, logSource, shardHash)
+		logAccumulator.createNewProducerBatch(log, callback, key, project, logstore, logTopic, logSource, shardHash, logTags)
 	}
 }
 
 // In this function，Naming with mlog is to avoid conflicts with the introduced kit/log package names.
-func (logAccumulator *LogAccumulator) addLogToProducerBatch(project, logstore, shardHash, logTopic, logSource string,
-	logData interface{}, callback CallBack) error {
+// addLogToProducerBatch adds the logs to the batch of the log group key, the
+// logTags are sent in addition to ProducerConfig.LogTags and may be nil. With
+// noWait it fails with a *BlockedError instead of exceeding the room.
+func (logAccumulator *LogAccumulator) addLogToProducerBatch(project, logstore, shardHash, logTopic, logSource string, logTags []*sls.LogTag,
+	logData interface{}, callback CallBack, noWait bool) error {
 	if logAccumulator.shutDownFlag.Load() {
 		level.Warn(logAccumulator.logger).Log("msg", "Producer has started and shut down and cannot write to new logs")
 		return errors.New("Producer has started and shut down and cannot write to new logs")
 	}
-
-	key := logAccumulator.getKeyString(project, logstore, logTopic, shardHash, logSource)
-	defer logAccumulator.lock.Unlock()
+	key := logAccumulator.getKeyString(project, logstore, logTopic, shardHash, logSource, logTags)
+	producer := logAccumulator.producer
 	logAccumulator.lock.Lock()
+	// the sizes are added under the lock, so the room is checked and taken at once
+	if noWait && (producer.destinations.isFull(destinationKey{project, logstore}) || producer.spool == nil && producer.isFull()) {
+		logAccumulator.lock.Unlock()
+		return &BlockedError{Err: errNoRoom}
+	}
+	if producer.spool != nil && producer.isFullFor(project, logstore) {
+		full, err := logAccumulator.addLogToSpoolBatch(key, project, logstore, shardHash, logTopic, logSource, logTags, logData, callback)
+		logAccumulator.lock.Unlock()
+		if full != nil {
+			logAccumulator.writeSpoolBatch(full)
+		}
+		if noWait && err == errSpoolFull {
+			return &BlockedError{Err: err}
+		}
+		return err
+	}
+	defer logAccumulator.lock.Unlock()
 	if mlog, ok := logData.(*sls.Log); ok {
 		if producerBatch, ok := logAccumulator.logGroupData[key]; ok == true {
 			logSize := int64(GetLogSizeCalculate(mlog))
 			atomic.AddInt64(&producerBatch.totalDataSize, logSize)
-			atomic.AddInt64(&logAccumulator.producer.producerLogGroupSize, logSize)
-			logAccumulator.addOrSendProducerBatch(key, project, logstore, logTopic, logSource, shardHash, producerBatch, mlog, callback)
+			logAccumulator.producer.addSize(project, logstore, logSize)
+			logAccumulator.addOrSendProducerBatch(key, project, logstore, logTopic, logSource, shardHash, logTags, producerBatch, mlog, callback)
 		} else {
-			logAccumulator.createNewProducerBatch(mlog, callback, key, project, logstore, logTopic, logSource, shardHash)
+			logAccumulator.createNewProducerBatch(mlog, callback, key, project, logstore, logTopic, logSource, shardHash, logTags)
 		}
 	} else if logList, ok := logData.([]*sls.Log); ok {
 		if producerBatch, ok := logAccumulator.logGroupData[key]; ok == true {
 			logListSize := int64(GetLogListSize(logList))
 			atomic.AddInt64(&producerBatch.totalDataSize, logListSize)
-			atomic.AddInt64(&logAccumulator.producer.producerLogGroupSize, logListSize)
-			logAccumulator.addOrSendProducerBatch(key, project, logstore, logTopic, logSource, shardHash, producerBatch, logList, callback)
+			logAccumulator.producer.addSize(project, logstore, logListSize)
+			logAccumulator.addOrSendProducerBatch(key, project, logstore, logTopic, logSource, shardHash, logTags, producerBatch, logList, callback)
 
 		} else {
-			logAccumulator.createNewProducerBatch(logList, callback, key, project, logstore, logTopic, logSource, shardHash)
+			logAccumulator.createNewProducerBatch(logList, callback, key, project, logstore, logTopic, logSource, shardHash, logTags)
 		}
 	} else {
 		level.Error(logAccumulator.logger).Log("msg", "Invalid logType")
@@ -92,14 +115,86 @@ func (logAccumulator *LogAccumulator) addLogToProducerBatch(project, logstore, s
 
 }
 
-func (logAccumulator *LogAccumulator) createNewProducerBatch(logType interface{}, callback CallBack, key, project, logstore, logTopic, logSource, shardHash string) {
+// addLogToSpoolBatch batches the logs overflowing TotalSizeLnBytes, they are
+// written to the spool after LingerMs like the logs sent to the server. It
+// returns the batch of the key that is full, the caller spools it after
+// releasing logAccumulator.lock.
+func (logAccumulator *LogAccumulator) addLogToSpoolBatch(key, project, logstore, shardHash, logTopic, logSource string, logTags []*sls.LogTag,
+	logData interface{}, callback CallBack) (*ProducerBatch, error) {
+	if logAccumulator.producer.spool.isFull() {
+		return nil, errSpoolFull
+	}
+	var size int64
+	var count int
+	if mlog, ok := logData.(*sls.Log); ok {
+		size, count = int64(GetLogSizeCalculate(mlog)), 1
+	} else if logList, ok := logData.([]*sls.Log); ok {
+		size, count = int64(GetLogListSize(logList)), len(logList)
+	} else {
+		level.Error(logAccumulator.logger).Log("msg", "Invalid logType")
+		return nil, errors.New("Invalid logType")
+	}
+	config := logAccumulator.producerConfig
+	producerBatch, ok := logAccumulator.spoolData[key]
+	if ok && producerBatch.totalDataSize+size <= config.MaxBatchSize && producerBatch.getLogGroupCount()+count <= config.MaxBatchCount {
+		atomic.AddInt64(&producerBatch.totalDataSize, size)
+		producerBatch.addLogToLogGroup(logData)
+		if callback != nil {
+			producerBatch.addProducerBatchCallBack(callback)
+		}
+		return nil, nil
+	}
+	newProducerBatch := initProducerBatch(logData, callback, project, logstore, logTopic, logSource, shardHash, logTags, config)
+	logAccumulator.producer.registerBatch(newProducerBatch)
+	logAccumulator.spoolData[key] = newProducerBatch
+	return producerBatch, nil
+}
+
+// spoolBatches writes the spool batches older than LingerMs, or all of them, to the spool
+func (logAccumulator *LogAccumulator) spoolBatches(all bool) {
+	nowMs := GetTimeMs(time.Now().UnixNano())
+	var producerBatches []*ProducerBatch
+	logAccumulator.lock.Lock()
+	for key, producerBatch := range logAccumulator.spoolData {
+		if all || nowMs-producerBatch.createTimeMs >= logAccumulator.producerConfig.LingerMs {
+			producerBatches = append(producerBatches, producerBatch)
+			delete(logAccumulator.spoolData, key)
+		}
+	}
+	logAccumulator.lock.Unlock()
+	for _, producerBatch := range producerBatches {
+		logAccumulator.writeSpoolBatch(producerBatch)
+	}
+}
+
+// writeSpoolBatch spools a batch of the logs overflowing TotalSizeLnBytes, it
+// fails if the spool cannot keep it
+func (logAccumulator *LogAccumulator) writeSpoolBatch(producerBatch *ProducerBatch) {
+	spool := logAccumulator.producer.spool
+	if err := spool.write(producerBatch); err != nil {
+		level.Warn(logAccumulator.logger).Log("msg", "Failed to spool the batch", "error", err)
+		code := SpoolFullError
+		if err != errSpoolFull {
+			code = sls.NewClientError(err).Code
+		}
+		spool.fail(producerBatch, code, err.Error())
+		return
+	}
+	logAccumulator.producer.completeBatch(producerBatch, true)
+}
+
+func (logAccumulator *LogAccumulator) createNewProducerBatch(logType interface{}, callback CallBack, key, project, logstore, logTopic, logSource, shardHash string, logTags []*sls.LogTag) {
 	level.Debug(logAccumulator.logger).Log("msg", "Create a new ProducerBatch")
 
 	if mlog, ok := logType.(*sls.Log); ok {
-		newProducerBatch := initProducerBatch(mlog, callback, project, logstore, logTopic, logSource, shardHash, logAccumulator.producerConfig)
+		newProducerBatch := initProducerBatch(mlog, callback, project, logstore, logTopic, logSource, shardHash, logTags, logAccumulator.producerConfig)
+		logAccumulator.producer.addSize(project, logstore, newProducerBatch.totalDataSize)
+		logAccumulator.producer.registerBatch(newProducerBatch)
 		logAccumulator.logGroupData[key] = newProducerBatch
 	} else if logList, ok := logType.([]*sls.Log); ok {
-		newProducerBatch := initProducerBatch(logList, callback, project, logstore, logTopic, logSource, shardHash, logAccumulator.producerConfig)
+		newProducerBatch := initProducerBatch(logList, callback, project, logstore, logTopic, logSource, shardHash, logTags, logAccumulator.producerConfig)
+		logAccumulator.producer.addSize(project, logstore, newProducerBatch.totalDataSize)
+		logAccumulator.producer.registerBatch(newProducerBatch)
 		logAccumulator.logGroupData[key] = newProducerBatch
 	}
 }
@@ -110,7 +205,7 @@ func (logAccumulator *LogAccumulator) innerSendToServer(key string, producerBatc
 	delete(logAccumulator.logGroupData, key)
 }
 
-func (logAccumulator *LogAccumulator) getKeyString(project, logstore, logTopic, shardHash, logSource string) string {
+func (logAccumulator *LogAccumulator) getKeyString(project, logstore, logTopic, shardHash, logSource string, logTags []*sls.LogTag) string {
 	var key strings.Builder
 	key.WriteString(project)
 	key.WriteString(Delimiter)
@@ -121,5 +216,12 @@ func (logAccumulator *LogAccumulator) getKeyString(project, logstore, logTopic,
 	key.WriteString(shardHash)
 	key.WriteString(Delimiter)
 	key.WriteString(logSource)
+	// the logs of other tags are in other log groups
+	for _, tag := range logTags {
+		key.WriteString(Delimiter)
+		key.WriteString(tag.GetKey())
+		key.WriteString("=")
+		key.WriteString(tag.GetValue())
+	}
 	return key.String()
 }
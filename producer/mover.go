@@ -61,6 +61,9 @@ func (mover *Mover) run(moverWaitGroup *sync.WaitGroup, config *ProducerConfig)
 			}
 		}
 		mover.logAccumulator.lock.Unlock()
+		if mover.logAccumulator.producer.spool != nil {
+			mover.logAccumulator.spoolBatches(false)
+		}
 
 		if mapCount == 0 {
 			level.Debug(mover.logger).Log("msg", "No data time in map waiting for user configured RemainMs parameter values")
@@ -85,6 +88,9 @@ func (mover *Mover) run(moverWaitGroup *sync.WaitGroup, config *ProducerConfig)
 	}
 	mover.logAccumulator.logGroupData = make(map[string]*ProducerBatch)
 	mover.logAccumulator.lock.Unlock()
+	if mover.logAccumulator.producer.spool != nil {
+		mover.logAccumulator.spoolBatches(true)
+	}
 
 	producerBatchList := mover.retryQueue.getRetryBatch(mover.moverShutDownFlag.Load())
 	count := len(producerBatchList)
@@ -0,0 +1,139 @@
+package producer
+
+import (
+	"sort"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+// Processor processes a log sent to a project and logstore before it is
+// batched, see ProducerConfig.Processors. It returns the logs sent in place of
+// the log: the log itself, which may be modified, none to drop it, or several
+// to split it.
+type Processor func(project, logstore string, log *sls.Log) []*sls.Log
+
+// NewAddFieldsProcessor adds the fields to the contents of the logs, eg. the
+// host and the pod, in the order of the keys.
+func NewAddFieldsProcessor(fields map[string]string) Processor {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return func(project, logstore string, log *sls.Log) []*sls.Log {
+		// the contents of the caller are not appended to
+		contents := make([]*sls.LogContent, 0, len(log.Contents)+len(keys))
+		contents = append(contents, log.Contents...)
+		for _, key := range keys {
+			contents = append(contents, &sls.LogContent{Key: proto.String(key), Value: proto.String(fields[key])})
+		}
+		log.Contents = contents
+		return []*sls.Log{log}
+	}
+}
+
+// NewAllowKeysProcessor keeps only the contents of the keys.
+func NewAllowKeysProcessor(keys ...string) Processor {
+	return newKeysProcessor(keys, true)
+}
+
+// NewDenyKeysProcessor removes the contents of the keys.
+func NewDenyKeysProcessor(keys ...string) Processor {
+	return newKeysProcessor(keys, false)
+}
+
+func newKeysProcessor(keys []string, allow bool) Processor {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+	return func(project, logstore string, log *sls.Log) []*sls.Log {
+		contents := make([]*sls.LogContent, 0, len(log.Contents))
+		for _, c := range log.Contents {
+			if _, ok := set[c.GetKey()]; ok == allow {
+				contents = append(contents, c)
+			}
+		}
+		log.Contents = contents
+		return []*sls.Log{log}
+	}
+}
+
+// NewMaskProcessor masks the sensitive contents like the SensitiveKeys of a
+// logtail config, see sls.NewSensitiveMask.
+func NewMaskProcessor(keys ...sls.SensitiveKey) (Processor, error) {
+	masks := make([]*sls.SensitiveMask, 0, len(keys))
+	for _, k := range keys {
+		mask, err := sls.NewSensitiveMask(k)
+		if err != nil {
+			return nil, err
+		}
+		masks = append(masks, mask)
+	}
+	return func(project, logstore string, log *sls.Log) []*sls.Log {
+		// the contents may be shared, eg. by the logs of a SlogHandler.WithAttrs,
+		// so the masked contents are new ones in a copy of the list
+		copied := false
+		for _, m := range masks {
+			for i, c := range log.Contents {
+				if c.GetKey() != m.Key {
+					continue
+				}
+				value, ok := m.Mask(c.GetValue())
+				if !ok {
+					continue
+				}
+				if !copied {
+					log.Contents = append([]*sls.LogContent{}, log.Contents...)
+					copied = true
+				}
+				log.Contents[i] = &sls.LogContent{Key: c.Key, Value: proto.String(value)}
+			}
+		}
+		return []*sls.Log{log}
+	}, nil
+}
+
+// addLogs runs the Processors on the logs and adds them to the accumulator.
+// The callback succeeds at once if all the logs are dropped.
+func (producer *Producer) addLogs(project, logstore, shardHash, topic, source string, logTags []*sls.LogTag, logData interface{}, callback CallBack, noWait bool) error {
+	if processors := producer.producerConfig.Processors; len(processors) > 0 {
+		var logs []*sls.Log
+		switch l := logData.(type) {
+		case *sls.Log:
+			logs = processLog(processors, project, logstore, l)
+		case []*sls.Log:
+			for _, log := range l {
+				logs = append(logs, processLog(processors, project, logstore, log)...)
+			}
+		default:
+			return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, logTags, logData, callback, noWait)
+		}
+		if len(logs) == 0 {
+			if callback != nil {
+				result := initResult()
+				result.successful = true
+				callback.Success(result)
+			}
+			return nil
+		}
+		logData = logs
+	}
+	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, logTags, logData, callback, noWait)
+}
+
+func processLog(processors []Processor, project, logstore string, log *sls.Log) []*sls.Log {
+	logs := []*sls.Log{log}
+	for _, processor := range processors {
+		var next []*sls.Log
+		for _, log := range logs {
+			next = append(next, processor(project, logstore, log)...)
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		logs = next
+	}
+	return logs
+}
@@ -0,0 +1,139 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+func newProcessorTestLog(kv ...string) *sls.Log {
+	log := &sls.Log{Time: proto.Uint32(1)}
+	for i := 0; i < len(kv); i += 2 {
+		log.Contents = append(log.Contents, &sls.LogContent{Key: proto.String(kv[i]), Value: proto.String(kv[i+1])})
+	}
+	return log
+}
+
+func processorContents(logs []*sls.Log) []string {
+	var contents []string
+	for _, log := range logs {
+		var kv []string
+		for _, c := range log.Contents {
+			kv = append(kv, c.GetKey()+"="+c.GetValue())
+		}
+		contents = append(contents, strings.Join(kv, ","))
+	}
+	return contents
+}
+
+func TestProcessors(t *testing.T) {
+	mask, err := NewMaskProcessor(
+		sls.SensitiveKey{Key: "phone", Type: "const", RegexBegin: "^\\d{3}", RegexContent: "\\d{4}", ConstString: "****"},
+		sls.SensitiveKey{Key: "id", Type: "md5", RegexBegin: "id:", RegexContent: "\\d+", All: true},
+	)
+	if err != nil {
+		t.Fatalf("NewMaskProcessor() error = %v", err)
+	}
+	tests := []struct {
+		name      string
+		processor Processor
+		log       *sls.Log
+		want      []string
+	}{
+		{"add fields", NewAddFieldsProcessor(map[string]string{"pod": "p1", "host": "h1"}), newProcessorTestLog("a", "1"), []string{"a=1,host=h1,pod=p1"}},
+		{"allow keys", NewAllowKeysProcessor("a", "c"), newProcessorTestLog("a", "1", "b", "2", "c", "3"), []string{"a=1,c=3"}},
+		{"deny keys", NewDenyKeysProcessor("b"), newProcessorTestLog("a", "1", "b", "2", "b", "3"), []string{"a=1"}},
+		{"mask", mask, newProcessorTestLog("phone", "13812345678", "id", "id:1 id:2", "other", "13812345678"),
+			[]string{"phone=138****5678,id=id:c4ca4238a0b923820dcc509a6f75849b id:c81e728d9d4c2f636f067f89cc14862c,other=13812345678"}},
+	}
+	for _, tt := range tests {
+		if got := processorContents(tt.processor("p", "l", tt.log)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. Processor() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// the contents of the caller are not appended to
+	shared := make([]*sls.LogContent, 1, 2)
+	shared[0] = &sls.LogContent{Key: proto.String("a"), Value: proto.String("1")}
+	NewAddFieldsProcessor(map[string]string{"b": "2"})("p", "l", &sls.Log{Contents: shared})
+	if extended := shared[:2]; extended[1] != nil {
+		t.Errorf("Processor() appended %v to the contents of the caller", extended[1])
+	}
+
+	// the contents of the log are not changed
+	content := &sls.LogContent{Key: proto.String("phone"), Value: proto.String("13812345678")}
+	masked := mask("p", "l", &sls.Log{Contents: []*sls.LogContent{content}})
+	if content.GetValue() != "13812345678" || masked[0].Contents[0].GetValue() != "138****5678" {
+		t.Errorf("Processor() masked %v, the content is %v", masked[0].Contents[0].GetValue(), content.GetValue())
+	}
+
+	if _, err := NewMaskProcessor(sls.SensitiveKey{Key: "k", Type: "hash", RegexContent: ".*"}); err == nil {
+		t.Errorf("NewMaskProcessor() of an invalid type succeeds")
+	}
+	if _, err := NewMaskProcessor(sls.SensitiveKey{Key: "k", Type: "const", RegexContent: "("}); err == nil {
+		t.Errorf("NewMaskProcessor() of an invalid regex succeeds")
+	}
+}
+
+func TestProducerProcessors(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.LingerMs = 3000
+	config.Processors = []Processor{
+		// drop the debug logs
+		func(project, logstore string, log *sls.Log) []*sls.Log {
+			for _, c := range log.Contents {
+				if c.GetKey() == "level" && c.GetValue() == "debug" {
+					return nil
+				}
+			}
+			return []*sls.Log{log}
+		},
+		// split the lines
+		func(project, logstore string, log *sls.Log) []*sls.Log {
+			var logs []*sls.Log
+			for _, line := range strings.Split(log.Contents[0].GetValue(), "\n") {
+				logs = append(logs, newProcessorTestLog("content", line))
+			}
+			return logs
+		},
+		func(project, logstore string, log *sls.Log) []*sls.Log {
+			log.Contents = append(log.Contents, &sls.LogContent{Key: proto.String("__destination__"), Value: proto.String(project + "/" + logstore)})
+			return []*sls.Log{log}
+		},
+	}
+	producer := InitProducer(config)
+	client := &flakyClient{healthy: true}
+	producer.threadPool.ioworker.client = client
+	producer.Start()
+	defer producer.SafeClose()
+
+	future, err := producer.SendLogFuture("p", "l", "", "", newProcessorTestLog("content", "a", "level", "debug"))
+	if err != nil {
+		t.Fatalf("SendLogFuture() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// the dropped log succeeds at once
+	if result, err := future.Wait(ctx); err != nil || !result.IsSuccessful() {
+		t.Errorf("Wait() of a dropped log = %v, %v", result, err)
+	}
+	producer.SendLogList("p", "l", "", "", []*sls.Log{newProcessorTestLog("content", "b\nc"), newProcessorTestLog("content", "d")})
+	if result, err := producer.Flush(context.Background()); err != nil || result.Logs != 3 {
+		t.Fatalf("Flush() = %+v, %v, want 3 logs", result, err)
+	}
+	got := processorContents(client.sent[0].Logs)
+	var want []string
+	for _, value := range []string{"b", "c", "d"} {
+		want = append(want, fmt.Sprintf("content=%s,__destination__=p/l", value))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package producer
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"sync/atomic"
@@ -27,6 +28,30 @@ type Producer struct {
 	buckets               int
 	logger                log.Logger
 	producerLogGroupSize  int64
+	spool                 *spool
+	// spaceCh is closed when producerLogGroupSize decreases, see waitContext
+	spaceLock    sync.Mutex
+	spaceCh      chan struct{}
+	stats        *producerStats
+	destinations *destinations
+	shardRouter  *shardRouter
+	// the batches in memory, see Flush
+	batchLock   sync.Mutex
+	liveBatches map[*ProducerBatch]struct{}
+}
+
+// BlockedError is returned by the Send Context methods when the context is done
+// before the producer has room for the logs.
+type BlockedError struct {
+	Err error
+}
+
+func (e *BlockedError) Error() string {
+	return TimeoutExecption + ": " + e.Err.Error()
+}
+
+func (e *BlockedError) Unwrap() error {
+	return e.Err
 }
 
 func InitProducer(producerConfig *ProducerConfig) *Producer {
@@ -50,18 +75,18 @@ func InitProducer(producerConfig *ProducerConfig) *Producer {
 	}
 	finalProducerConfig := validateProducerConfig(producerConfig)
 	retryQueue := initRetryQueue()
-	errorStatusMap := func() map[int]*string {
-		errorCodeMap := map[int]*string{}
-		for _, v := range producerConfig.NoRetryStatusCodeList {
-			errorCodeMap[int(v)] = nil
-		}
-		return errorCodeMap
-	}()
+	retryPolicy := finalProducerConfig.RetryPolicy
+	if retryPolicy == nil {
+		retryPolicy = NewJitterRetryPolicy(finalProducerConfig)
+	}
 	producer := &Producer{
 		producerConfig: finalProducerConfig,
 		buckets:        finalProducerConfig.Buckets,
+		stats:          newProducerStats(),
+		destinations:   newDestinations(finalProducerConfig),
+		liveBatches:    map[*ProducerBatch]struct{}{},
 	}
-	ioWorker := initIoWorker(client, retryQueue, logger, finalProducerConfig.MaxIoWorkerCount, errorStatusMap, producer)
+	ioWorker := initIoWorker(client, retryQueue, logger, finalProducerConfig.MaxIoWorkerCount, retryPolicy, producer)
 	threadPool := initIoThreadPool(ioWorker, logger)
 	logAccumulator := initLogAccumulator(finalProducerConfig, ioWorker, logger, threadPool, producer)
 	mover := initMover(logAccumulator, retryQueue, ioWorker, logger, threadPool)
@@ -73,6 +98,14 @@ func InitProducer(producerConfig *ProducerConfig) *Producer {
 	producer.ioWorkerWaitGroup = &sync.WaitGroup{}
 	producer.ioThreadPoolWaitGroup = &sync.WaitGroup{}
 	producer.logger = logger
+	if finalProducerConfig.ShardAwareHash {
+		producer.shardRouter = newShardRouter(client, finalProducerConfig, logger)
+	}
+	if finalProducerConfig.SpoolDir != "" {
+		if producer.spool, err = openSpool(finalProducerConfig, producer, logger); err != nil {
+			level.Error(logger).Log("msg", "Failed to open the spool, the producer runs without it", "dir", finalProducerConfig.SpoolDir, "error", err)
+		}
+	}
 	return producer
 }
 
@@ -120,130 +153,276 @@ func validateProducerConfig(producerConfig *ProducerConfig) *ProducerConfig {
 		level.Warn(logger).Log("msg", "The LingerMs parameter cannot be less than 100 milliseconds and has been reset to the default value of 2000 milliseconds")
 		producerConfig.LingerMs = 2000
 	}
+	if producerConfig.ShardAwareHash && producerConfig.ShardRefreshIntervalSec <= 0 {
+		producerConfig.ShardRefreshIntervalSec = defaultShardRefreshIntervalSec
+	}
+	if producerConfig.CircuitBreakerFailures > 0 && producerConfig.CircuitBreakerOpenMs <= 0 {
+		producerConfig.CircuitBreakerOpenMs = defaultCircuitBreakerOpenMs
+	}
+	if producerConfig.AdaptiveConcurrency {
+		if producerConfig.AdaptiveMinConcurrency <= 0 {
+			producerConfig.AdaptiveMinConcurrency = 1
+		}
+		if producerConfig.AdaptiveMaxConcurrency <= 0 || producerConfig.AdaptiveMaxConcurrency > int(producerConfig.MaxIoWorkerCount) {
+			producerConfig.AdaptiveMaxConcurrency = int(producerConfig.MaxIoWorkerCount)
+		}
+		if producerConfig.AdaptiveMinConcurrency > producerConfig.AdaptiveMaxConcurrency {
+			producerConfig.AdaptiveMinConcurrency = producerConfig.AdaptiveMaxConcurrency
+		}
+	}
+	if producerConfig.SpoolDir != "" && producerConfig.SpoolMaxBytes <= 0 {
+		producerConfig.SpoolMaxBytes = defaultSpoolMaxBytes
+	}
+	if producerConfig.SpoolDir != "" && producerConfig.SpoolMaxReplays <= 0 {
+		producerConfig.SpoolMaxReplays = defaultSpoolMaxReplays
+	}
 	return producerConfig
 }
 
 func (producer *Producer) HashSendLogWithCallBack(project, logstore, shardHash, topic, source string, log *sls.Log, callback CallBack) error {
-	err := producer.waitTime()
+	err := producer.waitTime(project, logstore)
 	if err != nil {
 		return err
 	}
-	if producer.producerConfig.AdjustShargHash {
-		shardHash, err = AdjustHash(shardHash, producer.buckets)
-		if err != nil {
-			return err
-		}
+	shardHash, err = producer.adjustHash(project, logstore, shardHash)
+	if err != nil {
+		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, log, callback)
+	return producer.addLogs(project, logstore, shardHash, topic, source, nil, log, callback, false)
 }
 
 func (producer *Producer) HashSendLogListWithCallBack(project, logstore, shardHash, topic, source string, logList []*sls.Log, callback CallBack) (err error) {
 
-	err = producer.waitTime()
+	err = producer.waitTime(project, logstore)
+	if err != nil {
+		return err
+	}
+	shardHash, err = producer.adjustHash(project, logstore, shardHash)
 	if err != nil {
 		return err
 	}
+	return producer.addLogs(project, logstore, shardHash, topic, source, nil, logList, callback, false)
+}
+
+// adjustHash maps the hash key to the shard owning it with ShardAwareHash, or to
+// one of the Buckets with AdjustShargHash, so the logs of a shard are sent together.
+func (producer *Producer) adjustHash(project, logstore, shardHash string) (string, error) {
+	if producer.shardRouter != nil {
+		return producer.shardRouter.route(project, logstore, shardHash), nil
+	}
 	if producer.producerConfig.AdjustShargHash {
-		shardHash, err = AdjustHash(shardHash, producer.buckets)
-		if err != nil {
-			return err
-		}
+		return AdjustHash(shardHash, producer.buckets)
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, logList, callback)
+	return shardHash, nil
 }
 
 func (producer *Producer) SendLog(project, logstore, topic, source string, log *sls.Log) error {
-	err := producer.waitTime()
+	err := producer.waitTime(project, logstore)
 	if err != nil {
 		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, "", topic, source, log, nil)
+	return producer.addLogs(project, logstore, "", topic, source, nil, log, nil, false)
 }
 
 func (producer *Producer) SendLogList(project, logstore, topic, source string, logList []*sls.Log) (err error) {
-	err = producer.waitTime()
+	err = producer.waitTime(project, logstore)
 	if err != nil {
 		return err
 	}
 
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, "", topic, source, logList, nil)
+	return producer.addLogs(project, logstore, "", topic, source, nil, logList, nil, false)
 
 }
 
 func (producer *Producer) HashSendLog(project, logstore, shardHash, topic, source string, log *sls.Log) error {
-	err := producer.waitTime()
+	err := producer.waitTime(project, logstore)
 	if err != nil {
 		return err
 	}
-	if producer.producerConfig.AdjustShargHash {
-		shardHash, err = AdjustHash(shardHash, producer.buckets)
-		if err != nil {
-			return err
-		}
+	shardHash, err = producer.adjustHash(project, logstore, shardHash)
+	if err != nil {
+		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, log, nil)
+	return producer.addLogs(project, logstore, shardHash, topic, source, nil, log, nil, false)
 }
 
 func (producer *Producer) HashSendLogList(project, logstore, shardHash, topic, source string, logList []*sls.Log) (err error) {
-	err = producer.waitTime()
+	err = producer.waitTime(project, logstore)
 	if err != nil {
 		return err
 	}
-	if producer.producerConfig.AdjustShargHash {
-		shardHash, err = AdjustHash(shardHash, producer.buckets)
-		if err != nil {
-			return err
-		}
+	shardHash, err = producer.adjustHash(project, logstore, shardHash)
+	if err != nil {
+		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, shardHash, topic, source, logList, nil)
+	return producer.addLogs(project, logstore, shardHash, topic, source, nil, logList, nil, false)
 
 }
 
 func (producer *Producer) SendLogWithCallBack(project, logstore, topic, source string, log *sls.Log, callback CallBack) error {
-	err := producer.waitTime()
+	err := producer.waitTime(project, logstore)
 	if err != nil {
 		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, "", topic, source, log, callback)
+	return producer.addLogs(project, logstore, "", topic, source, nil, log, callback, false)
 }
 
 func (producer *Producer) SendLogListWithCallBack(project, logstore, topic, source string, logList []*sls.Log, callback CallBack) (err error) {
-	err = producer.waitTime()
+	err = producer.waitTime(project, logstore)
 	if err != nil {
 		return err
 	}
-	return producer.logAccumulator.addLogToProducerBatch(project, logstore, "", topic, source, logList, callback)
+	return producer.addLogs(project, logstore, "", topic, source, nil, logList, callback, false)
 
 }
 
-func (producer *Producer) waitTime() error {
+// SendLogContext is SendLogWithCallBack that blocks until ctx is done when the
+// producer is full, the callback may be nil. It returns a *BlockedError if ctx
+// is done before the producer has room for the log, MaxBlockSec is not used.
+func (producer *Producer) SendLogContext(ctx context.Context, project, logstore, topic, source string, log *sls.Log, callback CallBack) error {
+	if err := producer.waitContext(ctx, project, logstore); err != nil {
+		return err
+	}
+	return producer.addLogs(project, logstore, "", topic, source, nil, log, callback, false)
+}
 
-	if producer.producerConfig.MaxBlockSec > 0 {
-		for i := 0; i < producer.producerConfig.MaxBlockSec; i++ {
+// SendLogListContext is SendLogListWithCallBack that blocks until ctx is done, see SendLogContext.
+func (producer *Producer) SendLogListContext(ctx context.Context, project, logstore, topic, source string, logList []*sls.Log, callback CallBack) error {
+	if err := producer.waitContext(ctx, project, logstore); err != nil {
+		return err
+	}
+	return producer.addLogs(project, logstore, "", topic, source, nil, logList, callback, false)
+}
 
-			if atomic.LoadInt64(&producer.producerLogGroupSize) > producer.producerConfig.TotalSizeLnBytes {
-				time.Sleep(time.Second)
-			} else {
-				return nil
-			}
+// HashSendLogContext is HashSendLogWithCallBack that blocks until ctx is done, see SendLogContext.
+func (producer *Producer) HashSendLogContext(ctx context.Context, project, logstore, shardHash, topic, source string, log *sls.Log, callback CallBack) error {
+	if err := producer.waitContext(ctx, project, logstore); err != nil {
+		return err
+	}
+	shardHash, err := producer.adjustHash(project, logstore, shardHash)
+	if err != nil {
+		return err
+	}
+	return producer.addLogs(project, logstore, shardHash, topic, source, nil, log, callback, false)
+}
+
+// HashSendLogListContext is HashSendLogListWithCallBack that blocks until ctx is done, see SendLogContext.
+func (producer *Producer) HashSendLogListContext(ctx context.Context, project, logstore, shardHash, topic, source string, logList []*sls.Log, callback CallBack) error {
+	if err := producer.waitContext(ctx, project, logstore); err != nil {
+		return err
+	}
+	shardHash, err := producer.adjustHash(project, logstore, shardHash)
+	if err != nil {
+		return err
+	}
+	return producer.addLogs(project, logstore, shardHash, topic, source, nil, logList, callback, false)
+}
+
+// SendLogListWithTags sends the logs in log groups with the tags in addition to
+// ProducerConfig.LogTags, the logs of other tags are batched apart. The shardHash
+// may be empty and the callback nil. It blocks until ctx is done when the
+// producer is full, see SendLogContext.
+func (producer *Producer) SendLogListWithTags(ctx context.Context, project, logstore, shardHash, topic, source string, logTags []*sls.LogTag, logList []*sls.Log, callback CallBack) error {
+	if err := producer.waitContext(ctx, project, logstore); err != nil {
+		return err
+	}
+	if shardHash != "" {
+		var err error
+		if shardHash, err = producer.adjustHash(project, logstore, shardHash); err != nil {
+			return err
 		}
+	}
+	return producer.addLogs(project, logstore, shardHash, topic, source, logTags, logList, callback, false)
+}
+
+// errNoRoom is the error of the *BlockedError of a send that does not wait
+var errNoRoom = errors.New("no room for the logs")
+
+// sendLogListNoWait is SendLogListWithTags that returns a *BlockedError at once
+// when the producer or the destination is full. The room is checked under the
+// lock of the accumulator where the size of the logs is added, so concurrent
+// sends do not exceed it together.
+func (producer *Producer) sendLogListNoWait(project, logstore, topic, source string, logTags []*sls.LogTag, logList []*sls.Log) error {
+	return producer.addLogs(project, logstore, "", topic, source, logTags, logList, nil, true)
+}
+
+// waitTime blocks for up to MaxBlockSec when the producer or the destination is full, forever if it is negative
+func (producer *Producer) waitTime(project, logstore string) error {
+	ctx := context.Background()
+	if producer.producerConfig.MaxBlockSec >= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(producer.producerConfig.MaxBlockSec)*time.Second)
+		defer cancel()
+	}
+	if err := producer.waitContext(ctx, project, logstore); err != nil {
 		level.Error(producer.logger).Log("msg", "Over producer set maximum blocking time")
 		return errors.New(TimeoutExecption)
-	} else if producer.producerConfig.MaxBlockSec == 0 {
-		if atomic.LoadInt64(&producer.producerLogGroupSize) > producer.producerConfig.TotalSizeLnBytes {
-			level.Error(producer.logger).Log("msg", "Over producer set maximum blocking time")
-			return errors.New(TimeoutExecption)
+	}
+	return nil
+}
+
+// waitContext blocks until the producer and the destination have room for more
+// logs, it is woken up by releaseBatch instead of polling.
+func (producer *Producer) waitContext(ctx context.Context, project, logstore string) error {
+	for {
+		// get the channel before the check, a release after the check closes it
+		spaceCh := producer.spaceChan()
+		if producer.logAccumulator.shutDownFlag.Load() {
+			return nil
 		}
-	} else if producer.producerConfig.MaxBlockSec < 0 {
-		for {
-			if atomic.LoadInt64(&producer.producerLogGroupSize) > producer.producerConfig.TotalSizeLnBytes {
-				time.Sleep(time.Second)
-			} else {
-				return nil
-			}
+		// the logs overflowing TotalSizeLnBytes are spooled, see addLogToProducerBatch,
+		// but MaxDestinationBytes holds for the spool too
+		if !producer.destinations.isFull(destinationKey{project, logstore}) &&
+			(!producer.isFull() || producer.spool != nil && !producer.spool.isFull()) {
+			return nil
+		}
+		select {
+		case <-spaceCh:
+		case <-ctx.Done():
+			return &BlockedError{Err: ctx.Err()}
 		}
 	}
-	return nil
+}
+
+func (producer *Producer) spaceChan() <-chan struct{} {
+	producer.spaceLock.Lock()
+	defer producer.spaceLock.Unlock()
+	if producer.spaceCh == nil {
+		producer.spaceCh = make(chan struct{})
+	}
+	return producer.spaceCh
+}
+
+// addSize adds the size of the logs of a destination in memory
+func (producer *Producer) addSize(project, logstore string, size int64) {
+	atomic.AddInt64(&producer.producerLogGroupSize, size)
+	producer.destinations.addSize(destinationKey{project, logstore}, size)
+}
+
+// releaseBatch removes the size of a batch that is finished and wakes up the waiting senders
+func (producer *Producer) releaseBatch(producerBatch *ProducerBatch) {
+	atomic.AddInt64(&producer.producerLogGroupSize, -producerBatch.totalDataSize)
+	producer.destinations.addSize(batchDestination(producerBatch), -producerBatch.totalDataSize)
+	producer.notifySpace()
+}
+
+func (producer *Producer) notifySpace() {
+	producer.spaceLock.Lock()
+	defer producer.spaceLock.Unlock()
+	if producer.spaceCh != nil {
+		close(producer.spaceCh)
+		producer.spaceCh = nil
+	}
+}
+
+// isFull reports whether the logs in memory exceed TotalSizeLnBytes, when waitTime blocks or fails.
+func (producer *Producer) isFull() bool {
+	return atomic.LoadInt64(&producer.producerLogGroupSize) > producer.producerConfig.TotalSizeLnBytes
+}
+
+// isFullFor is isFull or the logs of the destination exceed MaxDestinationBytes
+func (producer *Producer) isFullFor(project, logstore string) bool {
+	return producer.isFull() || producer.destinations.isFull(destinationKey{project, logstore})
 }
 
 func (producer *Producer) Start() {
@@ -252,6 +431,10 @@ func (producer *Producer) Start() {
 	go producer.mover.run(producer.moverWaitGroup, producer.producerConfig)
 	producer.ioThreadPoolWaitGroup.Add(1)
 	go producer.threadPool.start(producer.ioWorkerWaitGroup, producer.ioThreadPoolWaitGroup)
+	if producer.spool != nil {
+		producer.spool.wg.Add(1)
+		go producer.spool.run()
+	}
 }
 
 // Limited closing transfer parameter nil, safe closing transfer timeout time, timeout Ms parameter in milliseconds
@@ -263,9 +446,22 @@ func (producer *Producer) Close(timeoutMs int64) error {
 	for {
 		if atomic.LoadInt64(&producer.mover.ioWorker.taskCount) == 0 && !producer.threadPool.hasTask() {
 			level.Info(producer.logger).Log("msg", "All groutines of producer have been shutdown")
+			producer.closeSpool()
 			return nil
 		}
 		if time.Since(startCloseTime) > time.Duration(timeoutMs)*time.Millisecond {
+			if producer.spool != nil {
+				// the batches waiting to be sent are spooled, the ones being sent are not
+				for batch := producer.threadPool.popTask(); batch != nil; batch = producer.threadPool.popTask() {
+					producer.destinations.release(batchDestination(batch))
+					if !producer.mover.ioWorker.spoolBatch(batch) {
+						producer.mover.ioWorker.excuteFailedCallback(batch)
+					}
+				}
+				producer.closeSpool()
+				level.Warn(producer.logger).Log("msg", "The producer timeout closes, and the cached data is spooled")
+				return errors.New(TimeoutExecption)
+			}
 			level.Warn(producer.logger).Log("msg", "The producer timeout closes, and some of the cached data may not be sent properly")
 			return errors.New(TimeoutExecption)
 		}
@@ -280,15 +476,26 @@ func (producer *Producer) SafeClose() {
 	producer.threadPool.threadPoolShutDownFlag.Store(true)
 	producer.ioThreadPoolWaitGroup.Wait()
 	producer.ioWorkerWaitGroup.Wait()
+	producer.closeSpool()
 	level.Info(producer.logger).Log("msg", "Producer close finish")
 }
 
+func (producer *Producer) closeSpool() {
+	if producer.spool != nil {
+		producer.spool.close()
+	}
+}
+
 func (producer *Producer) sendCloseProdcerSignal() {
 	level.Info(producer.logger).Log("msg", "producer start closing")
 	producer.closeStstokenChannel()
 	producer.mover.moverShutDownFlag.Store(true)
 	producer.logAccumulator.shutDownFlag.Store(true)
 	producer.mover.ioWorker.retryQueueShutDownFlag.Store(true)
+	producer.notifySpace()
+	if producer.spool != nil {
+		producer.spool.stopReplay()
+	}
 }
 
 func (producer *Producer) closeStstokenChannel() {
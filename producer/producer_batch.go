@@ -29,6 +29,12 @@ type ProducerBatch struct {
 	result               *Result
 	maxReservedAttempts  int
 	useMetricStoreUrl    bool
+	// the segment a batch is replayed from
+	spoolSegment *spoolSegment
+	// how many times the batch is replayed from the spool, see SpoolMaxReplays
+	spoolReplays int
+	// the Flush calls waiting for the batch, guarded by Producer.batchLock
+	flushWaiters []*flushWaiter
 }
 
 func generatePackId(source string) string {
@@ -36,7 +42,7 @@ func generatePackId(source string) string {
 	return ToMd5(srcData)[0:16]
 }
 
-func initProducerBatch(logData interface{}, callBackFunc CallBack, project, logstore, logTopic, logSource, shardHash string, config *ProducerConfig) *ProducerBatch {
+func initProducerBatch(logData interface{}, callBackFunc CallBack, project, logstore, logTopic, logSource, shardHash string, logTags []*sls.LogTag, config *ProducerConfig) *ProducerBatch {
 	logs := []*sls.Log{}
 
 	if log, ok := logData.(*sls.Log); ok {
@@ -45,9 +51,12 @@ func initProducerBatch(logData interface{}, callBackFunc CallBack, project, logs
 		logs = append(logs, logList...)
 	}
 
+	// a new list, the tags of the config are shared by the batches
+	tags := make([]*sls.LogTag, 0, len(config.LogTags)+len(logTags)+1)
+	tags = append(append(tags, config.LogTags...), logTags...)
 	logGroup := &sls.LogGroup{
 		Logs:    logs,
-		LogTags: config.LogTags,
+		LogTags: tags,
 		Topic:   proto.String(logTopic),
 		Source:  proto.String(logSource),
 	}
@@ -40,6 +40,66 @@ type ProducerConfig struct {
 	CredentialsProvider   sls.CredentialsProvider
 	UseMetricStoreURL     bool
 
+	// Processors run in order on each log before it is batched, to enrich, mask,
+	// drop or split the logs. They may modify the logs, and are called by the
+	// goroutines sending the logs.
+	Processors []Processor
+
+	// RetryPolicy decides whether and when the failed batches are retried, the
+	// default is a JitterRetryPolicy of Retries, BaseRetryBackoffMs,
+	// MaxRetryBackoffMs and NoRetryStatusCodeList
+	RetryPolicy ProducerRetryPolicy
+
+	// DeadLetterSink optionally keeps the batches that fail after the retries or
+	// with a status in NoRetryStatusCodeList, eg. a FileDeadLetterSink
+	DeadLetterSink DeadLetterSink
+
+	// ShardAwareHash maps the hash keys of the HashSend methods to the readwrite
+	// shards listed with ListShards, so a batch holds the logs of one shard and its
+	// hash key is the begin key of the shard. The shards are listed in the
+	// background, the md5 of the hash key is used until they are listed. It takes
+	// precedence over AdjustShargHash.
+	ShardAwareHash bool
+	// ShardRefreshIntervalSec is how often the shards are listed again to follow
+	// splits and merges, default 60
+	ShardRefreshIntervalSec int
+
+	// MaxDestinationBytes is the memory quota of a project and logstore, the
+	// sends to a destination over its quota block like TotalSizeLnBytes, 0 disables it
+	MaxDestinationBytes int64
+	// CircuitBreakerFailures suspends a project and logstore after the consecutive
+	// failed requests, its batches are held for CircuitBreakerOpenMs and then
+	// one batch is sent to probe it, 0 disables it
+	CircuitBreakerFailures int
+	// CircuitBreakerOpenMs is how long a destination is suspended, default 30s
+	CircuitBreakerOpenMs int64
+	// AdaptiveConcurrency limits the concurrent requests of a project and logstore
+	// between AdaptiveMinConcurrency and AdaptiveMaxConcurrency, the limit decreases
+	// on ShardWriteQuotaExceed errors and slow requests and increases otherwise.
+	// MaxIoWorkerCount is still the limit of all the destinations.
+	AdaptiveConcurrency bool
+	// AdaptiveMinConcurrency is the initial and min limit, default 1
+	AdaptiveMinConcurrency int
+	// AdaptiveMaxConcurrency is the max limit, default MaxIoWorkerCount
+	AdaptiveMaxConcurrency int
+
+	// SpoolDir enables the disk spool. The batches that fail after the retries, that
+	// are not sent when the producer closes, or that overflow TotalSizeLnBytes are
+	// written to segment files in the dir and replayed in order, also after a restart.
+	// The delivery is at least once, a segment being replayed is replayed again after a crash.
+	SpoolDir string
+	// SpoolMaxBytes is the max disk usage of the spool, default 1G
+	SpoolMaxBytes int64
+	SpoolSync     SpoolSyncPolicy
+	// SpoolRetentionSec drops the segments not replayed in time, 0 keeps them forever
+	SpoolRetentionSec int64
+	// SpoolMaxReplays is how many times a batch is replayed from the spool before
+	// it fails and goes to the DeadLetterSink, default 10
+	SpoolMaxReplays int
+	// SpoolReplayCallBack receives the results of the batches spooled before a
+	// restart, whose callbacks are lost
+	SpoolReplayCallBack CallBack
+
 	packLock   sync.Mutex
 	packPrefix string
 	packNumber int64
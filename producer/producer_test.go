@@ -1,12 +1,15 @@
 package producer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
 	"time"
 
 	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
 )
 
 func TestVsSign(t *testing.T) {
@@ -30,3 +33,74 @@ func TestVsSign(t *testing.T) {
 	producerInstance.Close(60)   // 有限关闭，传递int值，参数值需为正整数，单位为秒
 	producerInstance.SafeClose() // 安全关闭
 }
+
+func TestSendLogContext(t *testing.T) {
+	producer := newTestProducer()
+	log := GenerateLog(uint32(time.Now().Unix()), map[string]string{"content": "test"})
+	if err := producer.SendLogContext(context.Background(), "p", "l", "", "", log, nil); err != nil {
+		t.Fatalf("SendLogContext() error = %v", err)
+	}
+	if producer.producerLogGroupSize <= 0 {
+		t.Errorf("producerLogGroupSize = %d, want the size of the log", producer.producerLogGroupSize)
+	}
+
+	producer.producerLogGroupSize = producer.producerConfig.TotalSizeLnBytes + 1
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := producer.SendLogContext(ctx, "p", "l", "", "", log, nil)
+	var blocked *BlockedError
+	if !errors.As(err, &blocked) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SendLogContext() error = %v, want a BlockedError of the deadline", err)
+	}
+
+	// a released batch wakes up the sender without polling
+	done := make(chan error, 1)
+	go func() {
+		done <- producer.SendLogListContext(context.Background(), "p", "l", "", "", []*sls.Log{log}, nil)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	producer.releaseBatch(&ProducerBatch{project: "x", logstore: "x", totalDataSize: producer.producerLogGroupSize})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("SendLogListContext() error = %v", err)
+		}
+		if time.Since(start) > 500*time.Millisecond {
+			t.Errorf("SendLogListContext() woke up after %v", time.Since(start))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SendLogListContext() is not woken up by releaseBatch")
+	}
+}
+
+func TestSendLogListWithTags(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.LogTags = []*sls.LogTag{{Key: proto.String("env"), Value: proto.String("prod")}}
+	producer := InitProducer(config)
+	log := GenerateLog(uint32(time.Now().Unix()), map[string]string{"content": "test"})
+	tag := func(value string) []*sls.LogTag {
+		return []*sls.LogTag{{Key: proto.String("host"), Value: proto.String(value)}}
+	}
+	for _, host := range []string{"a", "b", "a"} {
+		if err := producer.SendLogListWithTags(context.Background(), "p", "l", "", "", "", tag(host), []*sls.Log{log}, nil); err != nil {
+			t.Fatalf("SendLogListWithTags() error = %v", err)
+		}
+	}
+	// the logs of other tags are batched apart
+	got := map[string]int{}
+	for _, batch := range producer.logAccumulator.logGroupData {
+		tags := batch.logGroup.LogTags
+		if len(tags) != 2 || tags[0].GetKey() != "env" {
+			t.Fatalf("LogTags = %v, want env and host", tags)
+		}
+		got[tags[1].GetValue()] = len(batch.logGroup.Logs)
+	}
+	if len(got) != 2 || got["a"] != 2 || got["b"] != 1 {
+		t.Errorf("batches of the hosts = %v, want a: 2, b: 1", got)
+	}
+	if len(config.LogTags) != 1 {
+		t.Errorf("ProducerConfig.LogTags = %v", config.LogTags)
+	}
+}
@@ -1,5 +1,11 @@
 package producer
 
+import (
+	"errors"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
 type Attempt struct {
 	Success      bool
 	RequestId    string
@@ -23,15 +29,31 @@ func createAttempt(success bool,
 	}
 }
 
+// newErrorAttempt creates the attempt of a failed request, the errors that are
+// not *sls.Error are reported as ClientError
+func newErrorAttempt(err error, timeStampMs, lastAttemptCostMs int64) *Attempt {
+	var slsError *sls.Error
+	if !errors.As(err, &slsError) {
+		slsError = sls.NewClientError(err)
+	}
+	return createAttempt(false, slsError.RequestID, slsError.Code, slsError.Message, timeStampMs, lastAttemptCostMs)
+}
+
 type Result struct {
 	attemptList []*Attempt
 	successful  bool
+	spooled     bool
 }
 
 func (result *Result) IsSuccessful() bool {
 	return result.successful
 }
 
+// IsSpooled reports whether the batch was written to the disk spool, see ProducerConfig.SpoolDir.
+func (result *Result) IsSpooled() bool {
+	return result.spooled
+}
+
 func (result *Result) GetReservedAttempts() []*Attempt {
 	return result.attemptList
 }
@@ -0,0 +1,83 @@
+package producer
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+// ProducerRetryPolicy decides whether and when a batch failing to be sent is
+// retried, see ProducerConfig.RetryPolicy. It is called by many goroutines.
+type ProducerRetryPolicy interface {
+	// IsRetryable reports whether err may be transient. A batch failing with an
+	// error that is not retryable fails at once, and is not spooled.
+	IsRetryable(err error) bool
+	// Backoff returns the delay before retrying a batch after its attempt-th
+	// failure, counting from 1, or false when the retries are exhausted.
+	Backoff(err error, attempt int) (time.Duration, bool)
+}
+
+// JitterRetryPolicy is the default ProducerRetryPolicy. The errors with a
+// status in NoRetryStatusCodeList are not retried, and the delay of the n-th
+// retry is random in [0, min(MaxBackoffMs, BaseBackoffMs * 2^(n-1))], the full
+// jitter keeps the producers from retrying together after an outage.
+type JitterRetryPolicy struct {
+	Retries               int
+	BaseBackoffMs         int64
+	MaxBackoffMs          int64
+	NoRetryStatusCodeList []int
+}
+
+// NewJitterRetryPolicy creates a JitterRetryPolicy of the retry settings of the config.
+func NewJitterRetryPolicy(config *ProducerConfig) *JitterRetryPolicy {
+	return &JitterRetryPolicy{
+		Retries:               config.Retries,
+		BaseBackoffMs:         config.BaseRetryBackoffMs,
+		MaxBackoffMs:          config.MaxRetryBackoffMs,
+		NoRetryStatusCodeList: config.NoRetryStatusCodeList,
+	}
+}
+
+func (p *JitterRetryPolicy) IsRetryable(err error) bool {
+	status, ok := errorStatusCode(err)
+	if !ok {
+		// network errors and the other client errors
+		return true
+	}
+	for _, code := range p.NoRetryStatusCodeList {
+		if code == status {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *JitterRetryPolicy) Backoff(err error, attempt int) (time.Duration, bool) {
+	if attempt > p.Retries {
+		return 0, false
+	}
+	backoffMs := p.MaxBackoffMs
+	// the shift is bounded to not overflow
+	if attempt <= 32 && p.BaseBackoffMs<<(attempt-1) < p.MaxBackoffMs {
+		backoffMs = p.BaseBackoffMs << (attempt - 1)
+	}
+	if backoffMs <= 0 {
+		return 0, true
+	}
+	return time.Duration(rand.Int63n(backoffMs+1)) * time.Millisecond, true
+}
+
+// errorStatusCode returns the HTTP status of an error of the server
+func errorStatusCode(err error) (int, bool) {
+	var slsError *sls.Error
+	if errors.As(err, &slsError) && slsError.HTTPCode > 0 {
+		return int(slsError.HTTPCode), true
+	}
+	var badResponse *sls.BadResponseError
+	if errors.As(err, &badResponse) {
+		return badResponse.HTTPCode, true
+	}
+	return 0, false
+}
@@ -0,0 +1,120 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+func TestJitterRetryPolicyIsRetryable(t *testing.T) {
+	policy := NewJitterRetryPolicy(GetDefaultProducerConfig())
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad gateway", &sls.Error{HTTPCode: 502, Code: "BadGateway"}, true},
+		{"quota", &sls.Error{HTTPCode: 403, Code: sls.WRITE_QUOTA_EXCEED}, true},
+		{"bad request", &sls.Error{HTTPCode: 400, Code: "InvalidParameter"}, false},
+		{"wrapped not found", fmt.Errorf("send: %w", &sls.Error{HTTPCode: 404}), false},
+		{"bad response", sls.NewBadResponseError("", nil, 400), false},
+		{"client error", sls.NewClientError(errors.New("timeout")), true},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+	}
+	for _, tt := range tests {
+		if got := policy.IsRetryable(tt.err); got != tt.want {
+			t.Errorf("%q. IsRetryable() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJitterRetryPolicyBackoff(t *testing.T) {
+	policy := &JitterRetryPolicy{Retries: 100, BaseBackoffMs: 100, MaxBackoffMs: 1000}
+	tests := []struct {
+		attempt int
+		maxMs   int64
+	}{
+		{1, 100},
+		{2, 200},
+		{4, 800},
+		{5, 1000},
+		{100, 1000},
+	}
+	for _, tt := range tests {
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 100; i++ {
+			backoff, ok := policy.Backoff(nil, tt.attempt)
+			if !ok || backoff < 0 || backoff > time.Duration(tt.maxMs)*time.Millisecond {
+				t.Fatalf("%d. Backoff() = %v, %v, want in [0, %dms]", tt.attempt, backoff, ok, tt.maxMs)
+			}
+			seen[backoff] = true
+		}
+		// the delays are random
+		if len(seen) < 10 {
+			t.Errorf("%d. Backoff() returns %d distinct delays of 100", tt.attempt, len(seen))
+		}
+	}
+	if _, ok := policy.Backoff(nil, 101); ok {
+		t.Errorf("Backoff() retries after the retries are exhausted")
+	}
+}
+
+// networkClient fails PostLogStoreLogsV2 with an error that is not *sls.Error
+type networkClient struct {
+	sls.ClientInterface
+	calls int32
+}
+
+func (c *networkClient) PostLogStoreLogsV2(project, logstore string, req *sls.PostLogStoreLogsRequest) error {
+	atomic.AddInt32(&c.calls, 1)
+	return &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+}
+
+type countingRetryPolicy struct {
+	attempts []int
+}
+
+func (p *countingRetryPolicy) IsRetryable(err error) bool {
+	return true
+}
+
+func (p *countingRetryPolicy) Backoff(err error, attempt int) (time.Duration, bool) {
+	p.attempts = append(p.attempts, attempt)
+	return time.Millisecond, attempt <= 2
+}
+
+func TestRetryPolicy(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.LingerMs = 3000
+	policy := &countingRetryPolicy{}
+	config.RetryPolicy = policy
+	producer := InitProducer(config)
+	client := &networkClient{}
+	producer.threadPool.ioworker.client = client
+	producer.Start()
+	defer producer.SafeClose()
+
+	log := GenerateLog(uint32(time.Now().Unix()), map[string]string{"content": "test"})
+	producer.SendLog("p", "l", "", "", log)
+	result, err := producer.Flush(context.Background())
+	if err != nil || result.FailedBatches != 1 {
+		t.Fatalf("Flush() = %+v, %v, want a failed batch", result, err)
+	}
+	if calls := atomic.LoadInt32(&client.calls); calls != 3 {
+		t.Errorf("sent %d times, want 3", calls)
+	}
+	if fmt.Sprint(policy.attempts) != "[1 2 3]" {
+		t.Errorf("Backoff() attempts = %v, want [1 2 3]", policy.attempts)
+	}
+	failed := result.Failures[0].Result
+	if len(failed.GetReservedAttempts()) != 3 || failed.GetErrorCode() != "ClientError" || failed.GetErrorMessage() == "" {
+		t.Errorf("Result = %d attempts, %q %q, want 3 attempts of ClientError", len(failed.GetReservedAttempts()), failed.GetErrorCode(), failed.GetErrorMessage())
+	}
+}
@@ -0,0 +1,108 @@
+package producer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const defaultShardRefreshIntervalSec = 60
+
+// shardRouter maps the hash keys to the readwrite shards of the logstores, see
+// ProducerConfig.ShardAwareHash. The shards are listed in the background on the
+// first use and refreshed after ShardRefreshIntervalSec, the send path never
+// waits for them.
+type shardRouter struct {
+	client   sls.ClientInterface
+	interval time.Duration
+	logger   log.Logger
+
+	lock      sync.Mutex
+	logstores map[destinationKey]*shardState
+}
+
+// shardState is the last topology listed of a logstore, nil until it is listed
+type shardState struct {
+	topology   *sls.ShardTopology
+	updateTime time.Time
+	refreshing bool
+}
+
+func newShardRouter(client sls.ClientInterface, config *ProducerConfig, logger log.Logger) *shardRouter {
+	return &shardRouter{
+		client:    client,
+		interval:  time.Duration(config.ShardRefreshIntervalSec) * time.Second,
+		logger:    logger,
+		logstores: map[destinationKey]*shardState{},
+	}
+}
+
+// route returns the begin key of the shard owning the md5 of the hash key,
+// or the md5 itself when the shards are not listed yet, which the server
+// routes to the same shard.
+func (r *shardRouter) route(project, logstore, shardHash string) string {
+	key := ToMd5(shardHash)
+	topology := r.topology(destinationKey{project, logstore})
+	if topology == nil {
+		return key
+	}
+	shard, err := topology.ShardForHashKey(key)
+	if err != nil {
+		return key
+	}
+	return shard.InclusiveBeginKey
+}
+
+// topology returns the shards of a logstore, it starts listing them on the
+// first use, and refreshes them when they are older than the interval.
+func (r *shardRouter) topology(logstore destinationKey) *sls.ShardTopology {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	state, ok := r.logstores[logstore]
+	if !ok {
+		state = &shardState{refreshing: true}
+		r.logstores[logstore] = state
+		go r.refresh(logstore, state)
+	} else if !state.refreshing && time.Since(state.updateTime) > r.interval {
+		state.refreshing = true
+		go r.refresh(logstore, state)
+	}
+	return state.topology
+}
+
+func (r *shardRouter) refresh(logstore destinationKey, state *shardState) {
+	topology, err := sls.LoadShardTopology(r.client, logstore.project, logstore.logstore)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	state.refreshing = false
+	// retry after the interval on errors, the old shards are kept
+	state.updateTime = time.Now()
+	if err == nil && len(topology.ActiveShards()) == 0 {
+		err = errors.New("no readwrite shard")
+	}
+	if err != nil {
+		level.Warn(r.logger).Log("msg", "Failed to list the shards, the hash keys are not aligned to the shards", "project", logstore.project, "logstore", logstore.logstore, "error", err)
+		return
+	}
+	if state.topology != nil && !equalShards(state.topology.ActiveShards(), topology.ActiveShards()) {
+		level.Info(r.logger).Log("msg", "The shards are changed", "project", logstore.project, "logstore", logstore.logstore, "shards", len(topology.ActiveShards()))
+	}
+	state.topology = topology
+}
+
+func equalShards(a, b []*sls.Shard) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ShardID != b[i].ShardID {
+			return false
+		}
+	}
+	return true
+}
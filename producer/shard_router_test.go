@@ -0,0 +1,108 @@
+package producer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+)
+
+type shardsClient struct {
+	sls.ClientInterface
+	lock   sync.Mutex
+	shards []*sls.Shard
+	calls  int
+}
+
+func (c *shardsClient) ListShards(project, logstore string) ([]*sls.Shard, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.calls++
+	if logstore == "unknown" {
+		return nil, &sls.Error{HTTPCode: 404, Code: "LogStoreNotExist"}
+	}
+	return c.shards, nil
+}
+
+func (c *shardsClient) setShards(shards []*sls.Shard) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.shards = shards
+}
+
+func TestShardRouter(t *testing.T) {
+	client := &shardsClient{shards: []*sls.Shard{
+		{ShardID: 0, Status: "readwrite", InclusiveBeginKey: "00000000000000000000000000000000", ExclusiveBeginKey: "80000000000000000000000000000000"},
+		{ShardID: 1, Status: "ReadWrite", InclusiveBeginKey: "80000000000000000000000000000000", ExclusiveBeginKey: "ffffffffffffffffffffffffffffffff"},
+	}}
+	router := newShardRouter(client, &ProducerConfig{ShardRefreshIntervalSec: 60}, log.NewNopLogger())
+
+	// the md5 is the hash key until the shards are listed
+	if got := router.route("p", "l", "a"); got != "0cc175b9c0f1b6a831c399e269772661" {
+		t.Errorf("route() = %v before the shards are listed, want the md5", got)
+	}
+	waitRoute(t, router, "p", "l", "a", "00000000000000000000000000000000")
+
+	tests := []struct {
+		hash string
+		want string
+	}{
+		{"a", "00000000000000000000000000000000"}, // md5 0cc175b9...
+		{"b", "80000000000000000000000000000000"}, // md5 92eb5ffe...
+		{"c", "00000000000000000000000000000000"}, // md5 4a8a08f0...
+	}
+	for _, tt := range tests {
+		if got := router.route("p", "l", tt.hash); got != tt.want {
+			t.Errorf("%q. route() = %v, want %v", tt.hash, got, tt.want)
+		}
+	}
+
+	// a split of shard 0 is followed after the refresh
+	client.setShards([]*sls.Shard{
+		{ShardID: 0, Status: "readonly", InclusiveBeginKey: "00000000000000000000000000000000", ExclusiveBeginKey: "80000000000000000000000000000000"},
+		{ShardID: 1, Status: "readwrite", InclusiveBeginKey: "80000000000000000000000000000000", ExclusiveBeginKey: "ffffffffffffffffffffffffffffffff"},
+		{ShardID: 2, Status: "readwrite", InclusiveBeginKey: "00000000000000000000000000000000", ExclusiveBeginKey: "40000000000000000000000000000000"},
+		{ShardID: 3, Status: "readwrite", InclusiveBeginKey: "40000000000000000000000000000000", ExclusiveBeginKey: "80000000000000000000000000000000"},
+	})
+	router.lock.Lock()
+	router.logstores[destinationKey{"p", "l"}].updateTime = time.Now().Add(-time.Hour)
+	router.lock.Unlock()
+	waitRoute(t, router, "p", "l", "c", "40000000000000000000000000000000")
+	client.lock.Lock()
+	if client.calls != 2 {
+		t.Errorf("ListShards() is called %d times, want 2", client.calls)
+	}
+	client.lock.Unlock()
+
+	// the md5 is the hash key when the shards are unknown
+	router.route("p", "unknown", "a")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		router.lock.Lock()
+		refreshing := router.logstores[destinationKey{"p", "unknown"}].refreshing
+		router.lock.Unlock()
+		if !refreshing {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the shards are not listed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := router.route("p", "unknown", "a"); got != "0cc175b9c0f1b6a831c399e269772661" {
+		t.Errorf("route() = %v without shards, want the md5", got)
+	}
+}
+
+func waitRoute(t *testing.T, router *shardRouter, project, logstore, hash, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for router.route(project, logstore, hash) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("route() = %v, want %v", router.route(project, logstore, hash), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
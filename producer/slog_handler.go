@@ -0,0 +1,183 @@
+//go:build go1.21
+
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+// SlogHandlerOptions configures a SlogHandler.
+type SlogHandlerOptions struct {
+	// HandlerOptions are the level, AddSource and ReplaceAttr options of slog.
+	// ReplaceAttr is called for the level, message and source and for every
+	// attribute after the groups are flattened, the groups are still passed.
+	slog.HandlerOptions
+	Topic  string
+	Source string
+	// LogTags are the tags of the log groups in addition to ProducerConfig.LogTags
+	LogTags []*sls.LogTag
+	// NonBlocking drops logs instead of blocking when the producer is full,
+	// see SlogHandler.Dropped
+	NonBlocking bool
+}
+
+// SlogHandler is a slog.Handler that writes logs through a Producer.
+//
+// The time of a record is the Time and TimeNs of the log, and the level,
+// message and source are the contents "level", "msg" and "source".
+// Attributes are contents, attributes in groups are named <group>.<key>.
+type SlogHandler struct {
+	producer *Producer
+	project  string
+	logstore string
+	options  *SlogHandlerOptions
+	// contents added by WithAttrs, already flattened
+	contents []*sls.LogContent
+	// groups opened by WithGroup, prefix is the groups joined with "."
+	groups  []string
+	prefix  string
+	dropped *int64
+}
+
+// NewSlogHandler creates a SlogHandler writing into a logstore, options may be nil.
+func NewSlogHandler(producer *Producer, project, logstore string, options *SlogHandlerOptions) *SlogHandler {
+	if options == nil {
+		options = &SlogHandlerOptions{}
+	}
+	return &SlogHandler{
+		producer: producer,
+		project:  project,
+		logstore: logstore,
+		options:  options,
+		dropped:  new(int64),
+	}
+}
+
+// Dropped returns the number of logs dropped in NonBlocking mode, shared by the
+// handlers derived with WithAttrs and WithGroup.
+func (h *SlogHandler) Dropped() int64 {
+	return atomic.LoadInt64(h.dropped)
+}
+
+func (h *SlogHandler) Enabled(_ context.Context, l slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.options.Level != nil {
+		minLevel = h.options.Level.Level()
+	}
+	return l >= minLevel
+}
+
+func (h *SlogHandler) Handle(_ context.Context, r slog.Record) error {
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	log := &sls.Log{
+		Time:     proto.Uint32(uint32(t.Unix())),
+		TimeNs:   proto.Uint32(uint32(t.Nanosecond())),
+		Contents: make([]*sls.LogContent, 0, 3+len(h.contents)+r.NumAttrs()),
+	}
+	log.Contents = h.appendAttr(log.Contents, nil, "", slog.Any(slog.LevelKey, r.Level))
+	log.Contents = h.appendAttr(log.Contents, nil, "", slog.String(slog.MessageKey, r.Message))
+	if h.options.AddSource && r.PC != 0 {
+		log.Contents = h.appendAttr(log.Contents, nil, "", slog.String(slog.SourceKey, sourceFrame(r.PC)))
+	}
+	log.Contents = append(log.Contents, h.contents...)
+	r.Attrs(func(a slog.Attr) bool {
+		log.Contents = h.appendAttr(log.Contents, h.groups, h.prefix, a)
+		return true
+	})
+	logs := []*sls.Log{log}
+	if h.options.NonBlocking {
+		err := h.producer.sendLogListNoWait(h.project, h.logstore, h.options.Topic, h.options.Source, h.options.LogTags, logs)
+		var blocked *BlockedError
+		if errors.As(err, &blocked) {
+			atomic.AddInt64(h.dropped, 1)
+			return nil
+		}
+		return err
+	}
+	if err := h.producer.waitTime(h.project, h.logstore); err != nil {
+		return err
+	}
+	return h.producer.addLogs(h.project, h.logstore, "", h.options.Topic, h.options.Source, h.options.LogTags, logs, nil, false)
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	c := *h
+	c.contents = append([]*sls.LogContent{}, h.contents...)
+	for _, a := range attrs {
+		c.contents = h.appendAttr(c.contents, h.groups, h.prefix, a)
+	}
+	return &c
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.groups = append(append([]string{}, h.groups...), name)
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+// appendAttr flattens an attribute into contents, keys of groups are prefixed with the group names
+func (h *SlogHandler) appendAttr(contents []*sls.LogContent, groups []string, prefix string, a slog.Attr) []*sls.LogContent {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return contents
+		}
+		if a.Key != "" {
+			groups = append(groups[:len(groups):len(groups)], a.Key)
+			prefix += a.Key + "."
+		}
+		for _, ga := range attrs {
+			contents = h.appendAttr(contents, groups, prefix, ga)
+		}
+		return contents
+	}
+	if h.options.ReplaceAttr != nil {
+		a = h.options.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+	if a.Key == "" {
+		return contents
+	}
+	return append(contents, &sls.LogContent{Key: proto.String(prefix + a.Key), Value: proto.String(formatSlogValue(a.Value))})
+}
+
+func formatSlogValue(v slog.Value) string {
+	switch v.Kind() {
+	case slog.KindString:
+		return v.String()
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+		return fmt.Sprint(v.Any())
+	}
+	return v.String()
+}
+
+func sourceFrame(pc uintptr) string {
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	return frame.File + ":" + strconv.Itoa(frame.Line)
+}
@@ -0,0 +1,145 @@
+//go:build go1.21
+
+package producer
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+func TestSlogHandler(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	producer := InitProducer(config)
+	handler := NewSlogHandler(producer, "p", "l", &SlogHandlerOptions{
+		HandlerOptions: slog.HandlerOptions{AddSource: true},
+		Topic:          "app",
+		LogTags:        []*sls.LogTag{{Key: proto.String("env"), Value: proto.String("prod")}},
+	})
+	logger := slog.New(handler).With("service", "api").WithGroup("req")
+	logger.Debug("ignored")
+	logger.Info("done", "id", 7, slog.Group("user", "name", "bob"), slog.Group("empty"), "err", errors.New("boom"))
+
+	logs := pendingLogs(producer)
+	if len(logs) != 1 {
+		t.Fatalf("SlogHandler sent %d logs, want 1", len(logs))
+	}
+	got := contentsOf(logs[0])
+	want := map[string]string{
+		"level":         "INFO",
+		"msg":           "done",
+		"service":       "api",
+		"req.id":        "7",
+		"req.user.name": "bob",
+		"req.err":       "boom",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%q. SlogHandler content = %v, want %v", k, got[k], v)
+		}
+	}
+	if !strings.Contains(got["source"], "slog_handler_test.go:") {
+		t.Errorf("SlogHandler source = %v", got["source"])
+	}
+	if len(got) != len(want)+1 {
+		t.Errorf("SlogHandler contents = %v", got)
+	}
+	for _, batch := range producer.logAccumulator.logGroupData {
+		if tags := batch.logGroup.LogTags; len(tags) != 1 || tags[0].GetKey() != "env" || tags[0].GetValue() != "prod" {
+			t.Errorf("SlogHandler LogTags = %v", tags)
+		}
+	}
+	if logs[0].GetTime() == 0 {
+		t.Errorf("SlogHandler time is not set")
+	}
+}
+
+func TestSlogHandlerNonBlocking(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.MaxBlockSec = 60
+	producer := InitProducer(config)
+	handler := NewSlogHandler(producer, "p", "l", &SlogHandlerOptions{NonBlocking: true})
+	logger := slog.New(handler)
+	logger.Info("sent")
+	producer.producerLogGroupSize = config.TotalSizeLnBytes + 1
+	start := time.Now()
+	logger.Info("a")
+	logger.With("k", "v").Info("b")
+	// MaxBlockSec is not waited for
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("SlogHandler blocks for %v", elapsed)
+	}
+	if handler.Dropped() != 2 || len(pendingLogs(producer)) != 1 {
+		t.Errorf("SlogHandler dropped %d, sent %d", handler.Dropped(), len(pendingLogs(producer)))
+	}
+}
+
+func TestSlogHandlerNonBlockingConcurrent(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.TotalSizeLnBytes = 1
+	producer := InitProducer(config)
+	handler := NewSlogHandler(producer, "p", "l", &SlogHandlerOptions{NonBlocking: true})
+	logger := slog.New(handler)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger.Info("a")
+		}()
+	}
+	wg.Wait()
+	// only the first log fits, the others see it when they are added
+	if handler.Dropped() != 49 || len(pendingLogs(producer)) != 1 {
+		t.Errorf("SlogHandler dropped %d, sent %d, want 49, 1", handler.Dropped(), len(pendingLogs(producer)))
+	}
+}
+
+func TestSlogHandlerMaskProcessor(t *testing.T) {
+	mask, err := NewMaskProcessor(sls.SensitiveKey{Key: "phone", Type: "md5", RegexBegin: "^", RegexContent: "\\w+"})
+	if err != nil {
+		t.Fatalf("NewMaskProcessor() error = %v", err)
+	}
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.Processors = []Processor{mask}
+	producer := InitProducer(config)
+	// the contents of WithAttrs are shared by the logs of the goroutines
+	logger := slog.New(NewSlogHandler(producer, "p", "l", nil)).With("phone", "13812345678")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				logger.Info("call")
+			}
+		}()
+	}
+	wg.Wait()
+
+	logs := pendingLogs(producer)
+	if len(logs) != 80 {
+		t.Fatalf("SlogHandler sent %d logs, want 80", len(logs))
+	}
+	// masked once
+	want := "4c0115d39ddea1eba5a7cdf8edfc18a9"
+	for _, log := range logs {
+		if got := contentsOf(log)["phone"]; got != want {
+			t.Fatalf("masked phone = %v, want %v", got, want)
+		}
+	}
+	logger.Info("call")
+	if got := contentsOf(pendingLogs(producer)[80])["phone"]; got != want {
+		t.Errorf("masked phone = %v after the logs, want %v", got, want)
+	}
+}
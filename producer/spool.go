@@ -0,0 +1,546 @@
+package producer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// SpoolSyncPolicy is when the spool fsyncs its segment files.
+type SpoolSyncPolicy int
+
+const (
+	// SpoolSyncInterval fsyncs the segment being written every second
+	SpoolSyncInterval SpoolSyncPolicy = iota
+	// SpoolSyncAlways fsyncs after every batch is written
+	SpoolSyncAlways
+	// SpoolSyncNever leaves the flushing to the OS
+	SpoolSyncNever
+)
+
+// The error codes of the attempts of the batches the spool fails to keep
+const (
+	SpoolFullError    = "SpoolFull"
+	SpoolExpiredError = "SpoolExpired"
+)
+
+// SpoolCallBack can be implemented by a CallBack to know when its batch is
+// written to the disk spool. Success or Fail is called after the batch is
+// replayed from the spool, and Result.IsSpooled is true.
+type SpoolCallBack interface {
+	Spooled(result *Result)
+}
+
+const (
+	spoolSegmentSuffix     = ".seg"
+	spoolSegmentBytes      = 8 * 1024 * 1024
+	spoolRecordHeaderSize  = 8
+	defaultSpoolMaxBytes   = 1024 * 1024 * 1024
+	defaultSpoolMaxReplays = 10
+)
+
+// how often the spool replays a segment and syncs with SpoolSyncInterval
+var spoolReplayInterval = time.Second
+
+var errSpoolFull = errors.New("the spool exceeds SpoolMaxBytes")
+
+// spoolSegment is a file of records, the record of a batch is
+// | length uint32 | crc32 uint32 | payload |, see encodeSpoolRecord.
+type spoolSegment struct {
+	seq     uint64
+	path    string
+	size    int64
+	modTime time.Time
+	// the batches spooled by this process by offset, the callbacks of the
+	// batches spooled before a restart are lost
+	batches map[int64]*ProducerBatch
+	// the replayed batches that are not finished
+	pending int
+}
+
+// spool keeps the batches that cannot be sent or that overflow
+// TotalSizeLnBytes in segment files and replays them in order.
+type spool struct {
+	dir       string
+	maxBytes  int64
+	sync      SpoolSyncPolicy
+	retention time.Duration
+	producer  *Producer
+	logger    log.Logger
+
+	lock       sync.Mutex
+	segments   []*spoolSegment // by seq, the last one is active when file is not nil
+	file       *os.File
+	totalBytes int64
+	dirty      bool
+	nextSeq    uint64
+	replaying  *spoolSegment
+	closed     bool
+	stop       chan struct{}
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+}
+
+func openSpool(config *ProducerConfig, producer *Producer, logger log.Logger) (*spool, error) {
+	if err := os.MkdirAll(config.SpoolDir, 0755); err != nil {
+		return nil, err
+	}
+	s := &spool{
+		dir:       config.SpoolDir,
+		maxBytes:  config.SpoolMaxBytes,
+		sync:      config.SpoolSync,
+		retention: time.Duration(config.SpoolRetentionSec) * time.Second,
+		producer:  producer,
+		logger:    logger,
+		stop:      make(chan struct{}),
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, spoolSegmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, &spoolSegment{seq: seq, path: filepath.Join(s.dir, name), size: info.Size(), modTime: info.ModTime()})
+		s.totalBytes += info.Size()
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+	if n := len(s.segments); n > 0 {
+		s.nextSeq = s.segments[n-1].seq + 1
+		level.Info(logger).Log("msg", "spool has segments to replay", "segments", n, "bytes", s.totalBytes)
+	}
+	return s, nil
+}
+
+func (s *spool) isFull() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.totalBytes >= s.maxBytes
+}
+
+// write appends the batch to the active segment and calls the Spooled callbacks.
+func (s *spool) write(batch *ProducerBatch) error {
+	record, err := encodeSpoolRecord(batch)
+	if err != nil {
+		return err
+	}
+	if err := s.append(batch, record); err != nil {
+		return err
+	}
+	batch.result.spooled = true
+	for _, callBack := range batch.callBackList {
+		if spoolCallBack, ok := callBack.(SpoolCallBack); ok {
+			spoolCallBack.Spooled(batch.result)
+		}
+	}
+	return nil
+}
+
+func (s *spool) append(batch *ProducerBatch, record []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return errors.New("the spool is closed")
+	}
+	if s.totalBytes+int64(len(record)) > s.maxBytes {
+		return errSpoolFull
+	}
+	if s.file == nil || s.segments[len(s.segments)-1].size >= spoolSegmentBytes {
+		if err := s.roll(); err != nil {
+			return err
+		}
+	}
+	segment := s.segments[len(s.segments)-1]
+	if _, err := s.file.Write(record); err != nil {
+		// drop the partial record, it is skipped by the crc when replayed anyway
+		s.file.Truncate(segment.size)
+		s.file.Seek(segment.size, io.SeekStart)
+		return err
+	}
+	if s.sync == SpoolSyncAlways {
+		if err := s.file.Sync(); err != nil {
+			return err
+		}
+	} else {
+		s.dirty = true
+	}
+	segment.batches[segment.size] = batch
+	segment.size += int64(len(record))
+	segment.modTime = time.Now()
+	s.totalBytes += int64(len(record))
+	return nil
+}
+
+// roll seals the active segment and creates a new one, s.lock is held
+func (s *spool) roll() error {
+	s.seal()
+	seq := s.nextSeq
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentSuffix))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.nextSeq++
+	s.file = file
+	s.segments = append(s.segments, &spoolSegment{seq: seq, path: path, modTime: time.Now(), batches: map[int64]*ProducerBatch{}})
+	return nil
+}
+
+// seal closes the active segment, s.lock is held
+func (s *spool) seal() {
+	if s.file == nil {
+		return
+	}
+	if s.sync != SpoolSyncNever {
+		s.file.Sync()
+	}
+	s.file.Close()
+	s.file = nil
+	s.dirty = false
+}
+
+func (s *spool) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(spoolReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		s.lock.Lock()
+		if s.dirty && s.sync == SpoolSyncInterval && s.file != nil {
+			s.file.Sync()
+			s.dirty = false
+		}
+		s.lock.Unlock()
+		s.expire()
+		s.replay()
+	}
+}
+
+// replay sends the records of the oldest segment when the producer has room for them,
+// the segment is removed after all of its batches are finished.
+func (s *spool) replay() {
+	config := s.producer.producerConfig
+	s.lock.Lock()
+	if s.closed || s.replaying != nil || len(s.segments) == 0 ||
+		atomic.LoadInt64(&s.producer.producerLogGroupSize) > config.TotalSizeLnBytes/2 {
+		s.lock.Unlock()
+		return
+	}
+	segment := s.segments[0]
+	if s.file != nil && len(s.segments) == 1 {
+		s.seal()
+	}
+	s.replaying = segment
+	s.lock.Unlock()
+
+	batches, err := readSpoolSegment(segment.path, config)
+	if err != nil {
+		level.Warn(s.logger).Log("msg", "failed to read the spool segment, the rest of it is dropped", "segment", segment.path, "error", err)
+	}
+	s.lock.Lock()
+	for offset, batch := range batches {
+		if spooled, ok := segment.batches[offset]; ok {
+			batch.callBackList = spooled.callBackList
+			batch.result = spooled.result
+		} else if config.SpoolReplayCallBack != nil {
+			batch.callBackList = []CallBack{config.SpoolReplayCallBack}
+		}
+		batch.spoolSegment = segment
+		batch.spoolReplays++
+	}
+	segment.batches = nil
+	segment.pending = len(batches)
+	if segment.pending == 0 {
+		s.remove(segment)
+	}
+	s.lock.Unlock()
+
+	level.Info(s.logger).Log("msg", "replay the spool segment", "segment", segment.path, "batches", len(batches))
+	offsets := make([]int64, 0, len(batches))
+	for offset := range batches {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	for _, offset := range offsets {
+		batch := batches[offset]
+		s.producer.addSize(batch.project, batch.logstore, batch.totalDataSize)
+		s.producer.registerBatch(batch)
+		s.producer.threadPool.addTask(batch)
+	}
+}
+
+// finish is called when a replayed batch is delivered, failed or spooled again
+func (s *spool) finish(batch *ProducerBatch) {
+	segment := batch.spoolSegment
+	if segment == nil {
+		return
+	}
+	batch.spoolSegment = nil
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	segment.pending--
+	if segment.pending == 0 {
+		s.remove(segment)
+	}
+}
+
+// remove deletes a segment that is replayed, s.lock is held
+func (s *spool) remove(segment *spoolSegment) {
+	s.detach(segment)
+	s.removeFile(segment)
+}
+
+// detach removes a segment from the spool without deleting its file, s.lock is held
+func (s *spool) detach(segment *spoolSegment) {
+	for i, seg := range s.segments {
+		if seg == segment {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			break
+		}
+	}
+	if s.replaying == segment {
+		s.replaying = nil
+	}
+	s.totalBytes -= segment.size
+	// the senders waiting for the room of the spool, see waitContext
+	s.producer.notifySpace()
+}
+
+func (s *spool) removeFile(segment *spoolSegment) {
+	if err := os.Remove(segment.path); err != nil {
+		level.Warn(s.logger).Log("msg", "failed to remove the spool segment", "segment", segment.path, "error", err)
+	}
+}
+
+// expire removes the sealed segments older than the retention, the batches in
+// them, including the ones spooled before a restart, fail with SpoolExpiredError
+// and go to the DeadLetterSink
+func (s *spool) expire() {
+	if s.retention <= 0 {
+		return
+	}
+	config := s.producer.producerConfig
+	var segments []*spoolSegment
+	deadline := time.Now().Add(-s.retention)
+	s.lock.Lock()
+	for _, segment := range append([]*spoolSegment{}, s.segments...) {
+		if segment == s.replaying || (s.file != nil && segment == s.segments[len(s.segments)-1]) || segment.modTime.After(deadline) {
+			continue
+		}
+		// the detached segments are not written or replayed, so they are read without the lock
+		s.detach(segment)
+		segments = append(segments, segment)
+	}
+	s.lock.Unlock()
+	for _, segment := range segments {
+		batches, err := readSpoolSegment(segment.path, config)
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "failed to read the expired spool segment, the rest of it is dropped", "segment", segment.path, "error", err)
+		}
+		s.removeFile(segment)
+		level.Warn(s.logger).Log("msg", "the spool segment exceeds the retention and is dropped", "segment", segment.path, "batches", len(batches))
+		offsets := make([]int64, 0, len(batches))
+		for offset := range batches {
+			offsets = append(offsets, offset)
+		}
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+		for _, offset := range offsets {
+			batch := batches[offset]
+			if spooled, ok := segment.batches[offset]; ok {
+				batch.callBackList = spooled.callBackList
+				batch.result = spooled.result
+			} else if config.SpoolReplayCallBack != nil {
+				batch.callBackList = []CallBack{config.SpoolReplayCallBack}
+			}
+			s.fail(batch, SpoolExpiredError, "the spooled batch exceeds SpoolRetentionSec")
+		}
+	}
+}
+
+// fail finishes a batch the spool cannot keep, it goes to the DeadLetterSink
+func (s *spool) fail(batch *ProducerBatch, code, message string) {
+	nowMs := GetTimeMs(time.Now().UnixNano())
+	batch.result.attemptList = append(batch.result.attemptList, createAttempt(false, "", code, message, nowMs, 0))
+	batch.result.successful = false
+	s.producer.stats.recordFailed(batch)
+	s.producer.writeDeadLetter(batch)
+	for _, callBack := range batch.callBackList {
+		callBack.Fail(batch.result)
+	}
+	s.producer.completeBatch(batch, false)
+}
+
+// stopReplay stops replaying when the producer is closing
+func (s *spool) stopReplay() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// close stops replaying and closes the active segment, batches are not spooled after it
+func (s *spool) close() {
+	s.stopReplay()
+	s.wg.Wait()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seal()
+	s.closed = true
+}
+
+func encodeSpoolRecord(batch *ProducerBatch) ([]byte, error) {
+	logGroup, err := batch.logGroup.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, 0, len(batch.project)+len(batch.logstore)+len(logGroup)+32)
+	payload = appendSpoolString(payload, batch.project)
+	payload = appendSpoolString(payload, batch.logstore)
+	var flags byte
+	if batch.shardHash != nil {
+		flags |= 1
+	}
+	if batch.useMetricStoreUrl {
+		flags |= 2
+	}
+	if batch.spoolReplays > 0 {
+		flags |= 4
+	}
+	payload = append(payload, flags)
+	if batch.shardHash != nil {
+		payload = appendSpoolString(payload, *batch.shardHash)
+	}
+	if batch.spoolReplays > 0 {
+		payload = binary.AppendUvarint(payload, uint64(batch.spoolReplays))
+	}
+	payload = append(payload, logGroup...)
+
+	record := make([]byte, spoolRecordHeaderSize, spoolRecordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	return append(record, payload...), nil
+}
+
+func appendSpoolString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func readSpoolString(b []byte) (string, []byte, error) {
+	n, l := binary.Uvarint(b)
+	if l <= 0 || uint64(len(b)-l) < n {
+		return "", nil, errors.New("invalid spool record")
+	}
+	return string(b[l : l+int(n)]), b[l+int(n):], nil
+}
+
+// readSpoolSegment reads the batches of a segment by offset, it stops at the
+// first invalid record, eg. a partial record of a crash.
+func readSpoolSegment(path string, config *ProducerConfig) (map[int64]*ProducerBatch, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	batches := map[int64]*ProducerBatch{}
+	var offset int64
+	for len(data) > 0 {
+		if len(data) < spoolRecordHeaderSize {
+			return batches, errors.New("truncated spool record")
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(len(data)-spoolRecordHeaderSize) < uint64(n) {
+			return batches, errors.New("truncated spool record")
+		}
+		payload := data[spoolRecordHeaderSize : spoolRecordHeaderSize+int(n)]
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[4:]) {
+			return batches, errors.New("invalid spool record checksum")
+		}
+		batch, err := decodeSpoolRecord(payload, config)
+		if err != nil {
+			return batches, err
+		}
+		batches[offset] = batch
+		offset += int64(spoolRecordHeaderSize + int(n))
+		data = data[spoolRecordHeaderSize+int(n):]
+	}
+	return batches, nil
+}
+
+func decodeSpoolRecord(payload []byte, config *ProducerConfig) (*ProducerBatch, error) {
+	project, payload, err := readSpoolString(payload)
+	if err != nil {
+		return nil, err
+	}
+	logstore, payload, err := readSpoolString(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 {
+		return nil, errors.New("invalid spool record")
+	}
+	flags := payload[0]
+	payload = payload[1:]
+	var shardHash *string
+	if flags&1 != 0 {
+		var hash string
+		if hash, payload, err = readSpoolString(payload); err != nil {
+			return nil, err
+		}
+		shardHash = &hash
+	}
+	var replays uint64
+	if flags&4 != 0 {
+		var l int
+		if replays, l = binary.Uvarint(payload); l <= 0 {
+			return nil, errors.New("invalid spool record")
+		}
+		payload = payload[l:]
+	}
+	logGroup := &sls.LogGroup{}
+	if err := logGroup.Unmarshal(payload); err != nil {
+		return nil, err
+	}
+	result := initResult()
+	result.spooled = true
+	return &ProducerBatch{
+		totalDataSize:        int64(logGroup.Size()),
+		logGroup:             logGroup,
+		maxRetryIntervalInMs: config.MaxRetryBackoffMs,
+		callBackList:         []CallBack{},
+		createTimeMs:         GetTimeMs(time.Now().UnixNano()),
+		maxRetryTimes:        config.Retries,
+		baseRetryBackoffMs:   config.BaseRetryBackoffMs,
+		project:              project,
+		logstore:             logstore,
+		shardHash:            shardHash,
+		result:               result,
+		maxReservedAttempts:  config.MaxReservedAttempts,
+		useMetricStoreUrl:    flags&2 != 0,
+		spoolReplays:         int(replays),
+	}, nil
+}
@@ -0,0 +1,322 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+// flakyClient fails PostLogStoreLogsV2 until it is healthy
+type flakyClient struct {
+	sls.ClientInterface
+	lock    sync.Mutex
+	healthy bool
+	sent    []*sls.LogGroup
+}
+
+func (c *flakyClient) PostLogStoreLogsV2(project, logstore string, req *sls.PostLogStoreLogsRequest) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.healthy {
+		return &sls.Error{HTTPCode: 502, Code: "BadGateway", Message: "unavailable"}
+	}
+	c.sent = append(c.sent, req.LogGroup)
+	return nil
+}
+
+func (c *flakyClient) setHealthy() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.healthy = true
+}
+
+func (c *flakyClient) sentCount() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.sent)
+}
+
+type spoolCallBack struct {
+	lock     sync.Mutex
+	spooled  int
+	success  int
+	fail     int
+	resultCh chan *Result
+}
+
+func (c *spoolCallBack) Spooled(result *Result) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.spooled++
+}
+
+func (c *spoolCallBack) Success(result *Result) {
+	c.lock.Lock()
+	c.success++
+	c.lock.Unlock()
+	c.resultCh <- result
+}
+
+func (c *spoolCallBack) Fail(result *Result) {
+	c.lock.Lock()
+	c.fail++
+	c.lock.Unlock()
+	c.resultCh <- result
+}
+
+func newSpoolTestConfig(dir string) *ProducerConfig {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.SpoolDir = dir
+	config.LingerMs = 100
+	config.Retries = 0
+	return config
+}
+
+func testBatch(config *ProducerConfig, value string) *ProducerBatch {
+	log := &sls.Log{Time: proto.Uint32(1), Contents: []*sls.LogContent{{Key: proto.String("k"), Value: proto.String(value)}}}
+	return initProducerBatch(log, nil, "p", "l", "topic", "source", "hash", nil, config)
+}
+
+func TestSpoolReadWrite(t *testing.T) {
+	dir := t.TempDir()
+	config := newSpoolTestConfig(dir)
+	producer := InitProducer(config)
+	if producer.spool == nil {
+		t.Fatal("InitProducer() did not open the spool")
+	}
+	for _, value := range []string{"a", "b", "c"} {
+		if err := producer.spool.write(testBatch(config, value)); err != nil {
+			t.Fatalf("write() error = %v", err)
+		}
+	}
+	producer.spool.close()
+
+	// a partial record of a crash is skipped
+	path := producer.spool.segments[0].path
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{100, 0, 0, 0, 1})
+	file.Close()
+
+	reopened, err := openSpool(config, producer, producer.logger)
+	if err != nil {
+		t.Fatalf("openSpool() error = %v", err)
+	}
+	if len(reopened.segments) != 1 || reopened.nextSeq != 1 {
+		t.Fatalf("openSpool() segments = %d, nextSeq = %d, want 1, 1", len(reopened.segments), reopened.nextSeq)
+	}
+	batches, err := readSpoolSegment(path, config)
+	if err == nil {
+		t.Errorf("readSpoolSegment() error = nil, want the truncated record")
+	}
+	if len(batches) != 3 {
+		t.Fatalf("readSpoolSegment() = %d batches, want 3", len(batches))
+	}
+	batch := batches[0]
+	if batch.project != "p" || batch.logstore != "l" || batch.getShardHash() == nil || *batch.getShardHash() != "hash" ||
+		batch.logGroup.GetTopic() != "topic" || contentsOf(batch.logGroup.Logs[0])["k"] != "a" || !batch.result.IsSpooled() {
+		t.Errorf("readSpoolSegment() = %+v, want the first batch", batch)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	config := newSpoolTestConfig(t.TempDir())
+	config.SpoolMaxBytes = 10
+	producer := InitProducer(config)
+	if err := producer.spool.write(testBatch(config, "a")); err != errSpoolFull {
+		t.Errorf("write() error = %v, want %v", err, errSpoolFull)
+	}
+}
+
+func TestSpoolRetention(t *testing.T) {
+	config := newSpoolTestConfig(t.TempDir())
+	config.SpoolRetentionSec = 60
+	producer := InitProducer(config)
+	callback := &spoolCallBack{resultCh: make(chan *Result, 1)}
+	batch := testBatch(config, "a")
+	batch.callBackList = []CallBack{callback}
+	if err := producer.spool.write(batch); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	producer.spool.lock.Lock()
+	producer.spool.seal()
+	producer.spool.segments[0].modTime = time.Now().Add(-time.Hour)
+	producer.spool.lock.Unlock()
+
+	producer.spool.expire()
+	result := <-callback.resultCh
+	if result.GetErrorCode() != SpoolExpiredError || len(producer.spool.segments) != 0 || producer.spool.totalBytes != 0 {
+		t.Errorf("expire() error code = %q, segments = %d", result.GetErrorCode(), len(producer.spool.segments))
+	}
+}
+
+func TestSpoolRetentionAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	config := newSpoolTestConfig(dir)
+	producer := InitProducer(config)
+	for _, value := range []string{"a", "b"} {
+		if err := producer.spool.write(testBatch(config, value)); err != nil {
+			t.Fatalf("write() error = %v", err)
+		}
+	}
+	producer.spool.close()
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(producer.spool.segments[0].path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// the segment left by the previous process is dead-lettered
+	config = newSpoolTestConfig(dir)
+	config.SpoolRetentionSec = 60
+	var lock sync.Mutex
+	var letters []*DeadLetter
+	config.DeadLetterSink = DeadLetterFunc(func(letter *DeadLetter) error {
+		lock.Lock()
+		defer lock.Unlock()
+		letters = append(letters, letter)
+		return nil
+	})
+	reopened := InitProducer(config)
+	reopened.spool.expire()
+	lock.Lock()
+	defer lock.Unlock()
+	if len(letters) != 2 || len(reopened.spool.segments) != 0 {
+		t.Fatalf("expire() wrote %d dead letters, segments = %d, want 2, 0", len(letters), len(reopened.spool.segments))
+	}
+	attempts := letters[0].Attempts
+	if letters[0].Project != "p" || len(attempts) == 0 || attempts[len(attempts)-1].ErrorCode != SpoolExpiredError {
+		t.Errorf("expire() dead letter = %+v", letters[0])
+	}
+	if stats := reopened.Stats(); stats.FailedBatches != 2 {
+		t.Errorf("Stats() failed batches = %d, want 2", stats.FailedBatches)
+	}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	defer func(interval time.Duration) { spoolReplayInterval = interval }(spoolReplayInterval)
+	spoolReplayInterval = 50 * time.Millisecond
+
+	dir := t.TempDir()
+	producer := InitProducer(newSpoolTestConfig(dir))
+	client := &flakyClient{}
+	producer.threadPool.ioworker.client = client
+	producer.Start()
+
+	callback := &spoolCallBack{resultCh: make(chan *Result, 1)}
+	log := &sls.Log{Time: proto.Uint32(1), Contents: []*sls.LogContent{{Key: proto.String("k"), Value: proto.String("v")}}}
+	if err := producer.SendLogWithCallBack("p", "l", "", "", log, callback); err != nil {
+		t.Fatalf("SendLogWithCallBack() error = %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		callback.lock.Lock()
+		spooled := callback.spooled
+		callback.lock.Unlock()
+		if spooled > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the batch is not spooled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client.setHealthy()
+	select {
+	case result := <-callback.resultCh:
+		if !result.IsSuccessful() || !result.IsSpooled() {
+			t.Errorf("replayed result successful = %v, spooled = %v, want true, true", result.IsSuccessful(), result.IsSpooled())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the spooled batch is not replayed")
+	}
+	producer.SafeClose()
+
+	if client.sentCount() != 1 {
+		t.Errorf("sent %d log groups, want 1", client.sentCount())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("the spool has %d segments after the replay, want 0", len(entries))
+	}
+}
+
+func TestSpoolOverflow(t *testing.T) {
+	config := newSpoolTestConfig(t.TempDir())
+	config.MaxBlockSec = 0
+	producer := InitProducer(config)
+	producer.producerLogGroupSize = config.TotalSizeLnBytes + 1
+	log := &sls.Log{Time: proto.Uint32(1), Contents: []*sls.LogContent{{Key: proto.String("k"), Value: proto.String("v")}}}
+	for i := 0; i < 3; i++ {
+		if err := producer.SendLog("p", "l", "", "", log); err != nil {
+			t.Fatalf("SendLog() error = %v", err)
+		}
+	}
+	// the overflowing logs are batched before they are spooled
+	if len(pendingLogs(producer)) != 0 || len(producer.logAccumulator.spoolData) != 1 || len(producer.spool.segments) != 0 {
+		t.Fatalf("the overflowing logs are not batched for the spool")
+	}
+	result, err := producer.Flush(context.Background())
+	if err != nil || result.SpooledBatches != 1 || result.SpooledLogs != 3 {
+		t.Fatalf("Flush() = %+v, %v, want 1 spooled batch of 3 logs", result, err)
+	}
+	batches, err := readSpoolSegment(producer.spool.segments[0].path, config)
+	if err != nil || len(batches) != 1 {
+		t.Errorf("the spool has %d records, %v, want 1", len(batches), err)
+	}
+
+	producer.spool.maxBytes = 0
+	if err := producer.SendLog("p", "l", "", "", log); err == nil || err.Error() != TimeoutExecption {
+		t.Errorf("SendLog() error = %v, want %v when the spool is full", err, TimeoutExecption)
+	}
+}
+
+func TestSpoolMaxReplays(t *testing.T) {
+	config := newSpoolTestConfig(t.TempDir())
+	config.SpoolMaxReplays = 2
+	producer := InitProducer(config)
+	batch := testBatch(config, "a")
+	batch.spoolReplays = 1
+	if err := producer.spool.write(batch); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	// the replays are kept in the record and counted by replay
+	producer.spool.replay()
+	replayed := producer.threadPool.popTask()
+	if replayed == nil || replayed.spoolReplays != 2 {
+		t.Fatalf("replay() = %+v, want the batch replayed twice", replayed)
+	}
+	if producer.mover.ioWorker.spoolBatch(replayed) {
+		t.Errorf("spoolBatch() spools the batch over SpoolMaxReplays")
+	}
+}
+
+func TestSpoolMaxDestinationBytes(t *testing.T) {
+	config := newSpoolTestConfig(t.TempDir())
+	config.MaxDestinationBytes = 10
+	producer := InitProducer(config)
+	log := GenerateLog(uint32(time.Now().Unix()), map[string]string{"content": "more than ten bytes"})
+	if err := producer.SendLog("p", "slow", "", "", log); err != nil {
+		t.Fatalf("SendLog() error = %v", err)
+	}
+
+	// the spool has room, but the destination is over its quota
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var blocked *BlockedError
+	if err := producer.SendLogContext(ctx, "p", "slow", "", "", log, nil); !errors.As(err, &blocked) {
+		t.Errorf("SendLogContext() error = %v, want a BlockedError over the quota", err)
+	}
+	if len(producer.logAccumulator.spoolData) != 0 {
+		t.Errorf("the logs over MaxDestinationBytes are spooled")
+	}
+}
@@ -0,0 +1,214 @@
+package producer
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProducerStats is a snapshot of the state of a Producer, see Producer.Stats.
+type ProducerStats struct {
+	// BufferedBytes is the size of the logs in memory, compared with TotalSizeLnBytes
+	BufferedBytes int64
+	// InFlightRequests is the number of the batches being sent
+	InFlightRequests int64
+	// QueuedBatches is the number of the batches waiting for an io worker
+	QueuedBatches int
+	// RetryQueueDepth is the number of the batches waiting to be retried
+	RetryQueueDepth int
+	// SpooledBytes is the disk usage of the spool, see ProducerConfig.SpoolDir
+	SpooledBytes int64
+
+	SentBatches   int64
+	SentLogs      int64
+	SentBytes     int64
+	FailedBatches int64
+	FailedLogs    int64
+	FailedBytes   int64
+	// Requests counts every attempt to send a batch, successful or not
+	Requests int64
+	// AvgBatchBytes and AvgBatchLogs are the averages of the sent batches
+	AvgBatchBytes float64
+	AvgBatchLogs  float64
+	// AvgLatencyMs is the average time of the requests
+	AvgLatencyMs float64
+
+	// Destinations are the stats by project and logstore
+	Destinations []*DestinationStats
+}
+
+// DestinationStats is the state of the logs sent to a logstore.
+type DestinationStats struct {
+	Project  string
+	Logstore string
+	// BufferedBytes is the size of the logs in memory, compared with MaxDestinationBytes
+	BufferedBytes int64
+	// Suspended is true when the circuit breaker holds the batches
+	Suspended bool
+	// InFlightRequests is the number of the batches being sent
+	InFlightRequests int
+	// ConcurrencyLimit is the limit of InFlightRequests with AdaptiveConcurrency, 0 without it
+	ConcurrencyLimit int
+	// PendingBatches is the number of the batches in memory, being
+	// accumulated, waiting for an io worker or to be retried
+	PendingBatches int
+	SentLogs       int64
+	SentBytes      int64
+	FailedLogs     int64
+	FailedBytes    int64
+	// LastError is the last failed attempt, nil if no attempt has failed
+	LastError *Attempt
+}
+
+// producerStats keeps the counters of a producer, the gauges are read when Stats is called
+type producerStats struct {
+	lock          sync.Mutex
+	sentBatches   int64
+	sentLogs      int64
+	sentBytes     int64
+	failedBatches int64
+	failedLogs    int64
+	failedBytes   int64
+	requests      int64
+	latencyMs     int64
+	destinations  map[destinationKey]*DestinationStats
+}
+
+type destinationKey struct {
+	project  string
+	logstore string
+}
+
+func newProducerStats() *producerStats {
+	return &producerStats{destinations: map[destinationKey]*DestinationStats{}}
+}
+
+// destination returns the stats of a logstore, s.lock is held
+func (s *producerStats) destination(project, logstore string) *DestinationStats {
+	key := destinationKey{project, logstore}
+	d, ok := s.destinations[key]
+	if !ok {
+		d = &DestinationStats{Project: project, Logstore: logstore}
+		s.destinations[key] = d
+	}
+	return d
+}
+
+func (s *producerStats) recordSent(producerBatch *ProducerBatch, costMs int64) {
+	logs := int64(len(producerBatch.logGroup.GetLogs()))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests++
+	s.latencyMs += costMs
+	s.sentBatches++
+	s.sentLogs += logs
+	s.sentBytes += producerBatch.totalDataSize
+	d := s.destination(producerBatch.getProject(), producerBatch.getLogstore())
+	d.SentLogs += logs
+	d.SentBytes += producerBatch.totalDataSize
+}
+
+func (s *producerStats) recordError(producerBatch *ProducerBatch, err error, costMs int64) {
+	attempt := newErrorAttempt(err, GetTimeMs(time.Now().UnixNano()), costMs)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests++
+	s.latencyMs += costMs
+	s.destination(producerBatch.getProject(), producerBatch.getLogstore()).LastError = attempt
+}
+
+func (s *producerStats) recordFailed(producerBatch *ProducerBatch) {
+	logs := int64(len(producerBatch.logGroup.GetLogs()))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failedBatches++
+	s.failedLogs += logs
+	s.failedBytes += producerBatch.totalDataSize
+	d := s.destination(producerBatch.getProject(), producerBatch.getLogstore())
+	d.FailedLogs += logs
+	d.FailedBytes += producerBatch.totalDataSize
+}
+
+// Stats returns a snapshot of the state of the producer, it is safe to call
+// at any time, eg. from a health check.
+func (producer *Producer) Stats() *ProducerStats {
+	stats := &ProducerStats{
+		BufferedBytes:    atomic.LoadInt64(&producer.producerLogGroupSize),
+		InFlightRequests: atomic.LoadInt64(&producer.threadPool.ioworker.taskCount),
+	}
+	pending := map[destinationKey]int{}
+	countPending := func(producerBatch *ProducerBatch) {
+		pending[destinationKey{producerBatch.getProject(), producerBatch.getLogstore()}]++
+	}
+
+	producer.logAccumulator.lock.RLock()
+	for _, producerBatch := range producer.logAccumulator.logGroupData {
+		countPending(producerBatch)
+	}
+	producer.logAccumulator.lock.RUnlock()
+
+	producer.threadPool.forEachTask(func(producerBatch *ProducerBatch) {
+		stats.QueuedBatches++
+		countPending(producerBatch)
+	})
+
+	retryQueue := producer.threadPool.ioworker.retryQueue
+	retryQueue.mutex.Lock()
+	stats.RetryQueueDepth = retryQueue.Len()
+	for _, producerBatch := range retryQueue.batch {
+		countPending(producerBatch)
+	}
+	retryQueue.mutex.Unlock()
+
+	if producer.spool != nil {
+		producer.spool.lock.Lock()
+		stats.SpooledBytes = producer.spool.totalBytes
+		producer.spool.lock.Unlock()
+	}
+
+	s := producer.stats
+	s.lock.Lock()
+	stats.SentBatches = s.sentBatches
+	stats.SentLogs = s.sentLogs
+	stats.SentBytes = s.sentBytes
+	stats.FailedBatches = s.failedBatches
+	stats.FailedLogs = s.failedLogs
+	stats.FailedBytes = s.failedBytes
+	stats.Requests = s.requests
+	if s.sentBatches > 0 {
+		stats.AvgBatchBytes = float64(s.sentBytes) / float64(s.sentBatches)
+		stats.AvgBatchLogs = float64(s.sentLogs) / float64(s.sentBatches)
+	}
+	if s.requests > 0 {
+		stats.AvgLatencyMs = float64(s.latencyMs) / float64(s.requests)
+	}
+	for key := range pending {
+		// a destination whose batches are not sent yet
+		s.destination(key.project, key.logstore)
+	}
+	for key, d := range s.destinations {
+		copied := *d
+		copied.PendingBatches = pending[key]
+		copied.Suspended = producer.destinations.isSuspended(key)
+		producer.destinations.lock.Lock()
+		if state, ok := producer.destinations.states[key]; ok {
+			copied.BufferedBytes = state.bytes
+			copied.InFlightRequests = state.inflight
+			if producer.destinations.adaptive {
+				copied.ConcurrencyLimit = int(state.limit)
+			}
+		}
+		producer.destinations.lock.Unlock()
+		stats.Destinations = append(stats.Destinations, &copied)
+	}
+	s.lock.Unlock()
+
+	sort.Slice(stats.Destinations, func(i, j int) bool {
+		if stats.Destinations[i].Project != stats.Destinations[j].Project {
+			return stats.Destinations[i].Project < stats.Destinations[j].Project
+		}
+		return stats.Destinations[i].Logstore < stats.Destinations[j].Logstore
+	})
+	return stats
+}
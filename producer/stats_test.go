@@ -0,0 +1,76 @@
+package producer
+
+import (
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+func TestProducerStats(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.LingerMs = 100
+	config.Retries = 0
+	producer := InitProducer(config)
+	client := &flakyClient{}
+	producer.threadPool.ioworker.client = client
+
+	log := GenerateLog(uint32(time.Now().Unix()), map[string]string{"content": "test"})
+	producer.SendLog("p", "l1", "", "", log)
+	producer.SendLog("p", "l2", "", "", log)
+	stats := producer.Stats()
+	if stats.BufferedBytes <= 0 || len(stats.Destinations) != 2 || stats.Destinations[0].Logstore != "l1" || stats.Destinations[0].PendingBatches != 1 {
+		t.Fatalf("Stats() = %+v, want 2 pending batches", stats)
+	}
+
+	producer.Start()
+	waitStats(t, producer, func(stats *ProducerStats) bool { return stats.FailedBatches == 2 })
+	client.setHealthy()
+	producer.SendLogList("p", "l1", "", "", []*sls.Log{log, log, log})
+	waitStats(t, producer, func(stats *ProducerStats) bool { return stats.SentBatches == 1 })
+	producer.SafeClose()
+
+	stats = producer.Stats()
+	if stats.BufferedBytes != 0 || stats.FailedLogs != 2 || stats.SentLogs != 3 || stats.Requests != 3 || stats.AvgBatchLogs != 3 {
+		t.Errorf("Stats() = %+v, want 2 failed and 3 sent logs", stats)
+	}
+	l1 := stats.Destinations[0]
+	if l1.SentLogs != 3 || l1.FailedLogs != 1 || l1.PendingBatches != 0 || l1.LastError == nil || l1.LastError.ErrorCode != "BadGateway" {
+		t.Errorf("Stats() of l1 = %+v", l1)
+	}
+}
+
+func waitStats(t *testing.T, producer *Producer, done func(*ProducerStats) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done(producer.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Stats() = %+v", producer.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProducerStatsClose(t *testing.T) {
+	config := GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.LingerMs = 100
+	config.BaseRetryBackoffMs = 60000
+	config.MaxRetryBackoffMs = 60000
+	producer := InitProducer(config)
+	client := &flakyClient{}
+	producer.threadPool.ioworker.client = client
+	producer.Start()
+
+	log := GenerateLog(uint32(time.Now().Unix()), map[string]string{"content": "test"})
+	producer.SendLog("p", "l", "", "", log)
+	waitStats(t, producer, func(stats *ProducerStats) bool { return stats.Destinations[0].LastError != nil })
+	// the batch waiting for a retry fails when closing
+	producer.SafeClose()
+
+	stats := producer.Stats()
+	if stats.BufferedBytes != 0 || stats.FailedBatches != 1 || stats.Destinations[0].PendingBatches != 0 {
+		t.Errorf("Stats() = %+v, want a failed batch", stats)
+	}
+}
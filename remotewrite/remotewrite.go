@@ -0,0 +1,277 @@
+// Package remotewrite receives Prometheus remote write requests and writes
+// the samples into a MetricStore, so that Prometheus, or an agent that speaks
+// the remote write protocol, can use a MetricStore as its remote storage.
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	maxRequestBodySize = 32 * 1024 * 1024
+	// the max number of logs of one SendLogListContext call
+	maxSendLogCount = 4096
+	// the max number of the skipped series in a response
+	maxReportedSeries = 10
+)
+
+// Sample is one sample of a series, Timestamp is in unix milliseconds.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// TimeSeries is a series of a WriteRequest, the metric name is the __name__ label.
+type TimeSeries struct {
+	Labels  map[string]string
+	Samples []Sample
+}
+
+// Sender writes logs into a logstore, it is implemented by *producer.Producer,
+// which must be created with UseMetricStoreURL.
+type Sender interface {
+	SendLogListContext(ctx context.Context, project, logstore, topic, source string, logList []*sls.Log, callback producer.CallBack) error
+}
+
+// Handler serves the Prometheus remote write endpoint. Requests are snappy
+// compressed protobuf WriteRequests, exemplars, histograms and metadata are
+// ignored.
+//
+// Every sample is converted with sls.MetricPoint. The series that cannot be
+// converted, eg. with a label value containing |, are skipped, and like the
+// Prometheus server the handler writes the other series and responds 400 with
+// the skipped ones, which Prometheus does not retry. The handler waits for room
+// in the sender until the request is done and then responds 503, if the sender
+// fails it responds 500. Prometheus retries both, samples that were already
+// handed over may then be written twice.
+type Handler struct {
+	sender   Sender
+	project  string
+	logstore string
+}
+
+// NewHandler creates a Handler that writes into a MetricStore.
+func NewHandler(sender Sender, project, metricStore string) *Handler {
+	return &Handler{sender: sender, project: project, logstore: metricStore}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	compressed, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := DecodeWriteRequest(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logs, errs := ToLogs(series)
+	for len(logs) > 0 {
+		n := len(logs)
+		if n > maxSendLogCount {
+			n = maxSendLogCount
+		}
+		if err := h.sender.SendLogListContext(r.Context(), h.project, h.logstore, "", "", logs[:n], nil); err != nil {
+			code := http.StatusInternalServerError
+			var blocked *producer.BlockedError
+			if errors.As(err, &blocked) {
+				code = http.StatusServiceUnavailable
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		logs = logs[n:]
+	}
+	if len(errs) > 0 {
+		http.Error(w, skippedMessage(errs), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// skippedMessage reports the skipped series, the first maxReportedSeries of them in detail
+func skippedMessage(errs []error) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "skipped %d invalid series", len(errs))
+	for i, err := range errs {
+		if i == maxReportedSeries {
+			b.WriteString("\n...")
+			break
+		}
+		b.WriteString("\n")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// ToLogs converts series into MetricStore logs, one log per sample. The series
+// that cannot be converted are skipped, errs has one error for each of them.
+func ToLogs(series []*TimeSeries) (logs []*sls.Log, errs []error) {
+	for _, ts := range series {
+		seriesLogs, err := seriesToLogs(ts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("series %s: %w", seriesName(ts), err))
+			continue
+		}
+		logs = append(logs, seriesLogs...)
+	}
+	return logs, errs
+}
+
+// seriesName formats the labels of a series like Prometheus, eg. up{job="node"}
+func seriesName(ts *TimeSeries) string {
+	names := make([]string, 0, len(ts.Labels))
+	for name := range ts.Labels {
+		if name != sls.MetricNameKey {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(ts.Labels[sls.MetricNameKey])
+	b.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "%s=%q", name, ts.Labels[name])
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func seriesToLogs(ts *TimeSeries) ([]*sls.Log, error) {
+	logs := make([]*sls.Log, 0, len(ts.Samples))
+	for _, sample := range ts.Samples {
+		point := sls.NewMetricPoint(ts.Labels[sls.MetricNameKey]).
+			TimeNano(sample.Timestamp * 1e6).
+			Value(sample.Value)
+		for name, value := range ts.Labels {
+			if name != sls.MetricNameKey {
+				point.Label(name, value)
+			}
+		}
+		log, err := point.Log()
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
+
+// DecodeWriteRequest decodes the series of an uncompressed prometheus.WriteRequest.
+func DecodeWriteRequest(data []byte) ([]*TimeSeries, error) {
+	var series []*TimeSeries
+	err := decodeMessage(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		ts := &TimeSeries{Labels: map[string]string{}}
+		series = append(series, ts)
+		return decodeMessage(value, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+			if typ != protowire.BytesType {
+				return nil
+			}
+			switch num {
+			case 1:
+				var name, labelValue string
+				err := decodeMessage(value, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+					switch {
+					case num == 1 && typ == protowire.BytesType:
+						name = string(value)
+					case num == 2 && typ == protowire.BytesType:
+						labelValue = string(value)
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				if _, ok := ts.Labels[name]; ok {
+					return fmt.Errorf("duplicate label %s", name)
+				}
+				ts.Labels[name] = labelValue
+			case 2:
+				var sample Sample
+				err := decodeMessage(value, func(num protowire.Number, typ protowire.Type, _ []byte, v uint64) error {
+					switch {
+					case num == 1 && typ == protowire.Fixed64Type:
+						sample.Value = math.Float64frombits(v)
+					case num == 2 && typ == protowire.VarintType:
+						sample.Timestamp = int64(v)
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				ts.Samples = append(ts.Samples, sample)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+// decodeMessage calls fn for every field of a message, value is set for the
+// bytes wire type and v for the varint and fixed wire types
+func decodeMessage(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte, v uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var value []byte
+		var v uint64
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(data)
+			v = uint64(v32)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(data)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := fn(num, typ, value, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+	"github.com/gogo/protobuf/proto"
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func appendBytesField(b []byte, num protowire.Number, value []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+func encodeLabel(name, value string) []byte {
+	b := appendBytesField(nil, 1, []byte(name))
+	return appendBytesField(b, 2, []byte(value))
+}
+
+func encodeSample(value float64, timestamp int64) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(value))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(timestamp))
+}
+
+// encodeSeries encodes a series with two samples as a field of a WriteRequest
+func encodeSeries(labels ...string) []byte {
+	var ts []byte
+	for i := 0; i+1 < len(labels); i += 2 {
+		ts = appendBytesField(ts, 1, encodeLabel(labels[i], labels[i+1]))
+	}
+	ts = appendBytesField(ts, 2, encodeSample(1.5, 1700000000123))
+	ts = appendBytesField(ts, 2, encodeSample(2, 1700000001123))
+	return appendBytesField(nil, 1, ts)
+}
+
+func writeRequest(labels ...string) []byte {
+	return snappy.Encode(nil, encodeSeries(labels...))
+}
+
+type fakeSender struct {
+	logs []*sls.Log
+	err  error
+}
+
+func (s *fakeSender) SendLogListContext(ctx context.Context, project, logstore, topic, source string, logList []*sls.Log, callback producer.CallBack) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.logs = append(s.logs, logList...)
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     []byte
+		sendErr  error
+		wantCode int
+		wantLogs int
+	}{
+		{"ok", writeRequest("__name__", "up", "job", "node", "instance", "a:9100"), nil, http.StatusNoContent, 2},
+		{"not snappy", []byte("plain"), nil, http.StatusBadRequest, 0},
+		{"bad label", writeRequest("__name__", "up", "a-b", "1"), nil, http.StatusBadRequest, 0},
+		{"no name", writeRequest("job", "node"), nil, http.StatusBadRequest, 0},
+		{"bad series skipped", snappy.Encode(nil, append(encodeSeries("__name__", "up", "job", "a|b"), encodeSeries("__name__", "up")...)), nil, http.StatusBadRequest, 2},
+		{"send error", writeRequest("__name__", "up"), errors.New("timeout"), http.StatusInternalServerError, 0},
+	}
+	for _, tt := range tests {
+		sender := &fakeSender{err: tt.sendErr}
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		NewHandler(sender, "p", "m").ServeHTTP(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("%q. ServeHTTP() code = %v, want %v, body %s", tt.name, w.Code, tt.wantCode, w.Body.String())
+		}
+		if len(sender.logs) != tt.wantLogs {
+			t.Errorf("%q. ServeHTTP() sent %d logs, want %v", tt.name, len(sender.logs), tt.wantLogs)
+		}
+	}
+}
+
+func TestToLogs(t *testing.T) {
+	data, _ := snappy.Decode(nil, writeRequest("__name__", "up", "job", "node", "instance", "a:9100"))
+	series, err := DecodeWriteRequest(data)
+	if err != nil {
+		t.Fatalf("DecodeWriteRequest() error = %v", err)
+	}
+	logs, errs := ToLogs(series)
+	if len(errs) != 0 {
+		t.Fatalf("ToLogs() errs = %v", errs)
+	}
+	want := `Time:1700000000 ` +
+		`Contents:<Key:"__name__" Value:"up" > ` +
+		`Contents:<Key:"__labels__" Value:"instance#$#a:9100|job#$#node" > ` +
+		`Contents:<Key:"__time_nano__" Value:"1700000000123000000" > ` +
+		`Contents:<Key:"__value__" Value:"1.5" > `
+	if len(logs) != 2 || logs[0].String() != want {
+		t.Errorf("ToLogs() = %v, want %v", logs, want)
+	}
+}
+
+func TestHandlerSkippedSeries(t *testing.T) {
+	body := snappy.Encode(nil, append(encodeSeries("__name__", "up", "job", "a|b"), encodeSeries("__name__", "up", "job", "node")...))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	NewHandler(&fakeSender{}, "p", "m").ServeHTTP(w, req)
+	if message := w.Body.String(); !strings.Contains(message, "skipped 1 invalid series") || !strings.Contains(message, `up{job="a|b"}`) {
+		t.Errorf("ServeHTTP() body = %s, want the skipped series", message)
+	}
+}
+
+func TestHandlerContext(t *testing.T) {
+	config := producer.GetDefaultProducerConfig()
+	config.Endpoint = "cn-hangzhou.log.aliyuncs.com"
+	config.MaxBlockSec = 60
+	config.TotalSizeLnBytes = 1
+	p := producer.InitProducer(config)
+	log := &sls.Log{Time: proto.Uint32(1), Contents: []*sls.LogContent{{Key: proto.String("k"), Value: proto.String("v")}}}
+	if err := p.SendLog("p", "m", "", "", log); err != nil {
+		t.Fatalf("SendLog() error = %v", err)
+	}
+
+	// the full producer is waited for until the request is done, not MaxBlockSec
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(writeRequest("__name__", "up"))).WithContext(ctx)
+	w := httptest.NewRecorder()
+	start := time.Now()
+	NewHandler(p, "p", "m").ServeHTTP(w, req)
+	if elapsed := time.Since(start); w.Code != http.StatusServiceUnavailable || elapsed > 5*time.Second {
+		t.Errorf("ServeHTTP() code = %v after %v, want %v", w.Code, elapsed, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,222 @@
+package sls
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	ShardStatusReadWrite = "readwrite"
+	ShardStatusReadOnly  = "readonly"
+
+	// MinShardHashKey and MaxShardHashKey are the bounds of the hash key range of a logstore
+	MinShardHashKey = "00000000000000000000000000000000"
+	MaxShardHashKey = "ffffffffffffffffffffffffffffffff"
+)
+
+// ShardTopologyIssue is a gap or an overlap of the key ranges of readwrite shards.
+type ShardTopologyIssue struct {
+	// Kind is "gap" or "overlap"
+	Kind string
+	// BeginKey and EndKey is the key range [BeginKey, EndKey) of the issue
+	BeginKey string
+	EndKey   string
+	// ShardIDs are the shards around a gap or in an overlap
+	ShardIDs []int
+}
+
+func (i ShardTopologyIssue) String() string {
+	return fmt.Sprintf("%s [%s, %s) shards %v", i.Kind, i.BeginKey, i.EndKey, i.ShardIDs)
+}
+
+// ShardTopology interprets the key ranges and status returned by ListShards.
+// Readwrite shards own the hash key space, readonly shards are the parents of
+// a split or merge and only hold old data.
+type ShardTopology struct {
+	shards   []*Shard // ordered by shard id
+	byID     map[int]*Shard
+	active   []*Shard // readwrite shards ordered by begin key
+	parents  map[int][]int
+	children map[int][]int
+}
+
+// NewShardTopology builds a topology from the shards of one logstore.
+func NewShardTopology(shards []*Shard) (*ShardTopology, error) {
+	t := &ShardTopology{
+		byID:     make(map[int]*Shard, len(shards)),
+		parents:  map[int][]int{},
+		children: map[int][]int{},
+	}
+	for _, shard := range shards {
+		begin, err := normalizeHashKey(shard.InclusiveBeginKey)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %w", shard.ShardID, err)
+		}
+		end, err := normalizeHashKey(shard.ExclusiveBeginKey)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %w", shard.ShardID, err)
+		}
+		if begin >= end {
+			return nil, fmt.Errorf("shard %d: invalid key range [%s, %s)", shard.ShardID, begin, end)
+		}
+		if _, ok := t.byID[shard.ShardID]; ok {
+			return nil, fmt.Errorf("duplicated shard %d", shard.ShardID)
+		}
+		s := *shard
+		s.InclusiveBeginKey, s.ExclusiveBeginKey = begin, end
+		t.shards = append(t.shards, &s)
+		t.byID[s.ShardID] = &s
+		if strings.EqualFold(s.Status, ShardStatusReadWrite) {
+			t.active = append(t.active, &s)
+		}
+	}
+	sort.Slice(t.shards, func(i, j int) bool { return t.shards[i].ShardID < t.shards[j].ShardID })
+	sort.Slice(t.active, func(i, j int) bool { return t.active[i].InclusiveBeginKey < t.active[j].InclusiveBeginKey })
+	t.buildLineage()
+	return t, nil
+}
+
+// LoadShardTopology lists the shards of a logstore and builds its topology.
+func LoadShardTopology(client ClientInterface, project, logstore string) (*ShardTopology, error) {
+	shards, err := client.ListShards(project, logstore)
+	if err != nil {
+		return nil, err
+	}
+	return NewShardTopology(shards)
+}
+
+// buildLineage links every readonly shard to the shards created from it.
+// Shard ids are allocated increasingly, so a shard created by a split or merge
+// has a larger id than its parents. C is a direct child of P if their ranges
+// overlap, P is readonly, C has a larger id, and no readonly shard between
+// them overlaps both.
+func (t *ShardTopology) buildLineage() {
+	for i, parent := range t.shards {
+		if strings.EqualFold(parent.Status, ShardStatusReadWrite) {
+			continue
+		}
+		for j := i + 1; j < len(t.shards); j++ {
+			child := t.shards[j]
+			if !keyRangeOverlaps(parent, child) {
+				continue
+			}
+			direct := true
+			for k := i + 1; k < j; k++ {
+				middle := t.shards[k]
+				if !strings.EqualFold(middle.Status, ShardStatusReadWrite) &&
+					keyRangeOverlaps(parent, middle) && keyRangeOverlaps(middle, child) {
+					direct = false
+					break
+				}
+			}
+			if direct {
+				t.children[parent.ShardID] = append(t.children[parent.ShardID], child.ShardID)
+				t.parents[child.ShardID] = append(t.parents[child.ShardID], parent.ShardID)
+			}
+		}
+	}
+}
+
+func keyRangeOverlaps(a, b *Shard) bool {
+	return a.InclusiveBeginKey < b.ExclusiveBeginKey && b.InclusiveBeginKey < a.ExclusiveBeginKey
+}
+
+// normalizeHashKey returns the lower case 32 hex digits of a hash key,
+// shorter keys are padded with zero on the right.
+func normalizeHashKey(key string) (string, error) {
+	key = strings.ToLower(key)
+	if len(key) == 0 || len(key) > len(MaxShardHashKey) {
+		return "", fmt.Errorf("invalid hash key %q", key)
+	}
+	if _, err := hex.DecodeString(key + strings.Repeat("0", len(key)%2)); err != nil {
+		return "", fmt.Errorf("invalid hash key %q", key)
+	}
+	return key + MinShardHashKey[len(key):], nil
+}
+
+// Shards returns all shards ordered by shard id.
+func (t *ShardTopology) Shards() []*Shard {
+	return t.shards
+}
+
+// ActiveShards returns the readwrite shards ordered by key range.
+func (t *ShardTopology) ActiveShards() []*Shard {
+	return t.active
+}
+
+// Shard returns the shard with the id.
+func (t *ShardTopology) Shard(shardID int) (*Shard, bool) {
+	s, ok := t.byID[shardID]
+	return s, ok
+}
+
+// ShardForHashKey returns the readwrite shard that owns the hash key, such as
+// the one passed to PutLogsWithHashKey or the producer.
+func (t *ShardTopology) ShardForHashKey(hashKey string) (*Shard, error) {
+	key, err := normalizeHashKey(hashKey)
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(t.active), func(i int) bool {
+		return t.active[i].ExclusiveBeginKey > key
+	})
+	// the max key is owned by the last shard
+	if i == len(t.active) && key == MaxShardHashKey && len(t.active) > 0 {
+		i--
+	}
+	if i < len(t.active) && t.active[i].InclusiveBeginKey <= key {
+		return t.active[i], nil
+	}
+	return nil, fmt.Errorf("no readwrite shard owns hash key %s", key)
+}
+
+// ShardForKey returns the readwrite shard that owns the MD5 of key.
+func (t *ShardTopology) ShardForKey(key string) (*Shard, error) {
+	sum := md5.Sum([]byte(key))
+	return t.ShardForHashKey(hex.EncodeToString(sum[:]))
+}
+
+// Parents returns the ids of the shards that the shard was split or merged
+// from, nil for an original shard or if the parents have expired.
+func (t *ShardTopology) Parents(shardID int) []int {
+	return t.parents[shardID]
+}
+
+// Children returns the ids of the shards created by splitting or merging the shard.
+func (t *ShardTopology) Children(shardID int) []int {
+	return t.children[shardID]
+}
+
+// Check returns the gaps and overlaps in the key ranges of the readwrite shards,
+// it is empty for a consistent topology.
+func (t *ShardTopology) Check() []ShardTopologyIssue {
+	var issues []ShardTopologyIssue
+	if len(t.active) == 0 {
+		return []ShardTopologyIssue{{Kind: "gap", BeginKey: MinShardHashKey, EndKey: MaxShardHashKey}}
+	}
+	if first := t.active[0]; first.InclusiveBeginKey != MinShardHashKey {
+		issues = append(issues, ShardTopologyIssue{Kind: "gap", BeginKey: MinShardHashKey, EndKey: first.InclusiveBeginKey, ShardIDs: []int{first.ShardID}})
+	}
+	end, endShard := t.active[0].ExclusiveBeginKey, t.active[0]
+	for _, s := range t.active[1:] {
+		if s.InclusiveBeginKey > end {
+			issues = append(issues, ShardTopologyIssue{Kind: "gap", BeginKey: end, EndKey: s.InclusiveBeginKey, ShardIDs: []int{endShard.ShardID, s.ShardID}})
+		} else if s.InclusiveBeginKey < end {
+			overlapEnd := end
+			if s.ExclusiveBeginKey < overlapEnd {
+				overlapEnd = s.ExclusiveBeginKey
+			}
+			issues = append(issues, ShardTopologyIssue{Kind: "overlap", BeginKey: s.InclusiveBeginKey, EndKey: overlapEnd, ShardIDs: []int{endShard.ShardID, s.ShardID}})
+		}
+		if s.ExclusiveBeginKey > end {
+			end, endShard = s.ExclusiveBeginKey, s
+		}
+	}
+	if end != MaxShardHashKey {
+		issues = append(issues, ShardTopologyIssue{Kind: "gap", BeginKey: end, EndKey: MaxShardHashKey, ShardIDs: []int{endShard.ShardID}})
+	}
+	return issues
+}
@@ -0,0 +1,83 @@
+package sls
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func hashKeyPrefix(prefix string) string {
+	return prefix + MinShardHashKey[len(prefix):]
+}
+
+// shard 0 was split into 2 and 3, then 3 was merged with 1 into 4
+func splitMergeShards() []*Shard {
+	return []*Shard{
+		{ShardID: 0, Status: "readonly", InclusiveBeginKey: hashKeyPrefix("00"), ExclusiveBeginKey: hashKeyPrefix("80"), CreateTime: 100},
+		{ShardID: 1, Status: "readonly", InclusiveBeginKey: hashKeyPrefix("80"), ExclusiveBeginKey: MaxShardHashKey, CreateTime: 100},
+		{ShardID: 2, Status: "readwrite", InclusiveBeginKey: hashKeyPrefix("00"), ExclusiveBeginKey: hashKeyPrefix("40"), CreateTime: 200},
+		{ShardID: 3, Status: "readonly", InclusiveBeginKey: hashKeyPrefix("40"), ExclusiveBeginKey: hashKeyPrefix("80"), CreateTime: 200},
+		{ShardID: 4, Status: "readwrite", InclusiveBeginKey: hashKeyPrefix("40"), ExclusiveBeginKey: MaxShardHashKey, CreateTime: 300},
+	}
+}
+
+func TestShardTopologyHashKey(t *testing.T) {
+	topology, err := NewShardTopology(splitMergeShards())
+	assert.Nil(t, err)
+	assert.Len(t, topology.ActiveShards(), 2)
+
+	tests := []struct {
+		hashKey string
+		want    int
+	}{
+		{MinShardHashKey, 2},
+		{"3fffffffffffffffffffffffffffffff", 2},
+		{"40", 4},
+		{"7F000000000000000000000000000000", 4},
+		{MaxShardHashKey, 4},
+	}
+	for _, tt := range tests {
+		shard, err := topology.ShardForHashKey(tt.hashKey)
+		if assert.Nil(t, err, tt.hashKey) {
+			assert.Equal(t, tt.want, shard.ShardID, tt.hashKey)
+		}
+	}
+	_, err = topology.ShardForHashKey("not a hash key")
+	assert.NotNil(t, err)
+
+	// md5("a") = 0cc175b9c0f1b6a831c399e269772661
+	shard, err := topology.ShardForKey("a")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, shard.ShardID)
+}
+
+func TestShardTopologyLineage(t *testing.T) {
+	topology, err := NewShardTopology(splitMergeShards())
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 3}, topology.Children(0))
+	assert.Equal(t, []int{4}, topology.Children(1))
+	assert.Equal(t, []int{4}, topology.Children(3))
+	assert.Equal(t, []int{0}, topology.Parents(2))
+	assert.Equal(t, []int{1, 3}, topology.Parents(4))
+	assert.Nil(t, topology.Parents(0))
+	assert.Nil(t, topology.Children(2))
+	assert.Empty(t, topology.Check())
+}
+
+func TestShardTopologyCheck(t *testing.T) {
+	shards := []*Shard{
+		{ShardID: 0, Status: "readwrite", InclusiveBeginKey: hashKeyPrefix("10"), ExclusiveBeginKey: hashKeyPrefix("50")},
+		{ShardID: 1, Status: "readwrite", InclusiveBeginKey: hashKeyPrefix("40"), ExclusiveBeginKey: hashKeyPrefix("80")},
+		{ShardID: 2, Status: "readwrite", InclusiveBeginKey: hashKeyPrefix("90"), ExclusiveBeginKey: MaxShardHashKey},
+	}
+	topology, err := NewShardTopology(shards)
+	assert.Nil(t, err)
+	assert.Equal(t, []ShardTopologyIssue{
+		{Kind: "gap", BeginKey: MinShardHashKey, EndKey: hashKeyPrefix("10"), ShardIDs: []int{0}},
+		{Kind: "overlap", BeginKey: hashKeyPrefix("40"), EndKey: hashKeyPrefix("50"), ShardIDs: []int{0, 1}},
+		{Kind: "gap", BeginKey: hashKeyPrefix("80"), EndKey: hashKeyPrefix("90"), ShardIDs: []int{1, 2}},
+	}, topology.Check())
+
+	_, err = NewShardTopology([]*Shard{{ShardID: 0, InclusiveBeginKey: hashKeyPrefix("80"), ExclusiveBeginKey: hashKeyPrefix("40")}})
+	assert.NotNil(t, err)
+}
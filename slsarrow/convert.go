@@ -0,0 +1,270 @@
+// Package slsarrow converts between LogGroupList and Apache Arrow record batches.
+//
+// It is a separate module, so that the Arrow dependency is only required by
+// programs that use it.
+package slsarrow
+
+import (
+	"fmt"
+	"strings"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+)
+
+const (
+	FieldTime       = "__time__"
+	FieldTimeNsPart = "__time_ns_part__"
+	FieldTopic      = "__topic__"
+	FieldSource     = "__source__"
+	FieldTagPrefix  = "__tag__:"
+)
+
+// Options configures the conversion from logs to Arrow.
+type Options struct {
+	// TypeHints are the Arrow types of content keys, keys without a hint are strings.
+	// Supported types are the integer, float, boolean, string and binary types.
+	TypeHints map[string]arrow.DataType
+	// Schema fixes the schema instead of inferring it from the keys. Fields of
+	// the schema named like the metadata columns are filled with the metadata.
+	Schema *arrow.Schema
+	// Strict returns an error when a value can not be parsed as its type, by default the value is null
+	Strict bool
+	// Allocator defaults to memory.DefaultAllocator
+	Allocator memory.Allocator
+}
+
+func (o *Options) allocator() memory.Allocator {
+	if o.Allocator != nil {
+		return o.Allocator
+	}
+	return memory.DefaultAllocator
+}
+
+// InferSchema returns the schema of a LogGroupList: __time__, __time_ns_part__,
+// __topic__, __source__, then __tag__:* columns and content keys in the order they
+// are first seen. All columns are nullable.
+func InferSchema(logGroupList *sls.LogGroupList, typeHints map[string]arrow.DataType) *arrow.Schema {
+	fields := []arrow.Field{
+		{Name: FieldTime, Type: arrow.PrimitiveTypes.Uint32, Nullable: true},
+		{Name: FieldTimeNsPart, Type: arrow.PrimitiveTypes.Uint32, Nullable: true},
+		{Name: FieldTopic, Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: FieldSource, Type: arrow.BinaryTypes.String, Nullable: true},
+	}
+	seen := map[string]bool{}
+	for _, f := range fields {
+		seen[f.Name] = true
+	}
+	var tags, keys []string
+	for _, lg := range logGroupList.LogGroups {
+		for _, tag := range lg.LogTags {
+			name := FieldTagPrefix + tag.GetKey()
+			if !seen[name] {
+				seen[name] = true
+				tags = append(tags, name)
+			}
+		}
+		for _, log := range lg.Logs {
+			for _, c := range log.Contents {
+				if !seen[c.GetKey()] {
+					seen[c.GetKey()] = true
+					keys = append(keys, c.GetKey())
+				}
+			}
+		}
+	}
+	for _, name := range tags {
+		fields = append(fields, arrow.Field{Name: name, Type: arrow.BinaryTypes.String, Nullable: true})
+	}
+	for _, key := range keys {
+		var dataType arrow.DataType = arrow.BinaryTypes.String
+		if t, ok := typeHints[key]; ok {
+			dataType = t
+		}
+		fields = append(fields, arrow.Field{Name: key, Type: dataType, Nullable: true})
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// ToRecord converts a LogGroupList into one record batch, one row per log.
+// The caller must Release the record.
+func ToRecord(logGroupList *sls.LogGroupList, options *Options) (arrow.Record, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	schema := options.Schema
+	if schema == nil {
+		schema = InferSchema(logGroupList, options.TypeHints)
+	}
+	b := array.NewRecordBuilder(options.allocator(), schema)
+	defer b.Release()
+	if err := appendLogGroupList(b, logGroupList, options.Strict); err != nil {
+		return nil, err
+	}
+	return b.NewRecord(), nil
+}
+
+func appendLogGroupList(b *array.RecordBuilder, logGroupList *sls.LogGroupList, strict bool) error {
+	schema := b.Schema()
+	values := make([]*string, len(schema.Fields()))
+	index := make(map[string]int, len(values))
+	for i, f := range schema.Fields() {
+		index[f.Name] = i
+	}
+	set := func(name string, value *string) {
+		if i, ok := index[name]; ok {
+			values[i] = value
+		}
+	}
+	for _, lg := range logGroupList.LogGroups {
+		for _, log := range lg.Logs {
+			for i := range values {
+				values[i] = nil
+			}
+			set(FieldTopic, lg.Topic)
+			set(FieldSource, lg.Source)
+			for _, tag := range lg.LogTags {
+				set(FieldTagPrefix+tag.GetKey(), tag.Value)
+			}
+			if log.Time != nil {
+				t := fmt.Sprint(*log.Time)
+				set(FieldTime, &t)
+			}
+			if log.TimeNs != nil {
+				ns := fmt.Sprint(*log.TimeNs)
+				set(FieldTimeNsPart, &ns)
+			}
+			for _, c := range log.Contents {
+				set(c.GetKey(), c.Value)
+			}
+			for i, value := range values {
+				if err := appendValue(b.Field(i), value, strict); err != nil {
+					return fmt.Errorf("column %s: %w", schema.Field(i).Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func appendValue(builder array.Builder, value *string, strict bool) error {
+	if value == nil {
+		builder.AppendNull()
+		return nil
+	}
+	switch builder := builder.(type) {
+	case *array.StringBuilder:
+		builder.Append(*value)
+		return nil
+	case *array.BinaryBuilder:
+		builder.Append([]byte(*value))
+		return nil
+	}
+	v := strings.TrimSpace(*value)
+	if v == "" {
+		builder.AppendNull()
+		return nil
+	}
+	length := builder.Len()
+	if err := builder.AppendValueFromString(v); err != nil {
+		if strict {
+			return err
+		}
+		// some builders append a null on error, some do not
+		if builder.Len() == length {
+			builder.AppendNull()
+		}
+	}
+	return nil
+}
+
+// RecordReader converts a stream of LogGroupLists into record batches with the same schema.
+// If Options.Schema is nil, the schema is inferred from the first list, and
+// keys first seen in later lists are dropped.
+type RecordReader struct {
+	next    func() (*sls.LogGroupList, error)
+	options Options
+	schema  *arrow.Schema
+	record  arrow.Record
+	err     error
+	refs    int64
+}
+
+// NewRecordReader creates a RecordReader, next returns the next list, or nil at the end of the stream.
+func NewRecordReader(next func() (*sls.LogGroupList, error), options *Options) *RecordReader {
+	r := &RecordReader{next: next, refs: 1}
+	if options != nil {
+		r.options = *options
+	}
+	r.schema = r.options.Schema
+	return r
+}
+
+// Schema returns the schema, it is nil before the first call of Next if it is inferred.
+func (r *RecordReader) Schema() *arrow.Schema {
+	return r.schema
+}
+
+// Next converts the next list, it returns false at the end of the stream or on error.
+// Empty lists are skipped.
+func (r *RecordReader) Next() bool {
+	if r.record != nil {
+		r.record.Release()
+		r.record = nil
+	}
+	for r.err == nil {
+		list, err := r.next()
+		if err != nil {
+			r.err = err
+			return false
+		}
+		if list == nil {
+			return false
+		}
+		if r.schema == nil {
+			r.schema = InferSchema(list, r.options.TypeHints)
+		}
+		options := r.options
+		options.Schema = r.schema
+		record, err := ToRecord(list, &options)
+		if err != nil {
+			r.err = err
+			return false
+		}
+		if record.NumRows() == 0 {
+			record.Release()
+			continue
+		}
+		r.record = record
+		return true
+	}
+	return false
+}
+
+// Record returns the current record, it is released by the next call of Next or by Release.
+func (r *RecordReader) Record() arrow.Record {
+	return r.record
+}
+
+// Err returns the error that stopped the stream.
+func (r *RecordReader) Err() error {
+	return r.err
+}
+
+// Retain increases the reference count.
+func (r *RecordReader) Retain() {
+	r.refs++
+}
+
+// Release decreases the reference count and releases the current record when it reaches zero.
+func (r *RecordReader) Release() {
+	r.refs--
+	if r.refs == 0 && r.record != nil {
+		r.record.Release()
+		r.record = nil
+	}
+}
+
+var _ array.RecordReader = (*RecordReader)(nil)
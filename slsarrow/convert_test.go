@@ -0,0 +1,175 @@
+package slsarrow
+
+import (
+	"reflect"
+	"testing"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/gogo/protobuf/proto"
+)
+
+func makeLog(t uint32, kv ...string) *sls.Log {
+	log := &sls.Log{Time: proto.Uint32(t)}
+	for i := 0; i+1 < len(kv); i += 2 {
+		log.Contents = append(log.Contents, &sls.LogContent{Key: proto.String(kv[i]), Value: proto.String(kv[i+1])})
+	}
+	return log
+}
+
+func makeLogGroupList() *sls.LogGroupList {
+	return &sls.LogGroupList{LogGroups: []*sls.LogGroup{
+		{
+			Topic:   proto.String("nginx"),
+			Source:  proto.String("10.0.0.1"),
+			LogTags: []*sls.LogTag{{Key: proto.String("host"), Value: proto.String("web-1")}},
+			Logs: []*sls.Log{
+				makeLog(100, "status", "200", "latency", "0.5", "path", "/a"),
+				makeLog(101, "status", "x", "path", "/b"),
+			},
+		},
+		{
+			Topic: proto.String("app"),
+			Logs:  []*sls.Log{makeLog(102, "status", "500", "level", "INFO")},
+		},
+	}}
+}
+
+func TestToRecord(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	record, err := ToRecord(makeLogGroupList(), &Options{
+		TypeHints: map[string]arrow.DataType{"status": arrow.PrimitiveTypes.Int64, "latency": arrow.PrimitiveTypes.Float64},
+		Allocator: mem,
+	})
+	if err != nil {
+		t.Fatalf("ToRecord() error = %v", err)
+	}
+	defer record.Release()
+
+	var names []string
+	for _, f := range record.Schema().Fields() {
+		names = append(names, f.Name)
+	}
+	want := []string{"__time__", "__time_ns_part__", "__topic__", "__source__", "__tag__:host", "status", "latency", "path", "level"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("ToRecord() schema = %v, want %v", names, want)
+	}
+	if record.NumRows() != 3 {
+		t.Fatalf("ToRecord() rows = %v, want 3", record.NumRows())
+	}
+	status := record.Column(5).(*array.Int64)
+	if status.Value(0) != 200 || !status.IsNull(1) || status.Value(2) != 500 {
+		t.Errorf("ToRecord() status = %v", status)
+	}
+	if latency := record.Column(6).(*array.Float64); latency.Value(0) != 0.5 || !latency.IsNull(2) {
+		t.Errorf("ToRecord() latency = %v", latency)
+	}
+	if tag := record.Column(4).(*array.String); tag.Value(1) != "web-1" || !tag.IsNull(2) {
+		t.Errorf("ToRecord() tag = %v", tag)
+	}
+	if tm := record.Column(0).(*array.Uint32); tm.Value(2) != 102 {
+		t.Errorf("ToRecord() time = %v", tm)
+	}
+
+	if _, err := ToRecord(makeLogGroupList(), &Options{
+		TypeHints: map[string]arrow.DataType{"status": arrow.PrimitiveTypes.Int64},
+		Strict:    true,
+		Allocator: mem,
+	}); err == nil {
+		t.Error("ToRecord() strict expect error")
+	}
+}
+
+func TestToLogGroupsRoundTrip(t *testing.T) {
+	input := makeLogGroupList()
+	record, err := ToRecord(input, nil)
+	if err != nil {
+		t.Fatalf("ToRecord() error = %v", err)
+	}
+	defer record.Release()
+	groups, err := ToLogGroups(record, 0)
+	if err != nil {
+		t.Fatalf("ToLogGroups() error = %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("ToLogGroups() = %d groups, want 2", len(groups))
+	}
+	for i, lg := range groups {
+		if lg.String() != input.LogGroups[i].String() {
+			t.Errorf("ToLogGroups() group %d = %v, want %v", i, lg, input.LogGroups[i])
+		}
+	}
+}
+
+func TestRecordReader(t *testing.T) {
+	lists := []*sls.LogGroupList{
+		makeLogGroupList(),
+		{},
+		{LogGroups: []*sls.LogGroup{{Logs: []*sls.Log{makeLog(103, "status", "404", "new_key", "dropped")}}}},
+	}
+	i := 0
+	reader := NewRecordReader(func() (*sls.LogGroupList, error) {
+		if i == len(lists) {
+			return nil, nil
+		}
+		i++
+		return lists[i-1], nil
+	}, nil)
+	defer reader.Release()
+	var rows []int64
+	for reader.Next() {
+		if !reader.Record().Schema().Equal(reader.Schema()) {
+			t.Errorf("RecordReader record schema differs")
+		}
+		rows = append(rows, reader.Record().NumRows())
+	}
+	if reader.Err() != nil {
+		t.Fatalf("RecordReader error = %v", reader.Err())
+	}
+	if !reflect.DeepEqual(rows, []int64{3, 1}) {
+		t.Errorf("RecordReader rows = %v, want [3 1]", rows)
+	}
+	if reader.Schema().HasField("new_key") {
+		t.Errorf("RecordReader schema changed")
+	}
+}
+
+type fakeClient struct {
+	sls.ClientInterface
+	posted []*sls.LogGroup
+}
+
+func (c *fakeClient) PostLogStoreLogsV2(project, logstore string, req *sls.PostLogStoreLogsRequest) error {
+	c.posted = append(c.posted, req.LogGroup)
+	return nil
+}
+
+func TestPost(t *testing.T) {
+	list := &sls.LogGroupList{LogGroups: []*sls.LogGroup{{Topic: proto.String("t")}}}
+	for i := 0; i < 5000; i++ {
+		list.LogGroups[0].Logs = append(list.LogGroups[0].Logs, makeLog(100, "a", "b"))
+	}
+	record, err := ToRecord(list, nil)
+	if err != nil {
+		t.Fatalf("ToRecord() error = %v", err)
+	}
+	defer record.Release()
+	client := &fakeClient{}
+	if err := Post(client, "p", "l", record, 0); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	count := 0
+	for _, lg := range client.posted {
+		if len(lg.Logs) > maxLogGroupLogCount || lg.GetTopic() != "t" {
+			t.Errorf("Post() group of %d logs, topic %v", len(lg.Logs), lg.GetTopic())
+		}
+		count += len(lg.Logs)
+	}
+	if len(client.posted) != 2 || count != 5000 {
+		t.Errorf("Post() = %d groups, %d logs", len(client.posted), count)
+	}
+}
@@ -0,0 +1,148 @@
+package slsarrow
+
+import (
+	"strconv"
+	"strings"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/gogo/protobuf/proto"
+)
+
+const (
+	maxLogGroupLogCount = 4096
+	maxLogGroupSize     = 5 * 1024 * 1024
+)
+
+// ToLogGroups converts a record batch into log groups, the reverse of ToRecord.
+// Rows with the same __topic__, __source__ and __tag__:* columns are put into
+// the same group, in the order the groups first appear. Other columns are log
+// contents in schema order, formatted as strings, null values are omitted.
+// Rows without a __time__ value use now, in unix seconds.
+func ToLogGroups(record arrow.Record, now uint32) ([]*sls.LogGroup, error) {
+	schema := record.Schema()
+	timeCol, timeNsCol, topicCol, sourceCol := -1, -1, -1, -1
+	var tagCols, contentCols []int
+	for i, f := range schema.Fields() {
+		switch {
+		case f.Name == FieldTime:
+			timeCol = i
+		case f.Name == FieldTimeNsPart:
+			timeNsCol = i
+		case f.Name == FieldTopic:
+			topicCol = i
+		case f.Name == FieldSource:
+			sourceCol = i
+		case strings.HasPrefix(f.Name, FieldTagPrefix):
+			tagCols = append(tagCols, i)
+		default:
+			contentCols = append(contentCols, i)
+		}
+	}
+
+	var groups []*sls.LogGroup
+	groupIndex := map[string]*sls.LogGroup{}
+	var keyBuilder strings.Builder
+	for row := 0; row < int(record.NumRows()); row++ {
+		keyBuilder.Reset()
+		topic, hasTopic := stringValue(record, topicCol, row)
+		source, hasSource := stringValue(record, sourceCol, row)
+		keyBuilder.WriteString(strconv.Quote(topic))
+		keyBuilder.WriteString(strconv.Quote(source))
+		var tags []*sls.LogTag
+		for _, col := range tagCols {
+			if value, ok := stringValue(record, col, row); ok {
+				key := strings.TrimPrefix(schema.Field(col).Name, FieldTagPrefix)
+				tags = append(tags, &sls.LogTag{Key: proto.String(key), Value: proto.String(value)})
+				keyBuilder.WriteString(strconv.Quote(key))
+				keyBuilder.WriteString(strconv.Quote(value))
+			}
+		}
+		lg, ok := groupIndex[keyBuilder.String()]
+		if !ok {
+			lg = &sls.LogGroup{LogTags: tags}
+			if hasTopic {
+				lg.Topic = proto.String(topic)
+			}
+			if hasSource {
+				lg.Source = proto.String(source)
+			}
+			groupIndex[keyBuilder.String()] = lg
+			groups = append(groups, lg)
+		}
+
+		log := &sls.Log{Time: proto.Uint32(now)}
+		if value, ok := stringValue(record, timeCol, row); ok {
+			t, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			log.Time = proto.Uint32(uint32(t))
+		}
+		if value, ok := stringValue(record, timeNsCol, row); ok {
+			ns, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			log.TimeNs = proto.Uint32(uint32(ns))
+		}
+		for _, col := range contentCols {
+			if value, ok := stringValue(record, col, row); ok {
+				log.Contents = append(log.Contents, &sls.LogContent{
+					Key:   proto.String(schema.Field(col).Name),
+					Value: proto.String(value),
+				})
+			}
+		}
+		lg.Logs = append(lg.Logs, log)
+	}
+	return groups, nil
+}
+
+// stringValue formats the value of a column, col is -1 for a missing column.
+func stringValue(record arrow.Record, col, row int) (string, bool) {
+	if col < 0 || record.Column(col).IsNull(row) {
+		return "", false
+	}
+	switch column := record.Column(col).(type) {
+	case *array.String:
+		return column.Value(row), true
+	case *array.LargeString:
+		return column.Value(row), true
+	case *array.Binary:
+		return string(column.Value(row)), true
+	}
+	return record.Column(col).ValueStr(row), true
+}
+
+// Post writes a record batch into a logstore with PostLogStoreLogsV2, large
+// groups are split to meet the limits of one request.
+func Post(client sls.ClientInterface, project, logstore string, record arrow.Record, now uint32) error {
+	groups, err := ToLogGroups(record, now)
+	if err != nil {
+		return err
+	}
+	for _, lg := range groups {
+		for _, part := range splitLogGroup(lg) {
+			if err := client.PostLogStoreLogsV2(project, logstore, &sls.PostLogStoreLogsRequest{LogGroup: part}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// splitLogGroup splits a group into groups of at most 4096 logs and 5MB.
+func splitLogGroup(lg *sls.LogGroup) []*sls.LogGroup {
+	if len(lg.Logs) <= maxLogGroupLogCount && (len(lg.Logs) <= 1 || lg.Size() <= maxLogGroupSize) {
+		return []*sls.LogGroup{lg}
+	}
+	half := len(lg.Logs) / 2
+	if half > maxLogGroupLogCount {
+		half = maxLogGroupLogCount
+	}
+	left, right := *lg, *lg
+	left.Logs, right.Logs = lg.Logs[:half], lg.Logs[half:]
+	return append(splitLogGroup(&left), splitLogGroup(&right)...)
+}
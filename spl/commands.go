@@ -0,0 +1,506 @@
+package spl
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// command is one stage of a pipeline, it returns false if the log is dropped.
+type command interface {
+	apply(r *record) (bool, error)
+}
+
+type commandParser func(p *parser) (command, error)
+
+var commandParsers map[string]commandParser
+
+func init() {
+	commandParsers = map[string]commandParser{
+		"where":          parseWhere,
+		"extend":         parseExtend,
+		"project":        parseProject,
+		"project-away":   parseProjectAway,
+		"project-rename": parseProjectRename,
+		"parse-regexp":   parseParseRegexp,
+		"parse-json":     parseParseJSON,
+		"parse-csv":      parseParseCSV,
+	}
+}
+
+// parseOptions consumes the -name=value options of a command.
+func (p *parser) parseOptions(allowed ...string) (map[string]string, error) {
+	options := map[string]string{}
+	for p.peek().kind == tokenOption {
+		t := p.next()
+		name, val := t.text[1:], ""
+		if idx := strings.IndexByte(name, '='); idx >= 0 {
+			name, val = name[:idx], name[idx+1:]
+		}
+		name = strings.ToLower(name)
+		known := false
+		for _, a := range allowed {
+			if a == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, p.errorf(t, "unsupported option -%s", name)
+		}
+		options[name] = val
+	}
+	return options, nil
+}
+
+type whereCommand struct {
+	cond expr
+}
+
+func parseWhere(p *parser) (command, error) {
+	cond, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &whereCommand{cond: cond}, nil
+}
+
+func (c *whereCommand) apply(r *record) (bool, error) {
+	v, err := c.cond.eval(r)
+	if err != nil {
+		return false, err
+	}
+	return v.truth(), nil
+}
+
+type extendCommand struct {
+	names []string
+	exprs []expr
+}
+
+func parseExtend(p *parser) (command, error) {
+	c := &extendCommand{}
+	for {
+		name, err := p.parseFieldName()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenOperator || t.text != "=" {
+			return nil, p.errorf(t, "expect = but got %v", t)
+		}
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.names = append(c.names, name)
+		c.exprs = append(c.exprs, x)
+		if p.peek().kind != tokenComma {
+			return c, nil
+		}
+		p.next()
+	}
+}
+
+// apply evaluates all expressions before assigning, so every expression sees the input log.
+func (c *extendCommand) apply(r *record) (bool, error) {
+	values := make([]value, len(c.exprs))
+	for i, x := range c.exprs {
+		v, err := x.eval(r)
+		if err != nil {
+			return false, err
+		}
+		values[i] = v
+	}
+	for i, v := range values {
+		if v.isNull() {
+			r.remove(c.names[i])
+		} else {
+			r.set(c.names[i], v.String())
+		}
+	}
+	return true, nil
+}
+
+// fieldMatcher matches a field name exactly, or by pattern with -wildcard.
+type fieldMatcher struct {
+	names    []string
+	wildcard bool
+}
+
+func (m *fieldMatcher) match(name string) bool {
+	for _, n := range m.names {
+		if m.wildcard {
+			if ok, _ := path.Match(n, name); ok {
+				return true
+			}
+		} else if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseFieldMatcher(p *parser) (*fieldMatcher, error) {
+	options, err := p.parseOptions("wildcard")
+	if err != nil {
+		return nil, err
+	}
+	_, wildcard := options["wildcard"]
+	m := &fieldMatcher{wildcard: wildcard}
+	for {
+		t := p.next()
+		if t.kind != tokenIdent && !(wildcard && t.kind == tokenString) {
+			return nil, p.errorf(t, "expect field name but got %v", t)
+		}
+		if wildcard {
+			if _, err := path.Match(t.text, ""); err != nil {
+				return nil, p.errorf(t, "invalid wildcard %s", t.text)
+			}
+		}
+		m.names = append(m.names, t.text)
+		if p.peek().kind != tokenComma {
+			return m, nil
+		}
+		p.next()
+	}
+}
+
+type projectCommand struct {
+	matcher *fieldMatcher
+}
+
+func parseProject(p *parser) (command, error) {
+	m, err := parseFieldMatcher(p)
+	if err != nil {
+		return nil, err
+	}
+	return &projectCommand{matcher: m}, nil
+}
+
+// apply keeps the matched fields, in the order they are listed.
+func (c *projectCommand) apply(r *record) (bool, error) {
+	kept := make([]field, 0, len(c.matcher.names))
+	if c.matcher.wildcard {
+		for _, f := range r.fields {
+			if c.matcher.match(f.key) {
+				kept = append(kept, f)
+			}
+		}
+	} else {
+		for _, name := range c.matcher.names {
+			if i := r.index(name); i >= 0 {
+				kept = append(kept, r.fields[i])
+			}
+		}
+	}
+	r.fields = kept
+	return true, nil
+}
+
+type projectAwayCommand struct {
+	matcher *fieldMatcher
+}
+
+func parseProjectAway(p *parser) (command, error) {
+	m, err := parseFieldMatcher(p)
+	if err != nil {
+		return nil, err
+	}
+	return &projectAwayCommand{matcher: m}, nil
+}
+
+func (c *projectAwayCommand) apply(r *record) (bool, error) {
+	kept := r.fields[:0]
+	for _, f := range r.fields {
+		if !c.matcher.match(f.key) {
+			kept = append(kept, f)
+		}
+	}
+	r.fields = kept
+	return true, nil
+}
+
+type projectRenameCommand struct {
+	newNames []string
+	oldNames []string
+}
+
+func parseProjectRename(p *parser) (command, error) {
+	c := &projectRenameCommand{}
+	for {
+		newName, err := p.parseFieldName()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenOperator || t.text != "=" {
+			return nil, p.errorf(t, "expect = but got %v", t)
+		}
+		oldName, err := p.parseFieldName()
+		if err != nil {
+			return nil, err
+		}
+		c.newNames = append(c.newNames, newName)
+		c.oldNames = append(c.oldNames, oldName)
+		if p.peek().kind != tokenComma {
+			return c, nil
+		}
+		p.next()
+	}
+}
+
+func (c *projectRenameCommand) apply(r *record) (bool, error) {
+	for i, oldName := range c.oldNames {
+		idx := r.index(oldName)
+		if idx < 0 {
+			continue
+		}
+		newName := c.newNames[i]
+		if existing := r.index(newName); existing >= 0 && existing != idx {
+			r.fields[existing].value = r.fields[idx].value
+			r.fields = append(r.fields[:idx], r.fields[idx+1:]...)
+			continue
+		}
+		r.fields[idx].key = newName
+	}
+	return true, nil
+}
+
+type parseRegexpCommand struct {
+	source string
+	re     *regexp.Regexp
+	// names of the capture groups, in order
+	names []string
+}
+
+// parseParseRegexp parses `parse-regexp field, 'pattern' [as a, b]`.
+// Without as, the named groups (?P<name>...) of the pattern are used.
+func parseParseRegexp(p *parser) (command, error) {
+	source, err := p.parseFieldName()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenComma, ","); err != nil {
+		return nil, err
+	}
+	pt, err := p.expect(tokenString, "regexp")
+	if err != nil {
+		return nil, err
+	}
+	// SPL follows the java style (?<name>...) for named groups
+	re, err := regexp.Compile(strings.ReplaceAll(pt.text, "(?<", "(?P<"))
+	if err != nil {
+		return nil, p.errorf(pt, "invalid regexp: %v", err)
+	}
+	c := &parseRegexpCommand{source: source, re: re}
+	if p.acceptKeyword("as") {
+		if c.names, err = p.parseFieldList(); err != nil {
+			return nil, err
+		}
+	} else {
+		for i, name := range re.SubexpNames() {
+			if i == 0 {
+				continue
+			}
+			if name == "" {
+				return nil, p.errorf(pt, "capture group %d has no name, use as to name it", i)
+			}
+			c.names = append(c.names, name)
+		}
+	}
+	if len(c.names) != re.NumSubexp() {
+		return nil, p.errorf(pt, "regexp has %d capture groups but %d fields are given", re.NumSubexp(), len(c.names))
+	}
+	return c, nil
+}
+
+func (c *parseRegexpCommand) apply(r *record) (bool, error) {
+	s, ok := r.get(c.source)
+	if !ok {
+		return true, nil
+	}
+	m := c.re.FindStringSubmatch(s)
+	if m == nil {
+		return true, nil
+	}
+	for i, name := range c.names {
+		r.set(name, m[i+1])
+	}
+	return true, nil
+}
+
+type parseJSONCommand struct {
+	source    string
+	prefix    string
+	path      string
+	overwrite bool
+}
+
+// parseParseJSON parses `parse-json [-prefix='p_'] [-path='$.a'] [-mode='overwrite|preserve'] field`.
+func parseParseJSON(p *parser) (command, error) {
+	options, err := p.parseOptions("prefix", "path", "mode")
+	if err != nil {
+		return nil, err
+	}
+	source, err := p.parseFieldName()
+	if err != nil {
+		return nil, err
+	}
+	c := &parseJSONCommand{source: source, prefix: options["prefix"], path: options["path"], overwrite: true}
+	switch strings.ToLower(options["mode"]) {
+	case "", "overwrite":
+	case "preserve":
+		c.overwrite = false
+	default:
+		return nil, p.errorf(p.peek(), "unsupported parse-json mode %s", options["mode"])
+	}
+	return c, nil
+}
+
+// apply extracts the first level keys of a json object, nested values
+// are kept as json text.
+func (c *parseJSONCommand) apply(r *record) (bool, error) {
+	s, ok := r.get(c.source)
+	if !ok {
+		return true, nil
+	}
+	var obj map[string]interface{}
+	if c.path != "" {
+		v, ok := jsonPath(s, c.path)
+		if !ok {
+			return true, nil
+		}
+		if obj, ok = v.(map[string]interface{}); !ok {
+			return true, nil
+		}
+	} else {
+		decoder := json.NewDecoder(strings.NewReader(s))
+		decoder.UseNumber()
+		if err := decoder.Decode(&obj); err != nil {
+			return true, nil
+		}
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := c.prefix + k
+		if !c.overwrite {
+			if _, exists := r.get(name); exists {
+				continue
+			}
+		}
+		switch v := obj[k].(type) {
+		case nil:
+			r.set(name, "null")
+		case string:
+			r.set(name, v)
+		case json.Number:
+			r.set(name, v.String())
+		default:
+			var buf bytes.Buffer
+			encoder := json.NewEncoder(&buf)
+			encoder.SetEscapeHTML(false)
+			if err := encoder.Encode(v); err == nil {
+				r.set(name, strings.TrimSuffix(buf.String(), "\n"))
+			}
+		}
+	}
+	return true, nil
+}
+
+type parseCSVCommand struct {
+	source string
+	delim  string
+	quote  string
+	strict bool
+	names  []string
+}
+
+// parseParseCSV parses `parse-csv [-delim=','] [-quote='"'] [-strict] field as a, b`.
+func parseParseCSV(p *parser) (command, error) {
+	options, err := p.parseOptions("delim", "quote", "strict")
+	if err != nil {
+		return nil, err
+	}
+	source, err := p.parseFieldName()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("as"); err != nil {
+		return nil, err
+	}
+	names, err := p.parseFieldList()
+	if err != nil {
+		return nil, err
+	}
+	c := &parseCSVCommand{source: source, delim: ",", quote: `"`, names: names}
+	if d, ok := options["delim"]; ok {
+		c.delim = unescapeOption(d)
+	}
+	if q, ok := options["quote"]; ok {
+		c.quote = unescapeOption(q)
+	}
+	_, c.strict = options["strict"]
+	if c.delim == "" {
+		return nil, p.errorf(p.peek(), "parse-csv delim must not be empty")
+	}
+	return c, nil
+}
+
+func unescapeOption(s string) string {
+	return strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\\`, `\`).Replace(s)
+}
+
+// apply splits the field and assigns the columns in order. Without -strict
+// missing columns are skipped and the rest columns are ignored, with -strict
+// the log is kept unchanged unless the column count matches exactly.
+func (c *parseCSVCommand) apply(r *record) (bool, error) {
+	s, ok := r.get(c.source)
+	if !ok {
+		return true, nil
+	}
+	columns := splitCSV(s, c.delim, c.quote)
+	if c.strict && len(columns) != len(c.names) {
+		return true, nil
+	}
+	for i, name := range c.names {
+		if i >= len(columns) {
+			break
+		}
+		r.set(name, columns[i])
+	}
+	return true, nil
+}
+
+// splitCSV splits one line by delim, honoring quote. A doubled quote inside
+// a quoted column is an escaped quote.
+func splitCSV(s, delim, quote string) []string {
+	var columns []string
+	var current strings.Builder
+	inQuote := false
+	for i := 0; i < len(s); {
+		switch {
+		case quote != "" && strings.HasPrefix(s[i:], quote):
+			if inQuote && strings.HasPrefix(s[i+len(quote):], quote) {
+				current.WriteString(quote)
+				i += 2 * len(quote)
+			} else {
+				inQuote = !inQuote
+				i += len(quote)
+			}
+		case !inQuote && strings.HasPrefix(s[i:], delim):
+			columns = append(columns, current.String())
+			current.Reset()
+			i += len(delim)
+		default:
+			current.WriteByte(s[i])
+			i++
+		}
+	}
+	return append(columns, current.String())
+}
@@ -0,0 +1,25 @@
+package spl
+
+import "fmt"
+
+// SyntaxError is returned by Compile when a query is not valid SPL
+// or uses a command that is not supported locally.
+type SyntaxError struct {
+	Query   string
+	Pos     int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("spl: syntax error at %d: %s", e.Pos, e.Message)
+}
+
+// EvalError is returned when an expression can not be evaluated on a log,
+// eg. cast('abc' as bigint).
+type EvalError struct {
+	Message string
+}
+
+func (e *EvalError) Error() string {
+	return "spl: " + e.Message
+}
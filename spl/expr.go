@@ -0,0 +1,341 @@
+package spl
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+// expr is a compiled SPL expression.
+type expr interface {
+	eval(r *record) (value, error)
+}
+
+type literalExpr struct{ v value }
+
+func (e *literalExpr) eval(*record) (value, error) { return e.v, nil }
+
+type fieldExpr struct{ name string }
+
+func (e *fieldExpr) eval(r *record) (value, error) {
+	if s, ok := r.get(e.name); ok {
+		return stringValue(s), nil
+	}
+	return nullValue, nil
+}
+
+type notExpr struct{ x expr }
+
+func (e *notExpr) eval(r *record) (value, error) {
+	v, err := e.x.eval(r)
+	if err != nil || v.isNull() {
+		return nullValue, err
+	}
+	return boolValue(!v.truth()), nil
+}
+
+type negExpr struct{ x expr }
+
+func (e *negExpr) eval(r *record) (value, error) {
+	v, err := e.x.eval(r)
+	if err != nil || v.isNull() {
+		return nullValue, err
+	}
+	if v.kind == kindInt {
+		return intValue(-v.i), nil
+	}
+	f, ok := v.number()
+	if !ok {
+		return value{}, &EvalError{Message: "cannot negate '" + v.String() + "'"}
+	}
+	return floatValue(-f), nil
+}
+
+type logicalExpr struct {
+	and         bool
+	left, right expr
+}
+
+// eval follows SQL three-valued logic, null and false is false, null or true is true.
+func (e *logicalExpr) eval(r *record) (value, error) {
+	l, err := e.left.eval(r)
+	if err != nil {
+		return value{}, err
+	}
+	if !l.isNull() {
+		if e.and && !l.truth() {
+			return boolValue(false), nil
+		}
+		if !e.and && l.truth() {
+			return boolValue(true), nil
+		}
+	}
+	rv, err := e.right.eval(r)
+	if err != nil {
+		return value{}, err
+	}
+	if !rv.isNull() {
+		if e.and && !rv.truth() {
+			return boolValue(false), nil
+		}
+		if !e.and && rv.truth() {
+			return boolValue(true), nil
+		}
+	}
+	if l.isNull() || rv.isNull() {
+		return nullValue, nil
+	}
+	return boolValue(e.and), nil
+}
+
+type compareExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e *compareExpr) eval(r *record) (value, error) {
+	l, err := e.left.eval(r)
+	if err != nil {
+		return value{}, err
+	}
+	rv, err := e.right.eval(r)
+	if err != nil {
+		return value{}, err
+	}
+	c, ok := compare(l, rv)
+	if !ok {
+		return nullValue, nil
+	}
+	switch e.op {
+	case "=", "==":
+		return boolValue(c == 0), nil
+	case "!=", "<>":
+		return boolValue(c != 0), nil
+	case "<":
+		return boolValue(c < 0), nil
+	case "<=":
+		return boolValue(c <= 0), nil
+	case ">":
+		return boolValue(c > 0), nil
+	case ">=":
+		return boolValue(c >= 0), nil
+	}
+	return value{}, &EvalError{Message: "unknown operator " + e.op}
+}
+
+type arithmeticExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e *arithmeticExpr) eval(r *record) (value, error) {
+	l, err := e.left.eval(r)
+	if err != nil {
+		return value{}, err
+	}
+	rv, err := e.right.eval(r)
+	if err != nil {
+		return value{}, err
+	}
+	if l.isNull() || rv.isNull() {
+		return nullValue, nil
+	}
+	if e.op == "||" {
+		return stringValue(l.String() + rv.String()), nil
+	}
+	if l.kind == kindInt && rv.kind == kindInt {
+		switch e.op {
+		case "+":
+			return intValue(l.i + rv.i), nil
+		case "-":
+			return intValue(l.i - rv.i), nil
+		case "*":
+			return intValue(l.i * rv.i), nil
+		case "/", "%":
+			if rv.i == 0 {
+				return value{}, &EvalError{Message: "division by zero"}
+			}
+			if e.op == "/" {
+				return intValue(l.i / rv.i), nil
+			}
+			return intValue(l.i % rv.i), nil
+		}
+	}
+	x, okX := l.number()
+	y, okY := rv.number()
+	if !okX || !okY {
+		return value{}, &EvalError{Message: "arithmetic on non-numeric value '" + l.String() + "' " + e.op + " '" + rv.String() + "'"}
+	}
+	switch e.op {
+	case "+":
+		return floatValue(x + y), nil
+	case "-":
+		return floatValue(x - y), nil
+	case "*":
+		return floatValue(x * y), nil
+	case "/":
+		if y == 0 {
+			return value{}, &EvalError{Message: "division by zero"}
+		}
+		return floatValue(x / y), nil
+	case "%":
+		if y == 0 {
+			return value{}, &EvalError{Message: "division by zero"}
+		}
+		return floatValue(math.Mod(x, y)), nil
+	}
+	return value{}, &EvalError{Message: "unknown operator " + e.op}
+}
+
+type isNullExpr struct {
+	x      expr
+	negate bool
+}
+
+func (e *isNullExpr) eval(r *record) (value, error) {
+	v, err := e.x.eval(r)
+	if err != nil {
+		return value{}, err
+	}
+	return boolValue(v.isNull() != e.negate), nil
+}
+
+type inExpr struct {
+	x      expr
+	list   []expr
+	negate bool
+}
+
+func (e *inExpr) eval(r *record) (value, error) {
+	v, err := e.x.eval(r)
+	if err != nil || v.isNull() {
+		return nullValue, err
+	}
+	for _, item := range e.list {
+		iv, err := item.eval(r)
+		if err != nil {
+			return value{}, err
+		}
+		if c, ok := compare(v, iv); ok && c == 0 {
+			return boolValue(!e.negate), nil
+		}
+	}
+	return boolValue(e.negate), nil
+}
+
+type betweenExpr struct {
+	x, low, high expr
+	negate       bool
+}
+
+func (e *betweenExpr) eval(r *record) (value, error) {
+	v, err := e.x.eval(r)
+	if err != nil {
+		return value{}, err
+	}
+	low, err := e.low.eval(r)
+	if err != nil {
+		return value{}, err
+	}
+	high, err := e.high.eval(r)
+	if err != nil {
+		return value{}, err
+	}
+	c1, ok1 := compare(v, low)
+	c2, ok2 := compare(v, high)
+	if !ok1 || !ok2 {
+		return nullValue, nil
+	}
+	return boolValue((c1 >= 0 && c2 <= 0) != e.negate), nil
+}
+
+type likeExpr struct {
+	x       expr
+	pattern *regexp.Regexp
+	negate  bool
+}
+
+func (e *likeExpr) eval(r *record) (value, error) {
+	v, err := e.x.eval(r)
+	if err != nil || v.isNull() {
+		return nullValue, err
+	}
+	return boolValue(e.pattern.MatchString(v.String()) != e.negate), nil
+}
+
+// likeToRegexp converts a SQL like pattern, where % matches any sequence and
+// _ matches one character, into an anchored regular expression.
+func likeToRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+type castExpr struct {
+	x        expr
+	typeName string
+	try      bool
+}
+
+func (e *castExpr) eval(r *record) (value, error) {
+	v, err := e.x.eval(r)
+	if err != nil {
+		return value{}, err
+	}
+	cv, err := castValue(v, e.typeName)
+	if err != nil && e.try {
+		return nullValue, nil
+	}
+	return cv, err
+}
+
+type caseExpr struct {
+	whens   []expr
+	thens   []expr
+	elseExp expr
+}
+
+func (e *caseExpr) eval(r *record) (value, error) {
+	for i, when := range e.whens {
+		c, err := when.eval(r)
+		if err != nil {
+			return value{}, err
+		}
+		if c.truth() {
+			return e.thens[i].eval(r)
+		}
+	}
+	if e.elseExp != nil {
+		return e.elseExp.eval(r)
+	}
+	return nullValue, nil
+}
+
+type callExpr struct {
+	name string
+	fn   function
+	args []expr
+}
+
+func (e *callExpr) eval(r *record) (value, error) {
+	args := make([]value, len(e.args))
+	for i, arg := range e.args {
+		v, err := arg.eval(r)
+		if err != nil {
+			return value{}, err
+		}
+		args[i] = v
+	}
+	return e.fn.call(args)
+}
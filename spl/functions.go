@@ -0,0 +1,284 @@
+package spl
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+type function struct {
+	minArgs int
+	maxArgs int // -1 means variadic
+	// nullable functions receive null arguments, others return null for any null argument
+	nullable bool
+	impl     func(args []value) (value, error)
+}
+
+func (f function) call(args []value) (value, error) {
+	if !f.nullable {
+		for _, arg := range args {
+			if arg.isNull() {
+				return nullValue, nil
+			}
+		}
+	}
+	return f.impl(args)
+}
+
+var functions = map[string]function{
+	"lower": {1, 1, false, func(args []value) (value, error) {
+		return stringValue(strings.ToLower(args[0].String())), nil
+	}},
+	"upper": {1, 1, false, func(args []value) (value, error) {
+		return stringValue(strings.ToUpper(args[0].String())), nil
+	}},
+	"length": {1, 1, false, func(args []value) (value, error) {
+		return intValue(int64(utf8.RuneCountInString(args[0].String()))), nil
+	}},
+	"trim": {1, 1, false, func(args []value) (value, error) {
+		return stringValue(strings.TrimSpace(args[0].String())), nil
+	}},
+	"ltrim": {1, 1, false, func(args []value) (value, error) {
+		return stringValue(strings.TrimLeft(args[0].String(), " \t\r\n")), nil
+	}},
+	"rtrim": {1, 1, false, func(args []value) (value, error) {
+		return stringValue(strings.TrimRight(args[0].String(), " \t\r\n")), nil
+	}},
+	"concat": {1, -1, false, func(args []value) (value, error) {
+		var sb strings.Builder
+		for _, arg := range args {
+			sb.WriteString(arg.String())
+		}
+		return stringValue(sb.String()), nil
+	}},
+	"replace": {2, 3, false, func(args []value) (value, error) {
+		replacement := ""
+		if len(args) == 3 {
+			replacement = args[2].String()
+		}
+		return stringValue(strings.ReplaceAll(args[0].String(), args[1].String(), replacement)), nil
+	}},
+	"strpos": {2, 2, false, func(args []value) (value, error) {
+		s, sub := args[0].String(), args[1].String()
+		idx := strings.Index(s, sub)
+		if idx < 0 {
+			return intValue(0), nil
+		}
+		return intValue(int64(utf8.RuneCountInString(s[:idx]) + 1)), nil
+	}},
+	"substr":    {2, 3, false, substr},
+	"substring": {2, 3, false, substr},
+	"split_part": {3, 3, false, func(args []value) (value, error) {
+		index, ok := args[2].number()
+		if !ok || !(index >= 1) {
+			return value{}, &EvalError{Message: "split_part index must be a positive number"}
+		}
+		parts := strings.Split(args[0].String(), args[1].String())
+		// compared as floats, a huge index overflows int
+		if index >= float64(len(parts)+1) {
+			return nullValue, nil
+		}
+		return stringValue(parts[int(index)-1]), nil
+	}},
+	"coalesce": {1, -1, true, func(args []value) (value, error) {
+		for _, arg := range args {
+			if !arg.isNull() {
+				return arg, nil
+			}
+		}
+		return nullValue, nil
+	}},
+	"if": {2, 3, true, func(args []value) (value, error) {
+		if args[0].truth() {
+			return args[1], nil
+		}
+		if len(args) == 3 {
+			return args[2], nil
+		}
+		return nullValue, nil
+	}},
+	"regexp_like": {2, 2, false, func(args []value) (value, error) {
+		re, err := cachedRegexp(args[1].String())
+		if err != nil {
+			return value{}, err
+		}
+		return boolValue(re.MatchString(args[0].String())), nil
+	}},
+	"regexp_extract": {2, 3, false, func(args []value) (value, error) {
+		re, err := cachedRegexp(args[1].String())
+		if err != nil {
+			return value{}, err
+		}
+		group := 0
+		if len(args) == 3 {
+			g, ok := args[2].number()
+			if !ok {
+				return value{}, &EvalError{Message: "regexp_extract group must be a number"}
+			}
+			group = int(g)
+		}
+		if group < 0 || group > re.NumSubexp() {
+			return value{}, &EvalError{Message: "regexp_extract group " + strconv.Itoa(group) + " out of range"}
+		}
+		m := re.FindStringSubmatch(args[0].String())
+		if m == nil {
+			return nullValue, nil
+		}
+		return stringValue(m[group]), nil
+	}},
+	"regexp_replace": {2, 3, false, func(args []value) (value, error) {
+		re, err := cachedRegexp(args[1].String())
+		if err != nil {
+			return value{}, err
+		}
+		replacement := ""
+		if len(args) == 3 {
+			replacement = args[2].String()
+		}
+		return stringValue(re.ReplaceAllString(args[0].String(), replacement)), nil
+	}},
+	"json_extract_scalar": {2, 2, false, func(args []value) (value, error) {
+		v, ok := jsonPath(args[0].String(), args[1].String())
+		if !ok {
+			return nullValue, nil
+		}
+		switch t := v.(type) {
+		case string:
+			return stringValue(t), nil
+		case json.Number:
+			return stringValue(t.String()), nil
+		case bool:
+			return stringValue(strconv.FormatBool(t)), nil
+		}
+		return nullValue, nil
+	}},
+	"json_extract": {2, 2, false, func(args []value) (value, error) {
+		v, ok := jsonPath(args[0].String(), args[1].String())
+		if !ok {
+			return nullValue, nil
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nullValue, nil
+		}
+		return stringValue(string(b)), nil
+	}},
+}
+
+func substr(args []value) (value, error) {
+	runes := []rune(args[0].String())
+	start, ok := args[1].number()
+	if !ok || math.IsNaN(start) {
+		return value{}, &EvalError{Message: "substr start must be a number"}
+	}
+	// positions are 1-based, negative positions count from the end; they are
+	// compared as floats first, a huge position overflows int
+	n := float64(len(runes))
+	if start > n || start < -n {
+		return stringValue(""), nil
+	}
+	begin := int(start) - 1
+	if start < 0 {
+		begin = len(runes) + int(start)
+	}
+	if begin < 0 || begin >= len(runes) {
+		return stringValue(""), nil
+	}
+	end := len(runes)
+	if len(args) == 3 {
+		length, ok := args[2].number()
+		if !ok || !(length >= 0) {
+			return value{}, &EvalError{Message: "substr length must be a non-negative number"}
+		}
+		if length < float64(end-begin) {
+			end = begin + int(length)
+		}
+	}
+	return stringValue(string(runes[begin:end])), nil
+}
+
+// maxCachedRegexps bounds regexpCache, the patterns may come from the logs
+const maxCachedRegexps = 1024
+
+var (
+	regexpCacheLock sync.RWMutex
+	regexpCache     = map[string]*regexp.Regexp{}
+)
+
+// cachedRegexp compiles a pattern once, the patterns beyond maxCachedRegexps
+// are compiled on each call.
+func cachedRegexp(pattern string) (*regexp.Regexp, error) {
+	regexpCacheLock.RLock()
+	re, ok := regexpCache[pattern]
+	regexpCacheLock.RUnlock()
+	if ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, &EvalError{Message: "invalid regexp '" + pattern + "': " + err.Error()}
+	}
+	regexpCacheLock.Lock()
+	if len(regexpCache) < maxCachedRegexps {
+		regexpCache[pattern] = re
+	}
+	regexpCacheLock.Unlock()
+	return re, nil
+}
+
+// jsonPath resolves a simple json path such as $.a.b or $.a[0].b.
+func jsonPath(doc, path string) (interface{}, bool) {
+	decoder := json.NewDecoder(strings.NewReader(doc))
+	decoder.UseNumber()
+	var root interface{}
+	if err := decoder.Decode(&root); err != nil {
+		return nil, false
+	}
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	current := root
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			obj, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if current, ok = obj[path[:end]]; !ok {
+				return nil, false
+			}
+			path = path[end:]
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, false
+			}
+			key := strings.Trim(path[1:end], `"'`)
+			path = path[end+1:]
+			if arr, ok := current.([]interface{}); ok {
+				idx, err := strconv.Atoi(key)
+				if err != nil || idx < 0 || idx >= len(arr) {
+					return nil, false
+				}
+				current = arr[idx]
+			} else if obj, ok := current.(map[string]interface{}); ok {
+				if current, ok = obj[key]; !ok {
+					return nil, false
+				}
+			} else {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+	return current, current != nil
+}
@@ -0,0 +1,207 @@
+package spl
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenOption // -name or -name=value, only valid as command options
+	tokenPipe
+	tokenComma
+	tokenLParen
+	tokenRParen
+	tokenStar
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// quoted is true when an identifier was written as "quoted field"
+	quoted bool
+	pos    int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q at %d", t.text, t.pos)
+}
+
+// lex splits a SPL query into tokens.
+// Command names such as project-away are lexed as one identifier.
+func lex(query string) ([]token, error) {
+	var tokens []token
+	runes := []rune(query)
+	i := 0
+	for i < len(runes) {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '|':
+			if i+1 < len(runes) && runes[i+1] == '|' {
+				tokens = append(tokens, token{kind: tokenOperator, text: "||", pos: i})
+				i += 2
+			} else {
+				tokens = append(tokens, token{kind: tokenPipe, text: "|", pos: i})
+				i++
+			}
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: i})
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == '*':
+			tokens = append(tokens, token{kind: tokenStar, text: "*", pos: i})
+			i++
+		case c == '\'':
+			s, next, err := lexQuoted(runes, i, '\'')
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: s, pos: i})
+			i = next
+		case c == '"':
+			s, next, err := lexQuoted(runes, i, '"')
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: s, quoted: true, pos: i})
+			i = next
+		case c == '-' && i+1 < len(runes) && unicode.IsLetter(runes[i+1]) && optionAllowed(tokens):
+			start := i
+			i++
+			for i < len(runes) && (isIdentRune(runes[i]) || runes[i] == '-') {
+				i++
+			}
+			text := string(runes[start:i])
+			if i < len(runes) && runes[i] == '=' {
+				i++
+				if i < len(runes) && runes[i] == '\'' {
+					s, next, err := lexQuoted(runes, i, '\'')
+					if err != nil {
+						return nil, err
+					}
+					text += "=" + s
+					i = next
+				} else {
+					valueStart := i
+					for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '|' {
+						i++
+					}
+					text += "=" + string(runes[valueStart:i])
+				}
+			}
+			tokens = append(tokens, token{kind: tokenOption, text: text, pos: start})
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '+' || runes[i] == '-') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), pos: start})
+		case isIdentStart(c):
+			start := i
+			for i < len(runes) && (isIdentRune(runes[i]) ||
+				// command names like project-away and parse-regexp
+				(runes[i] == '-' && i+1 < len(runes) && unicode.IsLetter(runes[i+1]) && isCommandPrefix(string(runes[start:i])))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[start:i]), pos: start})
+		default:
+			op, ok := lexOperator(runes, i)
+			if !ok {
+				return nil, &SyntaxError{Query: query, Pos: i, Message: fmt.Sprintf("unexpected character %q", c)}
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+			i += len([]rune(op))
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(runes)})
+	return tokens, nil
+}
+
+// optionAllowed reports whether a '-' at this position starts a command option
+// (directly after a command name or another option) instead of a minus sign.
+func optionAllowed(tokens []token) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	last := tokens[len(tokens)-1]
+	if last.kind == tokenOption {
+		return true
+	}
+	if last.kind != tokenIdent || last.quoted {
+		return false
+	}
+	if len(tokens) >= 2 && tokens[len(tokens)-2].kind != tokenPipe {
+		return false
+	}
+	_, ok := commandParsers[strings.ToLower(last.text)]
+	return ok
+}
+
+func isCommandPrefix(s string) bool {
+	switch strings.ToLower(s) {
+	case "project", "parse", "project-away", "project-rename":
+		return true
+	}
+	return false
+}
+
+func isIdentStart(c rune) bool {
+	return c == '_' || c == '$' || unicode.IsLetter(c)
+}
+
+func isIdentRune(c rune) bool {
+	return c == '_' || c == '$' || c == '.' || c == ':' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+func lexQuoted(runes []rune, start int, quote rune) (string, int, error) {
+	var sb strings.Builder
+	i := start + 1
+	for i < len(runes) {
+		if runes[i] == quote {
+			// a doubled quote is an escaped quote
+			if i+1 < len(runes) && runes[i+1] == quote {
+				sb.WriteRune(quote)
+				i += 2
+				continue
+			}
+			return sb.String(), i + 1, nil
+		}
+		sb.WriteRune(runes[i])
+		i++
+	}
+	return "", 0, &SyntaxError{Query: string(runes), Pos: start, Message: "unterminated quoted text"}
+}
+
+func lexOperator(runes []rune, i int) (string, bool) {
+	two := ""
+	if i+1 < len(runes) {
+		two = string(runes[i : i+2])
+	}
+	switch two {
+	case "!=", "<>", "<=", ">=", "==":
+		return two, true
+	}
+	switch runes[i] {
+	case '=', '<', '>', '+', '-', '/', '%':
+		return string(runes[i]), true
+	}
+	return "", false
+}
@@ -0,0 +1,398 @@
+package spl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type parser struct {
+	query  string
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &SyntaxError{Query: p.query, Pos: t.pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// isKeyword reports whether t is the unquoted keyword kw.
+func isKeyword(t token, kw string) bool {
+	return t.kind == tokenIdent && !t.quoted && strings.EqualFold(t.text, kw)
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if isKeyword(p.peek(), kw) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if t := p.next(); !isKeyword(t, kw) {
+		return p.errorf(t, "expect %s but got %v", kw, t)
+	}
+	return nil
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, p.errorf(t, "expect %s but got %v", what, t)
+	}
+	return t, nil
+}
+
+// atCommandEnd reports whether the current command has no more tokens.
+func (p *parser) atCommandEnd() bool {
+	k := p.peek().kind
+	return k == tokenPipe || k == tokenEOF
+}
+
+// parseFieldName parses a field name, either an identifier or a "quoted" name.
+func (p *parser) parseFieldName() (string, error) {
+	t := p.next()
+	if t.kind != tokenIdent {
+		return "", p.errorf(t, "expect field name but got %v", t)
+	}
+	return t.text, nil
+}
+
+func (p *parser) parseFieldList() ([]string, error) {
+	var fields []string
+	for {
+		name, err := p.parseFieldName()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, name)
+		if p.peek().kind != tokenComma {
+			return fields, nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.acceptKeyword("not") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{x: x}, nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *parser) parsePredicate() (expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind == tokenOperator {
+		switch t.text {
+		case "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			p.next()
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			return &compareExpr{op: t.text, left: left, right: right}, nil
+		}
+	}
+	negate := false
+	if isKeyword(t, "not") {
+		p.next()
+		negate = true
+		t = p.peek()
+	}
+	switch {
+	case isKeyword(t, "like"):
+		p.next()
+		pt, err := p.expect(tokenString, "like pattern")
+		if err != nil {
+			return nil, err
+		}
+		re, err := likeToRegexp(pt.text)
+		if err != nil {
+			return nil, p.errorf(pt, "invalid like pattern: %v", err)
+		}
+		return &likeExpr{x: left, pattern: re, negate: negate}, nil
+	case isKeyword(t, "in"):
+		p.next()
+		if _, err := p.expect(tokenLParen, "("); err != nil {
+			return nil, err
+		}
+		list, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		return &inExpr{x: left, list: list, negate: negate}, nil
+	case isKeyword(t, "between"):
+		p.next()
+		low, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("and"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &betweenExpr{x: left, low: low, high: high, negate: negate}, nil
+	case isKeyword(t, "is") && !negate:
+		p.next()
+		isNot := p.acceptKeyword("not")
+		if err := p.expectKeyword("null"); err != nil {
+			return nil, err
+		}
+		return &isNullExpr{x: left, negate: isNot}, nil
+	}
+	if negate {
+		return nil, p.errorf(t, "expect like, in or between after not but got %v", t)
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokenOperator || (t.text != "+" && t.text != "-" && t.text != "||") {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmeticExpr{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseMultiplicative() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		op := ""
+		if t.kind == tokenStar {
+			op = "*"
+		} else if t.kind == tokenOperator && (t.text == "/" || t.text == "%") {
+			op = t.text
+		} else {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmeticExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if t := p.peek(); t.kind == tokenOperator && t.text == "-" {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negExpr{x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return &literalExpr{v: stringValue(t.text)}, nil
+	case tokenNumber:
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &literalExpr{v: intValue(i)}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %s", t.text)
+		}
+		return &literalExpr{v: floatValue(f)}, nil
+	case tokenLParen:
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case tokenIdent:
+		if t.quoted {
+			return &fieldExpr{name: t.text}, nil
+		}
+		switch strings.ToLower(t.text) {
+		case "null":
+			return &literalExpr{v: nullValue}, nil
+		case "true":
+			return &literalExpr{v: boolValue(true)}, nil
+		case "false":
+			return &literalExpr{v: boolValue(false)}, nil
+		case "case":
+			return p.parseCase()
+		}
+		if p.peek().kind == tokenLParen {
+			p.next()
+			return p.parseCall(t)
+		}
+		return &fieldExpr{name: t.text}, nil
+	}
+	return nil, p.errorf(t, "unexpected %v", t)
+}
+
+func (p *parser) parseCase() (expr, error) {
+	c := &caseExpr{}
+	for p.acceptKeyword("when") {
+		when, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("then"); err != nil {
+			return nil, err
+		}
+		then, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, when)
+		c.thens = append(c.thens, then)
+	}
+	if len(c.whens) == 0 {
+		return nil, p.errorf(p.peek(), "expect when but got %v", p.peek())
+	}
+	if p.acceptKeyword("else") {
+		elseExp, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.elseExp = elseExp
+	}
+	if err := p.expectKeyword("end"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseArgs parses a comma separated expression list up to and including ')'.
+func (p *parser) parseArgs() ([]expr, error) {
+	var args []expr
+	if p.peek().kind == tokenRParen {
+		p.next()
+		return args, nil
+	}
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		t := p.next()
+		if t.kind == tokenRParen {
+			return args, nil
+		}
+		if t.kind != tokenComma {
+			return nil, p.errorf(t, "expect , or ) but got %v", t)
+		}
+	}
+}
+
+func (p *parser) parseCall(name token) (expr, error) {
+	lower := strings.ToLower(name.text)
+	if lower == "cast" || lower == "try_cast" {
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("as"); err != nil {
+			return nil, err
+		}
+		typeToken, err := p.expect(tokenIdent, "type name")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		if _, err := castValue(stringValue("0"), typeToken.text); err != nil {
+			return nil, p.errorf(typeToken, "unsupported cast type %s", typeToken.text)
+		}
+		return &castExpr{x: x, typeName: typeToken.text, try: lower == "try_cast"}, nil
+	}
+	fn, ok := functions[lower]
+	if !ok {
+		return nil, p.errorf(name, "unsupported function %s", name.text)
+	}
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, p.errorf(name, "wrong number of arguments for %s: %d", name.text, len(args))
+	}
+	return &callExpr{name: lower, fn: fn, args: args}, nil
+}
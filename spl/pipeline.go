@@ -0,0 +1,144 @@
+// Package spl is a local interpreter for a subset of the SLS processing
+// language (SPL), the same language accepted by LogHubConfig.Query and
+// PullLogRequest.Query.
+//
+// Supported commands are where, extend, project, project-away,
+// project-rename, parse-regexp, parse-json and parse-csv, eg.
+//
+//	pipeline, err := spl.Compile(`* | where status = '500' | parse-regexp content, '(\S+)\s+(\w+)' as ip, method`)
+//	logGroupList, err = pipeline.Process(logGroupList)
+//
+// Pipelines can be used to unit-test consumer queries offline, or to process
+// data from any source on the client side.
+package spl
+
+import (
+	"fmt"
+	"strings"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+// Pipeline is a compiled SPL query, it is safe for concurrent use.
+type Pipeline struct {
+	query    string
+	commands []command
+}
+
+// Compile parses a SPL query. An empty query or * matches all logs.
+func Compile(query string) (*Pipeline, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{query: query, tokens: tokens}
+	pipeline := &Pipeline{query: query}
+
+	// the leading * stage selects all logs
+	if p.peek().kind == tokenStar {
+		p.next()
+		if !p.atCommandEnd() {
+			return nil, p.errorf(p.peek(), "expect | after * but got %v", p.peek())
+		}
+	}
+	for p.peek().kind != tokenEOF {
+		if p.peek().kind == tokenPipe {
+			p.next()
+		}
+		t := p.next()
+		if t.kind != tokenIdent || t.quoted {
+			return nil, p.errorf(t, "expect command but got %v", t)
+		}
+		parse, ok := commandParsers[strings.ToLower(t.text)]
+		if !ok {
+			return nil, p.errorf(t, "unsupported command %s", t.text)
+		}
+		cmd, err := parse(p)
+		if err != nil {
+			return nil, err
+		}
+		if !p.atCommandEnd() {
+			return nil, p.errorf(p.peek(), "unexpected %v", p.peek())
+		}
+		pipeline.commands = append(pipeline.commands, cmd)
+	}
+	return pipeline, nil
+}
+
+// MustCompile is like Compile but panics if the query can not be parsed.
+func MustCompile(query string) *Pipeline {
+	p, err := Compile(query)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the source query.
+func (p *Pipeline) String() string {
+	return p.query
+}
+
+// ProcessLog runs the pipeline on one log, group is used to read __topic__,
+// __source__ and __tag__:* fields and may be nil. The returned log is nil if
+// it is filtered out.
+func (p *Pipeline) ProcessLog(group *sls.LogGroup, log *sls.Log) (*sls.Log, error) {
+	r := newRecord(group, log)
+	for _, cmd := range p.commands {
+		keep, err := cmd.apply(r)
+		if err != nil {
+			return nil, err
+		}
+		if !keep {
+			return nil, nil
+		}
+	}
+	return r.toLog(), nil
+}
+
+// ProcessLogGroup runs the pipeline on every log of the group. The returned
+// group keeps the topic, source and tags of the input, and is nil if all logs
+// are filtered out.
+func (p *Pipeline) ProcessLogGroup(group *sls.LogGroup) (*sls.LogGroup, error) {
+	var logs []*sls.Log
+	for i, log := range group.Logs {
+		out, err := p.ProcessLog(group, log)
+		if err != nil {
+			return nil, fmt.Errorf("process log %d: %w", i, err)
+		}
+		if out != nil {
+			logs = append(logs, out)
+		}
+	}
+	if len(logs) == 0 {
+		return nil, nil
+	}
+	return &sls.LogGroup{
+		Logs:        logs,
+		Category:    group.Category,
+		Topic:       group.Topic,
+		Source:      group.Source,
+		MachineUUID: group.MachineUUID,
+		LogTags:     group.LogTags,
+	}, nil
+}
+
+// Process runs the pipeline on a LogGroupList, such as one returned by
+// PullLogs. Groups whose logs are all filtered out are removed.
+// The input is not modified.
+func (p *Pipeline) Process(logGroupList *sls.LogGroupList) (*sls.LogGroupList, error) {
+	result := &sls.LogGroupList{}
+	if logGroupList == nil {
+		return result, nil
+	}
+	for i, group := range logGroupList.LogGroups {
+		out, err := p.ProcessLogGroup(group)
+		if err != nil {
+			return nil, fmt.Errorf("spl: log group %d: %w", i, err)
+		}
+		if out != nil {
+			result.LogGroups = append(result.LogGroups, out)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,206 @@
+package spl
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+func makeLog(kv ...string) *sls.Log {
+	log := &sls.Log{Time: proto.Uint32(1700000000)}
+	for i := 0; i+1 < len(kv); i += 2 {
+		log.Contents = append(log.Contents, &sls.LogContent{Key: proto.String(kv[i]), Value: proto.String(kv[i+1])})
+	}
+	return log
+}
+
+func logToPairs(log *sls.Log) []string {
+	pairs := []string{}
+	for _, c := range log.Contents {
+		pairs = append(pairs, c.GetKey(), c.GetValue())
+	}
+	return pairs
+}
+
+func TestPipelineProcessLog(t *testing.T) {
+	group := &sls.LogGroup{
+		Topic:   proto.String("nginx"),
+		Source:  proto.String("10.0.0.1"),
+		LogTags: []*sls.LogTag{{Key: proto.String("host"), Value: proto.String("web-1")}},
+	}
+	tests := []struct {
+		name  string
+		query string
+		input []string
+		want  []string // nil means the log is filtered out
+	}{
+		{"all", "*", []string{"a", "1"}, []string{"a", "1"}},
+		{"where string", "* | where status = '200'", []string{"status", "200"}, []string{"status", "200"}},
+		{"where filtered", "* | where status = '200'", []string{"status", "500"}, nil},
+		{"where numeric", "where cast(status as bigint) >= 500 and method != 'GET'", []string{"status", "502", "method", "POST"}, []string{"status", "502", "method", "POST"}},
+		{"where implicit number", "where latency > 100", []string{"latency", "99"}, nil},
+		{"where null", "where missing = 'x'", []string{"a", "1"}, nil},
+		{"where is null", "where missing is null", []string{"a", "1"}, []string{"a", "1"}},
+		{"where like", "where path like '/api/%'", []string{"path", "/api/v1"}, []string{"path", "/api/v1"}},
+		{"where in", "where method in ('PUT', 'POST')", []string{"method", "GET"}, nil},
+		{"where meta", `where __topic__ = 'nginx' and "__tag__:host" = 'web-1'`, []string{"a", "1"}, []string{"a", "1"}},
+		{"where regexp_like", "where regexp_like(ua, 'curl/\\d+')", []string{"ua", "curl/7"}, []string{"ua", "curl/7"}},
+		{"extend", "extend b = a || '-x', c = cast(a as bigint) * 2, d = lower(m)", []string{"a", "21", "m", "AB"},
+			[]string{"a", "21", "m", "AB", "b", "21-x", "c", "42", "d", "ab"}},
+		{"extend case", "extend level = case when cast(s as bigint) >= 500 then 'error' else 'ok' end", []string{"s", "503"},
+			[]string{"s", "503", "level", "error"}},
+		{"project", "project b, a, missing", []string{"a", "1", "b", "2", "c", "3"}, []string{"b", "2", "a", "1"}},
+		{"project wildcard", "project -wildcard 'req_*'", []string{"req_a", "1", "b", "2", "req_c", "3"}, []string{"req_a", "1", "req_c", "3"}},
+		{"project-away", "project-away a, c", []string{"a", "1", "b", "2", "c", "3"}, []string{"b", "2"}},
+		{"project-rename", "project-rename x = a, b = c", []string{"a", "1", "b", "2", "c", "3"}, []string{"x", "1", "b", "3"}},
+		{"parse-regexp", `parse-regexp content, '(\S+)\s+(\w+)' as ip, method`, []string{"content", "1.2.3.4 GET"},
+			[]string{"content", "1.2.3.4 GET", "ip", "1.2.3.4", "method", "GET"}},
+		{"parse-regexp named", `parse-regexp content, '(?<ip>\S+)\s+(?P<method>\w+)'`, []string{"content", "1.2.3.4 GET"},
+			[]string{"content", "1.2.3.4 GET", "ip", "1.2.3.4", "method", "GET"}},
+		{"parse-regexp no match", `parse-regexp content, '(\d+)' as n`, []string{"content", "abc"}, []string{"content", "abc"}},
+		{"parse-json", "parse-json body", []string{"body", `{"b":{"x":1},"a":"s","n":1.5}`},
+			[]string{"body", `{"b":{"x":1},"a":"s","n":1.5}`, "a", "s", "b", `{"x":1}`, "n", "1.5"}},
+		{"parse-json prefix path", "parse-json -prefix='p_' -path='$.b' body", []string{"body", `{"b":{"x":1}}`},
+			[]string{"body", `{"b":{"x":1}}`, "p_x", "1"}},
+		{"parse-json preserve", "parse-json -mode='preserve' body", []string{"body", `{"a":"new"}`, "a", "old"},
+			[]string{"body", `{"a":"new"}`, "a", "old"}},
+		{"parse-csv", `parse-csv line as a, b, c`, []string{"line", `1,"x,y",3`}, []string{"line", `1,"x,y",3`, "a", "1", "b", "x,y", "c", "3"}},
+		{"parse-csv delim", `parse-csv -delim='|' line as a, b`, []string{"line", `1|2|3`}, []string{"line", `1|2|3`, "a", "1", "b", "2"}},
+		{"parse-csv strict", `parse-csv -strict line as a, b`, []string{"line", `1,2,3`}, []string{"line", `1,2,3`}},
+		{"chain", `* | parse-regexp content, '(\d+) (\d+)' as status, size | where cast(size as bigint) > 10 | project-away content | extend kb = cast(size as double) / 1024`,
+			[]string{"content", "200 2048"}, []string{"status", "200", "size", "2048", "kb", "2.0"}},
+		{"try_cast", "extend n = try_cast(a as bigint)", []string{"a", "x"}, []string{"a", "x"}},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.query)
+		if err != nil {
+			t.Errorf("%q. Compile() error = %v", tt.name, err)
+			continue
+		}
+		got, err := p.ProcessLog(group, makeLog(tt.input...))
+		if err != nil {
+			t.Errorf("%q. ProcessLog() error = %v", tt.name, err)
+			continue
+		}
+		if tt.want == nil {
+			if got != nil {
+				t.Errorf("%q. ProcessLog() = %v, want filtered", tt.name, logToPairs(got))
+			}
+			continue
+		}
+		if got == nil {
+			t.Errorf("%q. ProcessLog() filtered, want %v", tt.name, tt.want)
+			continue
+		}
+		if pairs := logToPairs(got); !reflect.DeepEqual(pairs, tt.want) {
+			t.Errorf("%q. ProcessLog() = %v, want %v", tt.name, pairs, tt.want)
+		}
+	}
+}
+
+func TestPipelineProcess(t *testing.T) {
+	p := MustCompile("* | where level = 'ERROR' | extend __time__ = '1700000100'")
+	input := &sls.LogGroupList{LogGroups: []*sls.LogGroup{
+		{Topic: proto.String("a"), Logs: []*sls.Log{makeLog("level", "INFO"), makeLog("level", "ERROR")}},
+		{Topic: proto.String("b"), Logs: []*sls.Log{makeLog("level", "DEBUG")}},
+	}}
+	out, err := p.Process(input)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if len(out.LogGroups) != 1 || len(out.LogGroups[0].Logs) != 1 {
+		t.Fatalf("Process() = %v, want one group with one log", out)
+	}
+	if out.LogGroups[0].GetTopic() != "a" {
+		t.Errorf("Process() topic = %v, want a", out.LogGroups[0].GetTopic())
+	}
+	if got := out.LogGroups[0].Logs[0].GetTime(); got != 1700000100 {
+		t.Errorf("Process() time = %v, want 1700000100", got)
+	}
+	if len(input.LogGroups[0].Logs) != 2 {
+		t.Errorf("Process() modified the input")
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	queries := []string{
+		"* | stats count(*)",
+		"* | where",
+		"* | where a = 'unterminated",
+		"* | extend a",
+		"* | parse-regexp content, '(a)(b)' as x",
+		"* | parse-json -unknown content",
+		"* | where cast(a as map) = 1",
+		"* | where nosuchfunc(a)",
+		"* | project a b",
+	}
+	for _, q := range queries {
+		if _, err := Compile(q); err == nil {
+			t.Errorf("Compile(%q) expect error", q)
+		} else if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("Compile(%q) error = %T, want *SyntaxError", q, err)
+		}
+	}
+}
+
+func TestEvalError(t *testing.T) {
+	p := MustCompile("* | where cast(a as bigint) > 1")
+	_, err := p.Process(&sls.LogGroupList{LogGroups: []*sls.LogGroup{{Logs: []*sls.Log{makeLog("a", "x")}}}})
+	if err == nil {
+		t.Fatal("Process() expect cast error")
+	}
+}
+
+func TestEvalBounds(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		input []string
+		want  []string
+	}{
+		{"mod fraction", "extend m = 5 % 0.5", nil, []string{"m", "0.0"}},
+		{"mod fraction field", "extend m = a % b", []string{"a", "5.5", "b", "0.5"}, []string{"a", "5.5", "b", "0.5", "m", "0.0"}},
+		{"mod float", "extend m = a % 2", []string{"a", "5.5"}, []string{"a", "5.5", "m", "1.5"}},
+		{"substr huge length", "extend s = substr('abc', 1, 1e30)", nil, []string{"s", "abc"}},
+		{"substr huge start", "extend s = substr('abc', 1e30)", nil, []string{"s", ""}},
+		{"substr huge negative start", "extend s = substr('abc', -1e30, 2)", nil, []string{"s", ""}},
+		{"split_part huge index", "extend s = split_part('a,b', ',', 1e30)", nil, nil},
+		{"split_part last", "extend s = split_part('a,b', ',', 2)", nil, []string{"s", "b"}},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.query)
+		if err != nil {
+			t.Errorf("%q. Compile() error = %v", tt.name, err)
+			continue
+		}
+		got, err := p.ProcessLog(&sls.LogGroup{}, makeLog(tt.input...))
+		if err != nil {
+			t.Errorf("%q. ProcessLog() error = %v", tt.name, err)
+			continue
+		}
+		if pairs := logToPairs(got); !reflect.DeepEqual(pairs, append([]string{}, tt.want...)) {
+			t.Errorf("%q. ProcessLog() = %v, want %v", tt.name, pairs, tt.want)
+		}
+	}
+
+	p := MustCompile("* | extend m = a % b")
+	if _, err := p.ProcessLog(&sls.LogGroup{}, makeLog("a", "5", "b", "0.0")); err == nil {
+		t.Errorf("ProcessLog() of a modulo by zero expect error")
+	}
+}
+
+func TestRegexpCacheBound(t *testing.T) {
+	for i := 0; i < maxCachedRegexps+10; i++ {
+		if _, err := cachedRegexp("x" + strconv.Itoa(i)); err != nil {
+			t.Fatalf("cachedRegexp() error = %v", err)
+		}
+	}
+	regexpCacheLock.RLock()
+	defer regexpCacheLock.RUnlock()
+	if len(regexpCache) > maxCachedRegexps {
+		t.Errorf("regexpCache has %d patterns, want at most %d", len(regexpCache), maxCachedRegexps)
+	}
+}
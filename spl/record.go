@@ -0,0 +1,132 @@
+package spl
+
+import (
+	"strconv"
+	"strings"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+// reserved field names, readable in every expression
+const (
+	FieldTime       = "__time__"
+	FieldTimeNsPart = "__time_ns_part__"
+	FieldTopic      = "__topic__"
+	FieldSource     = "__source__"
+	FieldTagPrefix  = "__tag__:"
+)
+
+type field struct {
+	key   string
+	value string
+}
+
+// record is the mutable view of one log while it runs through a pipeline.
+type record struct {
+	group  *sls.LogGroup
+	time   uint32
+	timeNs *uint32
+	fields []field
+}
+
+func newRecord(group *sls.LogGroup, log *sls.Log) *record {
+	r := &record{
+		group:  group,
+		time:   log.GetTime(),
+		timeNs: log.TimeNs,
+		fields: make([]field, 0, len(log.Contents)),
+	}
+	for _, c := range log.Contents {
+		r.fields = append(r.fields, field{key: c.GetKey(), value: c.GetValue()})
+	}
+	return r
+}
+
+func (r *record) index(name string) int {
+	for i := range r.fields {
+		if r.fields[i].key == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// get returns a log content first, then the reserved fields of the log and its group.
+func (r *record) get(name string) (string, bool) {
+	if i := r.index(name); i >= 0 {
+		return r.fields[i].value, true
+	}
+	switch name {
+	case FieldTime:
+		return strconv.FormatUint(uint64(r.time), 10), true
+	case FieldTimeNsPart:
+		if r.timeNs == nil {
+			return "", false
+		}
+		return strconv.FormatUint(uint64(*r.timeNs), 10), true
+	case FieldTopic:
+		if r.group == nil || r.group.Topic == nil {
+			return "", false
+		}
+		return r.group.GetTopic(), true
+	case FieldSource:
+		if r.group == nil || r.group.Source == nil {
+			return "", false
+		}
+		return r.group.GetSource(), true
+	}
+	if strings.HasPrefix(name, FieldTagPrefix) && r.group != nil {
+		key := name[len(FieldTagPrefix):]
+		for _, tag := range r.group.LogTags {
+			if tag.GetKey() == key {
+				return tag.GetValue(), true
+			}
+		}
+	}
+	return "", false
+}
+
+// set overwrites or appends a content. Setting __time__ or __time_ns_part__
+// changes the log time instead when the value is a valid number.
+func (r *record) set(name, val string) {
+	switch name {
+	case FieldTime:
+		if t, err := strconv.ParseUint(val, 10, 32); err == nil {
+			r.time = uint32(t)
+			return
+		}
+	case FieldTimeNsPart:
+		if t, err := strconv.ParseUint(val, 10, 32); err == nil {
+			ns := uint32(t)
+			r.timeNs = &ns
+			return
+		}
+	}
+	if i := r.index(name); i >= 0 {
+		r.fields[i].value = val
+		return
+	}
+	r.fields = append(r.fields, field{key: name, value: val})
+}
+
+func (r *record) remove(name string) {
+	if i := r.index(name); i >= 0 {
+		r.fields = append(r.fields[:i], r.fields[i+1:]...)
+	}
+}
+
+func (r *record) toLog() *sls.Log {
+	log := &sls.Log{
+		Time:     proto.Uint32(r.time),
+		Contents: make([]*sls.LogContent, 0, len(r.fields)),
+		TimeNs:   r.timeNs,
+	}
+	for _, f := range r.fields {
+		log.Contents = append(log.Contents, &sls.LogContent{
+			Key:   proto.String(f.key),
+			Value: proto.String(f.value),
+		})
+	}
+	return log
+}
@@ -0,0 +1,176 @@
+package spl
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+type valueKind int
+
+const (
+	kindNull valueKind = iota
+	kindString
+	kindInt
+	kindFloat
+	kindBool
+)
+
+// value is the result of evaluating an expression against one log.
+// Log fields are always strings; numbers and booleans only appear as
+// literals, function results or casts.
+type value struct {
+	kind valueKind
+	s    string
+	i    int64
+	f    float64
+	b    bool
+}
+
+var nullValue = value{kind: kindNull}
+
+func stringValue(s string) value { return value{kind: kindString, s: s} }
+func intValue(i int64) value     { return value{kind: kindInt, i: i} }
+func floatValue(f float64) value { return value{kind: kindFloat, f: f} }
+func boolValue(b bool) value     { return value{kind: kindBool, b: b} }
+
+func (v value) isNull() bool { return v.kind == kindNull }
+
+// String renders the value the way it is written back into a log content.
+func (v value) String() string {
+	switch v.kind {
+	case kindString:
+		return v.s
+	case kindInt:
+		return strconv.FormatInt(v.i, 10)
+	case kindFloat:
+		if v.f == math.Trunc(v.f) && math.Abs(v.f) < 1e15 {
+			return strconv.FormatFloat(v.f, 'f', 1, 64)
+		}
+		return strconv.FormatFloat(v.f, 'g', -1, 64)
+	case kindBool:
+		return strconv.FormatBool(v.b)
+	}
+	return ""
+}
+
+// number converts the value into a float64, reporting whether it is numeric.
+func (v value) number() (float64, bool) {
+	switch v.kind {
+	case kindInt:
+		return float64(v.i), true
+	case kindFloat:
+		return v.f, true
+	case kindBool:
+		if v.b {
+			return 1, true
+		}
+		return 0, true
+	case kindString:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.s), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// truth interprets the value as a condition, null is false.
+func (v value) truth() bool {
+	switch v.kind {
+	case kindBool:
+		return v.b
+	case kindInt:
+		return v.i != 0
+	case kindFloat:
+		return v.f != 0
+	case kindString:
+		b, err := strconv.ParseBool(v.s)
+		return err == nil && b
+	}
+	return false
+}
+
+func (v value) isNumeric() bool {
+	return v.kind == kindInt || v.kind == kindFloat
+}
+
+// compare returns -1, 0 or 1. ok is false if either side is null or the
+// values are not comparable. A number compared with a string compares
+// numerically when the string is a number, otherwise both sides are
+// compared as text.
+func compare(a, b value) (int, bool) {
+	if a.isNull() || b.isNull() {
+		return 0, false
+	}
+	if a.isNumeric() || b.isNumeric() {
+		x, okX := a.number()
+		y, okY := b.number()
+		if okX && okY {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if a.kind == kindBool && b.kind == kindBool {
+		switch {
+		case a.b == b.b:
+			return 0, true
+		case !a.b:
+			return -1, true
+		}
+		return 1, true
+	}
+	return strings.Compare(a.String(), b.String()), true
+}
+
+func castValue(v value, typeName string) (value, error) {
+	if v.isNull() {
+		return nullValue, nil
+	}
+	switch strings.ToLower(typeName) {
+	case "varchar", "string", "char":
+		return stringValue(v.String()), nil
+	case "bigint", "int", "integer", "smallint", "tinyint":
+		switch v.kind {
+		case kindInt:
+			return v, nil
+		case kindFloat:
+			return intValue(int64(v.f)), nil
+		case kindBool:
+			if v.b {
+				return intValue(1), nil
+			}
+			return intValue(0), nil
+		}
+		s := strings.TrimSpace(v.s)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return intValue(i), nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return intValue(int64(f)), nil
+		}
+		return value{}, &EvalError{Message: "cannot cast '" + v.s + "' to " + typeName}
+	case "double", "real", "float", "decimal":
+		f, ok := v.number()
+		if !ok {
+			return value{}, &EvalError{Message: "cannot cast '" + v.String() + "' to " + typeName}
+		}
+		return floatValue(f), nil
+	case "boolean", "bool":
+		switch v.kind {
+		case kindBool:
+			return v, nil
+		case kindString:
+			b, err := strconv.ParseBool(strings.TrimSpace(v.s))
+			if err != nil {
+				return value{}, &EvalError{Message: "cannot cast '" + v.s + "' to " + typeName}
+			}
+			return boolValue(b), nil
+		}
+		return boolValue(v.truth()), nil
+	}
+	return value{}, &EvalError{Message: "unsupported cast type " + typeName}
+}
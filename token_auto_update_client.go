@@ -1920,6 +1920,56 @@ func (c *TokenAutoUpdateClient) GetMetricStore(project, name string) (metricStor
 	return
 }
 
+func (c *TokenAutoUpdateClient) PromInstantQuery(project, metricStore, query string, ts time.Time) (result *PromQueryResult, err error) {
+	for i := 0; i < c.maxTryTimes; i++ {
+		result, err = c.logClient.PromInstantQuery(project, metricStore, query, ts)
+		if !c.processError(err) {
+			return
+		}
+	}
+	return
+}
+
+func (c *TokenAutoUpdateClient) PromRangeQuery(project, metricStore, query string, start, end time.Time, step time.Duration) (result *PromQueryResult, err error) {
+	for i := 0; i < c.maxTryTimes; i++ {
+		result, err = c.logClient.PromRangeQuery(project, metricStore, query, start, end, step)
+		if !c.processError(err) {
+			return
+		}
+	}
+	return
+}
+
+func (c *TokenAutoUpdateClient) PromSeries(project, metricStore string, matches []string, start, end time.Time) (series []map[string]string, err error) {
+	for i := 0; i < c.maxTryTimes; i++ {
+		series, err = c.logClient.PromSeries(project, metricStore, matches, start, end)
+		if !c.processError(err) {
+			return
+		}
+	}
+	return
+}
+
+func (c *TokenAutoUpdateClient) PromLabels(project, metricStore string, matches []string, start, end time.Time) (labels []string, err error) {
+	for i := 0; i < c.maxTryTimes; i++ {
+		labels, err = c.logClient.PromLabels(project, metricStore, matches, start, end)
+		if !c.processError(err) {
+			return
+		}
+	}
+	return
+}
+
+func (c *TokenAutoUpdateClient) PromLabelValues(project, metricStore, label string, matches []string, start, end time.Time) (values []string, err error) {
+	for i := 0; i < c.maxTryTimes; i++ {
+		values, err = c.logClient.PromLabelValues(project, metricStore, label, matches, start, end)
+		if !c.processError(err) {
+			return
+		}
+	}
+	return
+}
+
 func (c *TokenAutoUpdateClient) UpdateProjectPolicy(project, policy string) (err error) {
 	for i := 0; i < c.maxTryTimes; i++ {
 		err = c.logClient.UpdateProjectPolicy(project, policy)